@@ -0,0 +1,76 @@
+package chat
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/troylelandshields/hardconversations/logger"
+	"github.com/troylelandshields/hardconversations/sources"
+)
+
+const citationInstruction = `
+###
+
+Each piece of information above starts with a label in square brackets. After your answer, add a new line that starts with "Sources:" followed by a comma-separated list of the labels of the information you used, or "Sources: none". Do not put the labels anywhere else in your answer.`
+
+var (
+	citationLine   = regexp.MustCompile(`(?i)(?:^|\n)[ \t]*sources:[ \t]*([^\n]*?)\s*$`)
+	unsafeLabelChr = regexp.MustCompile(`[\[\],\n\r]`)
+)
+
+// citationLabels returns a unique label for each source: its Identifier if it has a usable one, otherwise "source-N". Labels are
+// matched case-insensitively, so they are unique regardless of case.
+func citationLabels(usedSources []sources.TextEmbedding) []string {
+	labels := make([]string, len(usedSources))
+	seen := map[string]bool{}
+	for i, s := range usedSources {
+		label := strings.TrimSpace(s.Identifier)
+		if label == "" || unsafeLabelChr.MatchString(label) || strings.EqualFold(label, "none") {
+			label = "source-" + strconv.Itoa(i+1)
+		}
+		base := label
+		for n := 2; seen[strings.ToLower(label)]; n++ {
+			label = base + "-" + strconv.Itoa(n)
+		}
+		seen[strings.ToLower(label)] = true
+		labels[i] = label
+	}
+	return labels
+}
+
+// extractCitations removes the "Sources:" line from the end of an answer, if its last line is one, and returns the answer and the
+// sources it cites. "Sources:" lines elsewhere are part of the answer. Labels that don't match a source are ignored.
+func extractCitations(answer string, usedSources []sources.TextEmbedding, labels []string) (string, []sources.TextEmbedding) {
+	match := citationLine.FindStringSubmatchIndex(answer)
+	if match == nil {
+		return answer, nil
+	}
+	cited := answer[match[2]:match[3]]
+	answer = strings.TrimSpace(answer[:match[0]])
+
+	byLabel := map[string]int{}
+	for i, label := range labels {
+		byLabel[strings.ToLower(label)] = i
+	}
+
+	var citedSources []sources.TextEmbedding
+	used := map[int]bool{}
+	for _, label := range strings.Split(cited, ",") {
+		label = strings.ToLower(strings.Trim(strings.TrimSpace(label), "[]."))
+		if label == "" || label == "none" {
+			continue
+		}
+		i, ok := byLabel[label]
+		if !ok {
+			logger.Debugf("Answer cited unknown source %q", label)
+			continue
+		}
+		if !used[i] {
+			used[i] = true
+			citedSources = append(citedSources, usedSources[i])
+		}
+	}
+
+	return answer, citedSources
+}
@@ -0,0 +1,97 @@
+package chat
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/troylelandshields/hardconversations/sources"
+)
+
+func TestCitations(t *testing.T) {
+	used := []sources.TextEmbedding{
+		{Identifier: "resume-1", Text: "Alice knows Go", Metadata: map[string]string{"name": "Alice"}},
+		{Identifier: "", Text: "Bob knows Rust"},
+		{Identifier: "resume-1", Text: "Alice knows SQL"},
+		{Identifier: "rules[2]", Text: "Be nice"},
+		{Identifier: "Resume-1", Text: "Alice knows Rust"},
+	}
+
+	labels := citationLabels(used)
+	wantLabels := []string{"resume-1", "source-2", "resume-1-2", "source-4", "Resume-1-3"}
+	if !reflect.DeepEqual(labels, wantLabels) {
+		t.Fatalf("citationLabels() = %v, want %v", labels, wantLabels)
+	}
+
+	thread := &Thread{config: NewConfig(WithCitations())}
+	rendered, _, _, err := thread.renderSources(used[:2], 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "[resume-1] Alice knows Go\n[source-2] Bob knows Rust"; rendered != want {
+		t.Errorf("renderSources() = %q, want %q", rendered, want)
+	}
+
+	tests := []struct {
+		name       string
+		answer     string
+		wantAnswer string
+		wantCited  []sources.TextEmbedding
+		text       bool // the answer isn't parsed
+	}{
+		{
+			name:       "typed answer with citations",
+			answer:     "{\"name\": \"Alice\"}\nSources: [resume-1], source-4, unknown, resume-1",
+			wantAnswer: "{\"name\": \"Alice\"}",
+			wantCited:  []sources.TextEmbedding{used[0], used[3]},
+		},
+		{
+			name:       "labels in a different case",
+			answer:     "42\nsources: RESUME-1-3",
+			wantAnswer: "42",
+			wantCited:  []sources.TextEmbedding{used[4]},
+		},
+		{
+			name:       "sources line that isn't last",
+			answer:     "Sources: resume-1 and the rules\nsay to be nice",
+			wantAnswer: "Sources: resume-1 and the rules\nsay to be nice",
+			text:       true,
+		},
+		{
+			name:       "no sources used",
+			answer:     "42\nSources: none",
+			wantAnswer: "42",
+		},
+		{
+			name:       "no citation line",
+			answer:     "42",
+			wantAnswer: "42",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			answer, cited := extractCitations(tt.answer, used, labels)
+			if answer != tt.wantAnswer {
+				t.Errorf("extractCitations() answer = %q, want %q", answer, tt.wantAnswer)
+			}
+			if !reflect.DeepEqual(cited, tt.wantCited) {
+				t.Errorf("extractCitations() cited = %+v, want %+v", cited, tt.wantCited)
+			}
+
+			if tt.text {
+				return
+			}
+			var v interface{}
+			if tt.wantAnswer[0] == '{' {
+				var result struct{ Name string }
+				v = &result
+			} else {
+				var result int
+				v = &result
+			}
+			if err := Parse(answer, v); err != nil {
+				t.Errorf("Parse() error = %v", err)
+			}
+		})
+	}
+}
@@ -19,14 +19,20 @@ func NewClient(openAIKey string, instruction string, opt ...ConfigOption) *Clien
 
 	systemMessage := fmt.Sprintf(baseSystemMessage, instruction)
 
+	config := NewConfig(opt...)
+
+	tokenizer := tokens.ForModel(config.Model)
+	managerOpts := append(config.managerOptions(openAIClient, nil), sources.WithTokenizer(tokenizer))
+
 	return &Client{
 		ai: openAIClient,
 		Thread: &Thread{
-			ai:                  openAIClient,
-			config:              NewConfig(opt...),
-			systemMessage:       systemMessage,
-			systemMessageTokens: tokens.MustCount(systemMessage),
-			Manager:             sources.New(openAIClient),
+			id:            newThreadID(),
+			ai:            openAIClient,
+			config:        config,
+			systemMessage: systemMessage,
+			tokenizer:     tokenizer,
+			Manager:       sources.New(openAIClient, managerOpts...),
 		},
 	}
 }
@@ -1,36 +1,106 @@
 package chat
 
 import (
+	"reflect"
+	"text/template"
+	"time"
+
 	gogpt "github.com/sashabaranov/go-openai"
+	"github.com/troylelandshields/hardconversations/sources"
 )
 
 type Config struct {
-	MaxTotalTokens    int // defaults to 4000
-	MaxResponseTokens int // defaults to 300
-	MaxHistoryTokens  int // defaults to 1000
+	// Token limits default to the model's context window, and up to a quarter of it for each of the response and the history
+	// (see LookupModel); models that aren't registered default to 4000, 300 and 1000.
+	MaxTotalTokens    int // the prompt, counted as the API does, plus MaxResponseTokens never exceed it
+	MaxResponseTokens int
+	MaxHistoryTokens  int
+	// MaxSourceTokens caps the tokens of the sources in each prompt, so a model with a large context window isn't sent (and
+	// doesn't bill for) as many sources as fit in it; defaults to 4000. A negative value means sources are only limited by
+	// MaxTotalTokens.
+	MaxSourceTokens int
 
 	// ChatRequest options
 	Model       string  // defaults to gogpt.GPT3Dot5Turbo
 	Temperature float64 // defaults to 0, max 2
 	UserID      string  // defaults to ""
 
+	// InputInspector is called with every rendered question input before it is sent; returning an error aborts the question.
+	InputInspector func(RenderedInput) error // defaults to nil
+
+	// Embeddings options.
+	EmbeddingModel      string           // defaults to gogpt.SmallEmbedding3
+	EmbeddingDimensions int              // defaults to 0, which is the model's default
+	Embedder            sources.Embedder // defaults to nil; if set, EmbeddingModel and EmbeddingDimensions are ignored
+
+	// EmbeddingCache is used to avoid re-embedding the same source text and prompts; defaults to an in-memory LRU cache, and
+	// to the parent's cache for threads created with NewThread.
+	EmbeddingCache sources.EmbeddingCache
+
+	// Chunking options. Sources can override the Chunker with sources.WithChunker.
+	MaxTokensChunkSize int                     // defaults to 2048
+	Chunker            sources.Chunker         // defaults to nil; if set, MaxTokensChunkSize is ignored
+	ChunkExpansion     *sources.ChunkExpansion // defaults to nil, which uses selected chunks as they are
+
+	// Fusion makes sources rank texts by both BM25 keyword matching and embeddings; defaults to nil, which only uses embeddings.
+	// Sources can override it with sources.WithFusion.
+	Fusion *sources.Fusion
+
+	// Diversity re-ranks relevant sources with maximal marginal relevance, between 0 (the default, only relevance) and 1.
+	Diversity float64
+
+	// Sources are fetched concurrently. Sources can override the timeout with sources.WithTimeout.
+	SourceParallelism int           // defaults to 8
+	SourceTimeout     time.Duration // defaults to 0, which means no timeout
+
+	// Citations labels each source with its Identifier and asks the model to list the labels it used, which are returned in
+	// Metadata.CitedSources; defaults to false.
+	Citations bool
+
+	// SourceTemplate renders the source texts, grouped into []SourceSection, into the system message; defaults to DefaultSourceTemplate.
+	SourceTemplate *template.Template
+	// SourceHeader returns the header of a source text in a section with a title; defaults to the text's Identifier.
+	SourceHeader func(sources.TextEmbedding) string
+
+	// RetrievalQuery is what relevant sources are found with; defaults to QueryFullPrompt. Only used if UseEmbeddings is true.
+	RetrievalQuery QueryMode
+	// QueryAggregation is how queries too long to embed at once are compared to sources; defaults to sources.QueryFirstChunk.
+	QueryAggregation sources.QueryAggregation
+	// Diagnostics adds a report of every candidate source text and why it was or wasn't used to each response's Metadata.
+	Diagnostics bool // defaults to false
+	// SourceFilters leave out source texts that don't match all of them; they are also passed to providers in their SourceQuery.
+	SourceFilters []sources.MetadataFilter
+
 	// TODO: support
 	UseEmbeddings             bool    // defaults to false
 	CosineSimilarityThreshold float64 // defaults to 0.7, must be between 0 and 1.
-	// MaxTokensChunkSize        int // TODO: figure out chunking
+
+	// modelDefaults records which token limits are the defaults of Model rather than set, so threads with a different model
+	// get that model's defaults for them
+	modelDefaults tokenLimits
+}
+
+type tokenLimits struct {
+	total, response, history bool
 }
 
 // NewConfig returns a new Config with default values.
 func NewConfig(opt ...ConfigOption) Config {
 	defaults := &Config{
-		MaxTotalTokens:    4000,
-		MaxResponseTokens: 300,
-		MaxHistoryTokens:  1000,
-
 		Model:       gogpt.GPT3Dot5Turbo,
 		Temperature: 0,
 		UserID:      "",
 
+		EmbeddingModel: string(gogpt.SmallEmbedding3),
+
+		MaxSourceTokens: defaultMaxSourceTokens,
+
+		MaxTokensChunkSize: 2048,
+
+		SourceParallelism: 8,
+
+		RetrievalQuery: QueryFullPrompt,
+
 		UseEmbeddings:             false,
 		CosineSimilarityThreshold: 0.7,
 	}
@@ -39,26 +109,141 @@ func NewConfig(opt ...ConfigOption) Config {
 		o(defaults)
 	}
 
+	total, response, history := modelTokenDefaults(defaults.Model)
+	if defaults.MaxTotalTokens == 0 {
+		defaults.MaxTotalTokens = total
+		defaults.modelDefaults.total = true
+	}
+	if defaults.MaxResponseTokens == 0 {
+		defaults.MaxResponseTokens = response
+		defaults.modelDefaults.response = true
+	}
+	if defaults.MaxHistoryTokens == 0 {
+		defaults.MaxHistoryTokens = history
+		defaults.modelDefaults.history = true
+	}
+
 	return *defaults
 }
 
+// withModelDefaults returns c with the token limits that were defaulted from the parent's model replaced by the defaults of
+// c.Model, for threads that use a different model than their parent. Limits set with an option are kept, even if they happen
+// to equal a default.
+func (c Config) withModelDefaults(parent Config) Config {
+	// options that set the fields directly rather than with the With functions don't clear modelDefaults
+	c.modelDefaults.total = c.modelDefaults.total && c.MaxTotalTokens == parent.MaxTotalTokens
+	c.modelDefaults.response = c.modelDefaults.response && c.MaxResponseTokens == parent.MaxResponseTokens
+	c.modelDefaults.history = c.modelDefaults.history && c.MaxHistoryTokens == parent.MaxHistoryTokens
+
+	total, response, history := modelTokenDefaults(c.Model)
+	if c.modelDefaults.total {
+		c.MaxTotalTokens = total
+	}
+	if c.modelDefaults.response {
+		c.MaxResponseTokens = response
+	}
+	if c.modelDefaults.history {
+		c.MaxHistoryTokens = history
+	}
+	return c
+}
+
+// managerOptions returns the options of the sources.Manager for c. If parent is not nil, only the options that differ from
+// parent are returned, for the Manager of a thread that inherits the rest from its parent's Manager.
+func (c Config) managerOptions(ai *gogpt.Client, parent *Config) []sources.ManagerOption {
+	var p Config
+	if parent != nil {
+		p = *parent
+	}
+	changed := func(v, parentValue interface{}) bool {
+		return parent == nil || !sameValue(v, parentValue)
+	}
+
+	var opts []sources.ManagerOption
+	if changed(c.Embedder, p.Embedder) || changed(c.EmbeddingModel, p.EmbeddingModel) || changed(c.EmbeddingDimensions, p.EmbeddingDimensions) {
+		embedder := c.Embedder
+		if embedder == nil {
+			embedder = sources.NewOpenAIEmbedder(ai, c.EmbeddingModel, c.EmbeddingDimensions)
+		}
+		opts = append(opts, sources.WithDefaultEmbedder(embedder))
+	}
+	if changed(c.Chunker, p.Chunker) || changed(c.MaxTokensChunkSize, p.MaxTokensChunkSize) {
+		chunker := c.Chunker
+		if chunker == nil {
+			chunker = sources.TokenChunker{Size: c.MaxTokensChunkSize}
+		}
+		opts = append(opts, sources.WithDefaultChunker(chunker))
+	}
+	if changed(c.SourceParallelism, p.SourceParallelism) {
+		opts = append(opts, sources.WithParallelism(c.SourceParallelism))
+	}
+	if changed(c.SourceTimeout, p.SourceTimeout) {
+		opts = append(opts, sources.WithDefaultTimeout(c.SourceTimeout))
+	}
+	if changed(c.Fusion, p.Fusion) {
+		if c.Fusion != nil {
+			opts = append(opts, sources.WithDefaultFusion(*c.Fusion))
+		} else {
+			opts = append(opts, sources.WithoutDefaultFusion())
+		}
+	}
+	if changed(c.ChunkExpansion, p.ChunkExpansion) {
+		var expansion sources.ChunkExpansion
+		if c.ChunkExpansion != nil {
+			expansion = *c.ChunkExpansion
+		}
+		opts = append(opts, sources.WithDefaultChunkExpansion(expansion))
+	}
+	if changed(c.QueryAggregation, p.QueryAggregation) {
+		opts = append(opts, sources.WithQueryAggregation(c.QueryAggregation))
+	}
+	if changed(c.Diversity, p.Diversity) {
+		opts = append(opts, sources.WithMMR(c.Diversity))
+	}
+	if c.EmbeddingCache != nil && changed(c.EmbeddingCache, p.EmbeddingCache) {
+		opts = append(opts, sources.WithEmbeddingCache(c.EmbeddingCache))
+	}
+	return opts
+}
+
+// sameValue reports whether a and b are the same setting: values that can be compared, like embedders and caches behind
+// pointers, must be equal, and the others must be deeply equal.
+func sameValue(a, b interface{}) bool {
+	if a == nil || b == nil || reflect.TypeOf(a) != reflect.TypeOf(b) {
+		return a == b
+	}
+	if reflect.TypeOf(a).Comparable() {
+		return a == b
+	}
+	return reflect.DeepEqual(a, b)
+}
+
 type ConfigOption func(*Config)
 
 func WithMaxTotalTokens(maxTotalTokens int) ConfigOption {
 	return func(c *Config) {
 		c.MaxTotalTokens = maxTotalTokens
+		c.modelDefaults.total = false
 	}
 }
 
 func WithMaxResponseTokens(maxResponseTokens int) ConfigOption {
 	return func(c *Config) {
 		c.MaxResponseTokens = maxResponseTokens
+		c.modelDefaults.response = false
 	}
 }
 
 func WithMaxHistoryTokens(maxHistoryTokens int) ConfigOption {
 	return func(c *Config) {
 		c.MaxHistoryTokens = maxHistoryTokens
+		c.modelDefaults.history = false
+	}
+}
+
+func WithMaxSourceTokens(maxSourceTokens int) ConfigOption {
+	return func(c *Config) {
+		c.MaxSourceTokens = maxSourceTokens
 	}
 }
 
@@ -80,12 +265,94 @@ func WithUserID(userID string) ConfigOption {
 	}
 }
 
+// WithInputInspector sets a function that can inspect (e.g. log, or reject if it uses too many tokens) every rendered question input before it is sent.
+func WithInputInspector(inspector func(RenderedInput) error) ConfigOption {
+	return func(c *Config) {
+		c.InputInspector = inspector
+	}
+}
+
+// WithCitations makes answers cite the sources they used; see Metadata.CitedSources. The citations are removed from the
+// answer before it is parsed.
+func WithCitations() ConfigOption {
+	return func(c *Config) {
+		c.Citations = true
+	}
+}
+
+// WithSourceTemplate sets the template that renders source texts into the system message. It is executed with a []SourceSection.
+func WithSourceTemplate(tmpl *template.Template) ConfigOption {
+	return func(c *Config) {
+		c.SourceTemplate = tmpl
+	}
+}
+
+// WithSourceHeader sets how the header of each source text is built, e.g. from its Metadata, in sections that have a title
+// (see sources.WithName and sources.WithDescription).
+func WithSourceHeader(header func(sources.TextEmbedding) string) ConfigOption {
+	return func(c *Config) {
+		c.SourceHeader = header
+	}
+}
+
+// WithRetrievalQuery sets what relevant sources are found with, e.g. QueryInput to only use the question's input, or
+// QueryHypotheticalAnswer to have the model write an answer to search with. Only used if UseEmbeddings is true.
+func WithRetrievalQuery(mode QueryMode) ConfigOption {
+	return func(c *Config) {
+		c.RetrievalQuery = mode
+	}
+}
+
+// WithDiagnostics adds a RetrievalReport to the Metadata of every response, e.g. to print with Metadata.RetrievalReport.Print
+// when an answer is wrong.
+func WithDiagnostics() ConfigOption {
+	return func(c *Config) {
+		c.Diagnostics = true
+	}
+}
+
+// WithSourceFilters adds filters that source texts must match to be used, e.g. sources.MetadataEquals("state", "UT").
+func WithSourceFilters(filters ...sources.MetadataFilter) ConfigOption {
+	return func(c *Config) {
+		c.SourceFilters = append(c.SourceFilters[:len(c.SourceFilters):len(c.SourceFilters)], filters...)
+	}
+}
+
+// WithQueryAggregation sets how queries that are too long to embed at once are compared to sources.
+func WithQueryAggregation(aggregation sources.QueryAggregation) ConfigOption {
+	return func(c *Config) {
+		c.QueryAggregation = aggregation
+	}
+}
+
 func WithUseEmbeddings(useEmbeddings bool) ConfigOption {
 	return func(c *Config) {
 		c.UseEmbeddings = useEmbeddings
 	}
 }
 
+// WithEmbeddingModel sets the OpenAI model used to embed sources and prompts, and optionally the number of dimensions to shorten the embeddings to (0 for the model's default).
+func WithEmbeddingModel(model string, dimensions int) ConfigOption {
+	return func(c *Config) {
+		c.EmbeddingModel = model
+		c.EmbeddingDimensions = dimensions
+	}
+}
+
+// WithEmbedder sets the Embedder used to embed sources and prompts, e.g. sources.HashingEmbedder to run without network access.
+func WithEmbedder(embedder sources.Embedder) ConfigOption {
+	return func(c *Config) {
+		c.Embedder = embedder
+	}
+}
+
+// WithEmbeddingCache sets the cache used for embeddings of sources and prompts, e.g. sources.NewDiskEmbeddingCache to persist them between runs.
+func WithEmbeddingCache(cache sources.EmbeddingCache) ConfigOption {
+	return func(c *Config) {
+		c.EmbeddingCache = cache
+	}
+}
+
 // WithCosineSimilarityThreshold changes the minimum value required to include a source in the chat request.
 // The cosine similarity between a source and the prompt (times source weight) must meet this minimum or it will be ignored.
 // Only used if UseEmbeddings is true.
@@ -95,9 +362,54 @@ func WithCosineSimilarityThreshold(cosineSimilarityThreshold float64) ConfigOpti
 	}
 }
 
-// TODO:
-// func WithMaxTokensChunkSize(maxTokensChunkSize int) ConfigOption {
-// 	return func(c *Config) {
-// 		c.MaxTokensChunkSize = maxTokensChunkSize
-// 	}
-// }
+// WithMaxTokensChunkSize sets the max number of tokens in each chunk of source text; longer source texts are split by tokens.
+func WithMaxTokensChunkSize(maxTokensChunkSize int) ConfigOption {
+	return func(c *Config) {
+		c.MaxTokensChunkSize = maxTokensChunkSize
+	}
+}
+
+// WithChunkExpansion sets how selected chunks of longer source texts are stitched together or expanded with the text around them.
+// Only used if UseEmbeddings is true.
+func WithChunkExpansion(expansion sources.ChunkExpansion) ConfigOption {
+	return func(c *Config) {
+		c.ChunkExpansion = &expansion
+	}
+}
+
+// WithHybridSearch makes sources rank texts by both BM25 keyword matching and embeddings, e.g. so exact matches on names or IDs
+// aren't missed. Only used if UseEmbeddings is true.
+func WithHybridSearch(fusion sources.Fusion) ConfigOption {
+	return func(c *Config) {
+		c.Fusion = &fusion
+	}
+}
+
+// WithDiversity re-ranks relevant sources so near-duplicate texts don't fill the token budget; diversity is between 0 and 1,
+// and higher values prefer texts that are less similar to the ones already used. Only used if UseEmbeddings is true.
+func WithDiversity(diversity float64) ConfigOption {
+	return func(c *Config) {
+		c.Diversity = diversity
+	}
+}
+
+// WithSourceParallelism limits how many sources are fetched at the same time; 0 or less means no limit.
+func WithSourceParallelism(n int) ConfigOption {
+	return func(c *Config) {
+		c.SourceParallelism = n
+	}
+}
+
+// WithSourceTimeout sets how long each source can take to return its texts.
+func WithSourceTimeout(d time.Duration) ConfigOption {
+	return func(c *Config) {
+		c.SourceTimeout = d
+	}
+}
+
+// WithChunker sets how source texts are split into chunks, e.g. sources.MarkdownChunker{Size: 500, Overlap: 50}.
+func WithChunker(chunker sources.Chunker) ConfigOption {
+	return func(c *Config) {
+		c.Chunker = chunker
+	}
+}
@@ -0,0 +1,184 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/pkg/errors"
+	gogpt "github.com/sashabaranov/go-openai"
+)
+
+// Sentinel errors that can be used with errors.Is to check which kind of failure occurred; the typed errors below
+// can be used with errors.As to get more details.
+var (
+	ErrRateLimited           = errors.New("rate limited")
+	ErrQuotaExceeded         = errors.New("quota exceeded")
+	ErrContextLengthExceeded = errors.New("context length exceeded")
+	ErrAuthentication        = errors.New("authentication failed")
+	ErrTransport             = errors.New("transport failure")
+	ErrRefused               = errors.New("request refused")
+	ErrParse                 = errors.New("failed to parse answer")
+	ErrValidation            = errors.New("answer failed validation")
+	ErrUnsupportedFeature    = errors.New("model does not support feature")
+)
+
+// RateLimitError is returned when the API rejects a request because of rate limits.
+type RateLimitError struct {
+	Err error
+}
+
+func (e *RateLimitError) Error() string        { return "rate limited: " + e.Err.Error() }
+func (e *RateLimitError) Unwrap() error        { return e.Err }
+func (e *RateLimitError) Is(target error) bool { return target == ErrRateLimited }
+
+// QuotaError is returned when the account has run out of quota or credits. Unlike rate limits, retrying won't help.
+type QuotaError struct {
+	Err error
+}
+
+func (e *QuotaError) Error() string        { return "quota exceeded: " + e.Err.Error() }
+func (e *QuotaError) Unwrap() error        { return e.Err }
+func (e *QuotaError) Is(target error) bool { return target == ErrQuotaExceeded }
+
+// ContextLengthError is returned when the request was too large for the model's context window.
+type ContextLengthError struct {
+	Err error
+}
+
+func (e *ContextLengthError) Error() string        { return "context length exceeded: " + e.Err.Error() }
+func (e *ContextLengthError) Unwrap() error        { return e.Err }
+func (e *ContextLengthError) Is(target error) bool { return target == ErrContextLengthExceeded }
+
+// AuthenticationError is returned when the API key is missing, invalid or not allowed to use the model.
+type AuthenticationError struct {
+	Err error
+}
+
+func (e *AuthenticationError) Error() string        { return "authentication failed: " + e.Err.Error() }
+func (e *AuthenticationError) Unwrap() error        { return e.Err }
+func (e *AuthenticationError) Is(target error) bool { return target == ErrAuthentication }
+
+// TransportError is returned for any other failure talking to the API, e.g. network errors or server errors.
+// StatusCode is 0 if no response was received.
+type TransportError struct {
+	StatusCode int
+	Err        error
+}
+
+func (e *TransportError) Error() string        { return "transport failure: " + e.Err.Error() }
+func (e *TransportError) Unwrap() error        { return e.Err }
+func (e *TransportError) Is(target error) bool { return target == ErrTransport }
+
+// RefusalError is returned when the model answers with "Error: " as instructed by the system message, meaning it could not fulfill the request.
+type RefusalError struct {
+	Reason string
+}
+
+func (e *RefusalError) Error() string {
+	return fmt.Sprintf("Unable to process request with error: %s", e.Reason)
+}
+func (e *RefusalError) Is(target error) bool { return target == ErrRefused }
+
+// ParseError is returned when the answer could not be parsed into the expected output type. Raw is the text that was received.
+type ParseError struct {
+	Raw string
+	Err error
+}
+
+func (e *ParseError) Error() string        { return "failed to parse answer: " + e.Err.Error() }
+func (e *ParseError) Unwrap() error        { return e.Err }
+func (e *ParseError) Is(target error) bool { return target == ErrParse }
+
+// ValidationError is returned when the parsed answer implements Validator and its Validate method returned an error.
+type ValidationError struct {
+	Raw string
+	Err error
+}
+
+func (e *ValidationError) Error() string        { return "answer failed validation: " + e.Err.Error() }
+func (e *ValidationError) Unwrap() error        { return e.Err }
+func (e *ValidationError) Is(target error) bool { return target == ErrValidation }
+
+// UnsupportedFeatureError is returned before a request is sent when it needs a feature (e.g. images) the configured model doesn't have.
+type UnsupportedFeatureError struct {
+	Model   string
+	Feature string
+}
+
+func (e *UnsupportedFeatureError) Error() string {
+	return fmt.Sprintf("model %s does not support %s", e.Model, e.Feature)
+}
+func (e *UnsupportedFeatureError) Is(target error) bool { return target == ErrUnsupportedFeature }
+
+// Validator can be implemented by output types to reject answers that parsed correctly but are not acceptable.
+type Validator interface {
+	Validate() error
+}
+
+// IsRetryable reports whether the request that caused err might succeed if it is tried again unchanged. Canceled
+// requests and expired deadlines aren't retryable.
+func IsRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var transportErr *TransportError
+	if errors.As(err, &transportErr) {
+		return transportErr.StatusCode == 0 || transportErr.StatusCode >= http.StatusInternalServerError
+	}
+	return errors.Is(err, ErrRateLimited)
+}
+
+// classifySourceError converts an error from retrieving sources into one of the typed errors above if it came from the OpenAI
+// client, e.g. while creating embeddings. Other errors, e.g. from source providers, are returned as they are.
+func classifySourceError(err error) error {
+	var apiErr *gogpt.APIError
+	var reqErr *gogpt.RequestError
+	if errors.As(err, &apiErr) || errors.As(err, &reqErr) {
+		return classifyAPIError(err)
+	}
+	return err
+}
+
+// classifyAPIError converts an error returned by the OpenAI client into one of the typed errors above. Errors from a canceled
+// context or an expired deadline are returned as they are so callers can check for them.
+func classifyAPIError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+
+	var apiErr *gogpt.APIError
+	if errors.As(err, &apiErr) {
+		switch code, _ := apiErr.Code.(string); code {
+		case "context_length_exceeded":
+			return &ContextLengthError{Err: err}
+		case "insufficient_quota":
+			return &QuotaError{Err: err}
+		}
+
+		switch apiErr.HTTPStatusCode {
+		case http.StatusTooManyRequests:
+			return &RateLimitError{Err: err}
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return &AuthenticationError{Err: err}
+		}
+		return &TransportError{StatusCode: apiErr.HTTPStatusCode, Err: err}
+	}
+
+	var reqErr *gogpt.RequestError
+	if errors.As(err, &reqErr) {
+		switch reqErr.HTTPStatusCode {
+		case http.StatusTooManyRequests:
+			return &RateLimitError{Err: err}
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return &AuthenticationError{Err: err}
+		}
+		return &TransportError{StatusCode: reqErr.HTTPStatusCode, Err: err}
+	}
+
+	return &TransportError{Err: err}
+}
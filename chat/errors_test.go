@@ -0,0 +1,79 @@
+package chat
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/pkg/errors"
+	gogpt "github.com/sashabaranov/go-openai"
+)
+
+func TestClassifyAPIError(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		want      error
+		retryable bool
+	}{
+		{
+			name:      "rate limit",
+			err:       &gogpt.APIError{HTTPStatusCode: http.StatusTooManyRequests, Code: "rate_limit_exceeded"},
+			want:      ErrRateLimited,
+			retryable: true,
+		},
+		{
+			name: "quota",
+			err:  &gogpt.APIError{HTTPStatusCode: http.StatusTooManyRequests, Code: "insufficient_quota"},
+			want: ErrQuotaExceeded,
+		},
+		{
+			name: "context length",
+			err:  &gogpt.APIError{HTTPStatusCode: http.StatusBadRequest, Code: "context_length_exceeded"},
+			want: ErrContextLengthExceeded,
+		},
+		{
+			name:      "server error",
+			err:       &gogpt.APIError{HTTPStatusCode: http.StatusBadGateway},
+			want:      ErrTransport,
+			retryable: true,
+		},
+		{
+			name: "canceled",
+			err:  errors.Wrap(context.Canceled, "error sending request"),
+			want: context.Canceled,
+		},
+		{
+			name: "deadline",
+			err:  context.DeadlineExceeded,
+			want: context.DeadlineExceeded,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := classifyAPIError(tt.err)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("classifyAPIError() = %v, want %v", err, tt.want)
+			}
+			if errors.Is(err, ErrTransport) != (tt.want == ErrTransport) {
+				t.Errorf("classifyAPIError() = %v, a transport error is only wanted for %v", err, ErrTransport)
+			}
+			if got := IsRetryable(err); got != tt.retryable {
+				t.Errorf("IsRetryable() = %t, want %t", got, tt.retryable)
+			}
+		})
+	}
+}
+
+func TestClassifySourceError(t *testing.T) {
+	apiErr := errors.Wrap(&gogpt.APIError{HTTPStatusCode: http.StatusTooManyRequests, Code: "rate_limit_exceeded"}, "error creating embeddings")
+	if err := classifySourceError(apiErr); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("classifySourceError() = %v, want %v", err, ErrRateLimited)
+	}
+
+	providerErr := errors.New("error reading source")
+	if err := classifySourceError(providerErr); err != providerErr {
+		t.Errorf("classifySourceError() = %v, want %v", err, providerErr)
+	}
+}
@@ -0,0 +1,476 @@
+package chat
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/troylelandshields/hardconversations/internal/tokens"
+	"gopkg.in/yaml.v3"
+)
+
+// InputFormat controls how struct, slice and map inputs are rendered into the prompt. Inputs that implement PromptInput
+// and basic types are always rendered the same way regardless of the format.
+type InputFormat string
+
+const (
+	InputFormatJSON        InputFormat = "json"         // indented JSON; the default
+	InputFormatCompactJSON InputFormat = "compact_json" // JSON without any whitespace
+	InputFormatYAML        InputFormat = "yaml"         // YAML, with field descriptions as comments
+	InputFormatMarkdown    InputFormat = "markdown"     // nested markdown list of "**key**: value" items
+	InputFormatXML         InputFormat = "xml"          // XML-like tagged sections, with field descriptions as attributes
+)
+
+// InputFormats lists all of the supported input formats.
+var InputFormats = []InputFormat{InputFormatJSON, InputFormatCompactJSON, InputFormatYAML, InputFormatMarkdown, InputFormatXML}
+
+// Valid returns true if f is empty (meaning the default) or one of InputFormats.
+func (f InputFormat) Valid() bool {
+	if f == "" {
+		return true
+	}
+	for _, format := range InputFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// RenderedInput is an input after it has been converted to text for the prompt.
+type RenderedInput struct {
+	Format InputFormat // empty if the input was rendered from multiple named inputs
+	Text   string
+	Tokens int
+}
+
+// NamedInput is one of a question's inputs, rendered as its own labeled section of the prompt.
+type NamedInput struct {
+	Name     string
+	Value    interface{}
+	Format   InputFormat
+	Optional bool // optional inputs are left out of the prompt when they are the zero value
+}
+
+// RenderInput converts v to text in the given format and counts its tokens.
+//
+// Struct fields are named by their JSON name and can be controlled with the hardc-input tag:
+//
+//	`hardc-input:"-"`                           omits the field
+//	`hardc-input:"Full Name"`                   renames the field
+//	`hardc-input:",desc=the candidate's name"`  describes the field (in the yaml, markdown and xml formats)
+func RenderInput(v interface{}, format InputFormat) (RenderedInput, error) {
+	if format == "" {
+		format = InputFormatJSON
+	}
+	if !format.Valid() {
+		return RenderedInput{}, errors.Errorf("unsupported input format: %s", format)
+	}
+
+	text, err := renderInput(v, format)
+	if err != nil {
+		return RenderedInput{}, err
+	}
+
+	tokenCount, err := tokens.Count(text)
+	if err != nil {
+		return RenderedInput{}, err
+	}
+
+	return RenderedInput{
+		Format: format,
+		Text:   text,
+		Tokens: tokenCount,
+	}, nil
+}
+
+// RenderInputs renders each of the inputs in its format as a section labeled with its name, e.g. "candidate:\n{...}".
+func RenderInputs(inputs ...NamedInput) (RenderedInput, error) {
+	var sections []string
+	for _, input := range inputs {
+		if input.Optional && (input.Value == nil || reflect.ValueOf(input.Value).IsZero()) {
+			continue
+		}
+
+		rendered, err := RenderInput(input.Value, input.Format)
+		if err != nil {
+			return RenderedInput{}, errors.Wrapf(err, "failed to render input %s", input.Name)
+		}
+		sections = append(sections, input.Name+":\n"+rendered.Text)
+	}
+
+	text := strings.Join(sections, "\n\n")
+	tokenCount, err := tokens.Count(text)
+	if err != nil {
+		return RenderedInput{}, err
+	}
+
+	return RenderedInput{
+		Text:   text,
+		Tokens: tokenCount,
+	}, nil
+}
+
+func renderInput(v interface{}, format InputFormat) (string, error) {
+	if s, ok := convertBasicInput(v); ok {
+		return s, nil
+	}
+
+	node := buildInputNode(reflect.ValueOf(v))
+
+	switch format {
+	case InputFormatCompactJSON:
+		var buf bytes.Buffer
+		err := node.writeJSON(&buf)
+		return buf.String(), err
+	case InputFormatYAML:
+		yamlNode, err := node.yamlNode()
+		if err != nil {
+			return "", err
+		}
+		b, err := yaml.Marshal(yamlNode)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSuffix(string(b), "\n"), nil
+	case InputFormatMarkdown:
+		var buf bytes.Buffer
+		node.writeMarkdown(&buf, 0)
+		return strings.TrimSuffix(buf.String(), "\n"), nil
+	case InputFormatXML:
+		var buf bytes.Buffer
+		node.writeXML(&buf, "input", "", 0)
+		return strings.TrimSuffix(buf.String(), "\n"), nil
+	}
+
+	var compact bytes.Buffer
+	if err := node.writeJSON(&compact); err != nil {
+		return "", err
+	}
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, compact.Bytes(), "", "    "); err != nil {
+		return "", err
+	}
+	return indented.String(), nil
+}
+
+type inputNodeKind int
+
+const (
+	inputScalar inputNodeKind = iota
+	inputList
+	inputObject
+)
+
+// inputNode is an intermediate representation of an input so that every format applies the same field rules.
+type inputNode struct {
+	kind   inputNodeKind
+	value  interface{} // for scalars
+	items  []inputNode // for lists
+	fields []inputField
+}
+
+type inputField struct {
+	name        string
+	description string
+	node        inputNode
+}
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	promptInputType   = reflect.TypeOf((*PromptInput)(nil)).Elem()
+)
+
+func buildInputNode(v reflect.Value) inputNode {
+	for v.IsValid() && (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return inputNode{kind: inputScalar}
+		}
+		if v.Kind() == reflect.Pointer && implementsAny(v.Type(), promptInputType, jsonMarshalerType, textMarshalerType) {
+			break
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return inputNode{kind: inputScalar}
+	}
+
+	if v.Type().Implements(promptInputType) {
+		return inputNode{kind: inputScalar, value: v.Interface().(PromptInput).PromptInput()}
+	}
+	if implementsAny(v.Type(), jsonMarshalerType, textMarshalerType) {
+		return inputNode{kind: inputScalar, value: v.Interface()}
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		return inputNode{kind: inputObject, fields: structInputFields(v)}
+	case reflect.Map:
+		node := inputNode{kind: inputObject}
+		for _, key := range v.MapKeys() {
+			node.fields = append(node.fields, inputField{
+				name: fmt.Sprint(key.Interface()),
+				node: buildInputNode(v.MapIndex(key)),
+			})
+		}
+		sort.Slice(node.fields, func(i, j int) bool {
+			return node.fields[i].name < node.fields[j].name
+		})
+		return node
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return inputNode{kind: inputScalar, value: v.Interface()}
+		}
+		node := inputNode{kind: inputList}
+		for i := 0; i < v.Len(); i++ {
+			node.items = append(node.items, buildInputNode(v.Index(i)))
+		}
+		return node
+	}
+
+	return inputNode{kind: inputScalar, value: v.Interface()}
+}
+
+func structInputFields(v reflect.Value) []inputField {
+	var fields []inputField
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, omitEmpty, ok := jsonFieldName(field)
+		if !ok {
+			continue
+		}
+
+		fieldValue := v.Field(i)
+		if field.Anonymous && field.Tag.Get("json") == "" && field.Tag.Get("hardc-input") == "" {
+			for fieldValue.Kind() == reflect.Pointer {
+				if fieldValue.IsNil() {
+					break
+				}
+				fieldValue = fieldValue.Elem()
+			}
+			if fieldValue.Kind() == reflect.Struct {
+				fields = append(fields, structInputFields(fieldValue)...)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+
+		rename, description, omit := parseInputTag(field.Tag.Get("hardc-input"))
+		if omit {
+			continue
+		}
+		if rename != "" {
+			name = rename
+		}
+		if omitEmpty && fieldValue.IsZero() {
+			continue
+		}
+
+		fields = append(fields, inputField{
+			name:        name,
+			description: description,
+			node:        buildInputNode(fieldValue),
+		})
+	}
+	return fields
+}
+
+// parseInputTag parses a hardc-input tag of the form "name,desc=description"; the description may contain commas.
+func parseInputTag(tag string) (name string, description string, omit bool) {
+	if tag == "-" {
+		return "", "", true
+	}
+
+	name, rest, _ := strings.Cut(tag, ",")
+	if strings.HasPrefix(rest, "desc=") {
+		description = strings.TrimPrefix(rest, "desc=")
+	}
+
+	return strings.TrimSpace(name), description, false
+}
+
+func implementsAny(t reflect.Type, ifaces ...reflect.Type) bool {
+	for _, iface := range ifaces {
+		if t.Implements(iface) {
+			return true
+		}
+	}
+	return false
+}
+
+// text returns a scalar as plain text; strings are not quoted.
+func (n inputNode) text() (string, error) {
+	if s, ok := n.value.(string); ok {
+		return s, nil
+	}
+
+	b, err := json.Marshal(n.value)
+	if err != nil {
+		return "", err
+	}
+
+	var s string
+	if json.Unmarshal(b, &s) == nil {
+		return s, nil
+	}
+	return string(b), nil
+}
+
+func (n inputNode) writeJSON(buf *bytes.Buffer) error {
+	switch n.kind {
+	case inputList:
+		buf.WriteByte('[')
+		for i, item := range n.items {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := item.writeJSON(buf); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case inputObject:
+		buf.WriteByte('{')
+		for i, field := range n.fields {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			name, err := json.Marshal(field.name)
+			if err != nil {
+				return err
+			}
+			buf.Write(name)
+			buf.WriteByte(':')
+			if err := field.node.writeJSON(buf); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		b, err := json.Marshal(n.value)
+		if err != nil {
+			return err
+		}
+		buf.Write(b)
+	}
+	return nil
+}
+
+func (n inputNode) yamlNode() (*yaml.Node, error) {
+	switch n.kind {
+	case inputList:
+		node := &yaml.Node{Kind: yaml.SequenceNode}
+		for _, item := range n.items {
+			itemNode, err := item.yamlNode()
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, itemNode)
+		}
+		return node, nil
+	case inputObject:
+		node := &yaml.Node{Kind: yaml.MappingNode}
+		for _, field := range n.fields {
+			valueNode, err := field.node.yamlNode()
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content,
+				&yaml.Node{Kind: yaml.ScalarNode, Value: field.name, HeadComment: field.description},
+				valueNode,
+			)
+		}
+		return node, nil
+	}
+
+	node := &yaml.Node{}
+	switch n.value.(type) {
+	case json.Marshaler, encoding.TextMarshaler, []byte:
+		text, err := n.text()
+		if err != nil {
+			return nil, err
+		}
+		return node, node.Encode(text)
+	}
+	return node, node.Encode(n.value)
+}
+
+func (n inputNode) writeMarkdown(buf *bytes.Buffer, depth int) {
+	indent := strings.Repeat("  ", depth)
+	switch n.kind {
+	case inputList:
+		for i, item := range n.items {
+			if item.kind == inputScalar {
+				text, _ := item.text()
+				fmt.Fprintf(buf, "%s- %s\n", indent, text)
+				continue
+			}
+			fmt.Fprintf(buf, "%s- %d.\n", indent, i+1)
+			item.writeMarkdown(buf, depth+1)
+		}
+	case inputObject:
+		for _, field := range n.fields {
+			label := "**" + field.name + "**"
+			if field.description != "" {
+				label += " (" + field.description + ")"
+			}
+			if field.node.kind == inputScalar {
+				text, _ := field.node.text()
+				fmt.Fprintf(buf, "%s- %s: %s\n", indent, label, text)
+				continue
+			}
+			fmt.Fprintf(buf, "%s- %s:\n", indent, label)
+			field.node.writeMarkdown(buf, depth+1)
+		}
+	default:
+		text, _ := n.text()
+		fmt.Fprintf(buf, "%s%s\n", indent, text)
+	}
+}
+
+var invalidTagChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// the XML escapes for element text and for attribute values; newlines are left as they are so the text stays readable
+var (
+	xmlTextEscaper      = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	xmlAttributeEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+)
+
+func (n inputNode) writeXML(buf *bytes.Buffer, name, description string, depth int) {
+	indent := strings.Repeat("  ", depth)
+	tag := invalidTagChars.ReplaceAllString(name, "_")
+
+	fmt.Fprintf(buf, "%s<%s", indent, tag)
+	if description != "" {
+		fmt.Fprintf(buf, ` description="%s"`, xmlAttributeEscaper.Replace(description))
+	}
+
+	if n.kind == inputScalar {
+		text, _ := n.text()
+		fmt.Fprintf(buf, ">%s</%s>\n", xmlTextEscaper.Replace(text), tag)
+		return
+	}
+
+	buf.WriteString(">\n")
+	switch n.kind {
+	case inputList:
+		for _, item := range n.items {
+			item.writeXML(buf, "item", "", depth+1)
+		}
+	case inputObject:
+		for _, field := range n.fields {
+			field.node.writeXML(buf, field.name, field.description, depth+1)
+		}
+	}
+	fmt.Fprintf(buf, "%s</%s>\n", indent, tag)
+}
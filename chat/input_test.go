@@ -0,0 +1,120 @@
+package chat
+
+import (
+	"testing"
+)
+
+type testInputCandidate struct {
+	Name     string   `json:"name" hardc-input:",desc=full name"`
+	Skills   []string `json:"skills"`
+	Email    string   `json:"email" hardc-input:"Contact"`
+	Internal string   `hardc-input:"-"`
+	Notes    string   `json:"notes,omitempty"`
+}
+
+func TestRenderInput(t *testing.T) {
+	input := testInputCandidate{
+		Name:     "Janet Dough",
+		Skills:   []string{"Go", "SQL"},
+		Email:    "j@dough.com",
+		Internal: "secret",
+	}
+
+	tests := []struct {
+		format InputFormat
+		want   string
+	}{
+		{
+			format: InputFormatCompactJSON,
+			want:   `{"name":"Janet Dough","skills":["Go","SQL"],"Contact":"j@dough.com"}`,
+		},
+		{
+			format: InputFormatYAML,
+			want: `# full name
+name: Janet Dough
+skills:
+    - Go
+    - SQL
+Contact: j@dough.com`,
+		},
+		{
+			format: InputFormatMarkdown,
+			want: `- **name** (full name): Janet Dough
+- **skills**:
+  - Go
+  - SQL
+- **Contact**: j@dough.com`,
+		},
+		{
+			format: InputFormatXML,
+			want: `<input>
+  <name description="full name">Janet Dough</name>
+  <skills>
+    <item>Go</item>
+    <item>SQL</item>
+  </skills>
+  <Contact>j@dough.com</Contact>
+</input>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			got, err := RenderInput(input, tt.format)
+			if err != nil {
+				t.Errorf("RenderInput() error = %v", err)
+				return
+			}
+			if got.Text != tt.want {
+				t.Errorf("RenderInput() = %q, want %q", got.Text, tt.want)
+			}
+			if got.Tokens == 0 {
+				t.Errorf("RenderInput() did not count tokens")
+			}
+		})
+	}
+
+	if _, err := RenderInput(input, "toml"); err == nil {
+		t.Errorf("RenderInput() expected error for unknown format")
+	}
+}
+
+func TestRenderInputXMLEscaping(t *testing.T) {
+	input := struct {
+		Query string `json:"query" hardc-input:",desc=a \"quoted\" <b>description</b>"`
+	}{
+		Query: `name = "Janet" && age < 30`,
+	}
+
+	got, err := RenderInput(input, InputFormatXML)
+	if err != nil {
+		t.Fatalf("RenderInput() error = %v", err)
+	}
+	want := `<input>
+  <query description="a &quot;quoted&quot; &lt;b&gt;description&lt;/b&gt;">name = "Janet" &amp;&amp; age &lt; 30</query>
+</input>`
+	if got.Text != want {
+		t.Errorf("RenderInput() = %q, want %q", got.Text, want)
+	}
+}
+
+func TestRenderInputs(t *testing.T) {
+	got, err := RenderInputs(
+		NamedInput{Name: "candidate", Value: testInputCandidate{Name: "Janet Dough"}, Format: InputFormatCompactJSON},
+		NamedInput{Name: "notes", Value: "", Optional: true},
+		NamedInput{Name: "resumeText", Value: "Janet Dough\nj@dough.com"},
+	)
+	if err != nil {
+		t.Errorf("RenderInputs() error = %v", err)
+		return
+	}
+
+	want := `candidate:
+{"name":"Janet Dough","skills":[],"Contact":""}
+
+resumeText:
+Janet Dough
+j@dough.com`
+	if got.Text != want {
+		t.Errorf("RenderInputs() = %q, want %q", got.Text, want)
+	}
+}
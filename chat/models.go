@@ -0,0 +1,179 @@
+package chat
+
+import (
+	"strings"
+	"sync"
+
+	gogpt "github.com/sashabaranov/go-openai"
+)
+
+// Feature is something a model can do beyond plain text chat.
+type Feature string
+
+const (
+	FeatureSystemRole Feature = "system role"
+	FeatureJSONSchema Feature = "JSON schema"
+	FeatureTools      Feature = "tools"
+	FeatureVision     Feature = "vision"
+	FeatureLogprobs   Feature = "logprobs"
+	FeatureStreaming  Feature = "streaming"
+)
+
+// Pricing is what a model costs in USD per million tokens.
+type Pricing struct {
+	Input  float64
+	Output float64
+}
+
+// Cost returns what a request with usage cost in USD.
+func (p Pricing) Cost(usage gogpt.Usage) float64 {
+	return (float64(usage.PromptTokens)*p.Input + float64(usage.CompletionTokens)*p.Output) / 1e6
+}
+
+// ModelProfile describes a model's limits, features and pricing.
+type ModelProfile struct {
+	ContextWindow   int // the most tokens a request and its response can use
+	MaxOutputTokens int // the most tokens a response can use
+	Features        []Feature
+	Pricing         Pricing
+	Reasoning       bool // the model takes max_completion_tokens instead of max_tokens, which include its reasoning tokens
+}
+
+// Supports reports whether the model has feature f.
+func (p ModelProfile) Supports(f Feature) bool {
+	for _, feature := range p.Features {
+		if feature == f {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	allFeatures              = []Feature{FeatureSystemRole, FeatureJSONSchema, FeatureTools, FeatureVision, FeatureLogprobs, FeatureStreaming}
+	textFeatures             = []Feature{FeatureSystemRole, FeatureTools, FeatureLogprobs, FeatureStreaming}
+	reasoningFeatures        = []Feature{FeatureSystemRole, FeatureJSONSchema, FeatureTools, FeatureVision, FeatureStreaming}
+	textReasoningFeatures    = []Feature{FeatureSystemRole, FeatureJSONSchema, FeatureTools, FeatureStreaming}
+	previewReasoningFeatures = []Feature{FeatureStreaming}
+)
+
+var (
+	modelsMu sync.RWMutex
+
+	// modelProfiles maps model name prefixes to their profiles; the longest matching prefix wins, so dated snapshots like
+	// gpt-4o-2024-08-06 use the profile of gpt-4o.
+	modelProfiles = map[string]ModelProfile{
+		"gpt-3.5-turbo":             {ContextWindow: 16385, MaxOutputTokens: 4096, Features: textFeatures, Pricing: Pricing{Input: 0.5, Output: 1.5}},
+		"gpt-4":                     {ContextWindow: 8192, MaxOutputTokens: 8192, Features: textFeatures, Pricing: Pricing{Input: 30, Output: 60}},
+		"gpt-4-32k":                 {ContextWindow: 32768, MaxOutputTokens: 8192, Features: textFeatures, Pricing: Pricing{Input: 60, Output: 120}},
+		"gpt-4-1106-preview":        {ContextWindow: 128000, MaxOutputTokens: 4096, Features: textFeatures, Pricing: Pricing{Input: 10, Output: 30}},
+		"gpt-4-0125-preview":        {ContextWindow: 128000, MaxOutputTokens: 4096, Features: textFeatures, Pricing: Pricing{Input: 10, Output: 30}},
+		"gpt-4-turbo-preview":       {ContextWindow: 128000, MaxOutputTokens: 4096, Features: textFeatures, Pricing: Pricing{Input: 10, Output: 30}},
+		"gpt-4-1106-vision-preview": {ContextWindow: 128000, MaxOutputTokens: 4096, Features: []Feature{FeatureSystemRole, FeatureVision, FeatureStreaming}, Pricing: Pricing{Input: 10, Output: 30}},
+		"gpt-4-vision-preview":      {ContextWindow: 128000, MaxOutputTokens: 4096, Features: []Feature{FeatureSystemRole, FeatureVision, FeatureStreaming}, Pricing: Pricing{Input: 10, Output: 30}},
+		"gpt-4-turbo":               {ContextWindow: 128000, MaxOutputTokens: 4096, Features: []Feature{FeatureSystemRole, FeatureTools, FeatureVision, FeatureLogprobs, FeatureStreaming}, Pricing: Pricing{Input: 10, Output: 30}},
+		"gpt-4o":                    {ContextWindow: 128000, MaxOutputTokens: 16384, Features: allFeatures, Pricing: Pricing{Input: 2.5, Output: 10}},
+		"gpt-4o-mini":               {ContextWindow: 128000, MaxOutputTokens: 16384, Features: allFeatures, Pricing: Pricing{Input: 0.15, Output: 0.6}},
+		"chatgpt-4o":                {ContextWindow: 128000, MaxOutputTokens: 16384, Features: []Feature{FeatureSystemRole, FeatureVision, FeatureLogprobs, FeatureStreaming}, Pricing: Pricing{Input: 5, Output: 15}},
+		"gpt-4.1":                   {ContextWindow: 1047576, MaxOutputTokens: 32768, Features: allFeatures, Pricing: Pricing{Input: 2, Output: 8}},
+		"gpt-4.1-mini":              {ContextWindow: 1047576, MaxOutputTokens: 32768, Features: allFeatures, Pricing: Pricing{Input: 0.4, Output: 1.6}},
+		"gpt-4.1-nano":              {ContextWindow: 1047576, MaxOutputTokens: 32768, Features: allFeatures, Pricing: Pricing{Input: 0.1, Output: 0.4}},
+		"gpt-4.5":                   {ContextWindow: 128000, MaxOutputTokens: 16384, Features: allFeatures, Pricing: Pricing{Input: 75, Output: 150}},
+		"gpt-5":                     {ContextWindow: 400000, MaxOutputTokens: 128000, Features: reasoningFeatures, Pricing: Pricing{Input: 1.25, Output: 10}, Reasoning: true},
+		"gpt-5-mini":                {ContextWindow: 400000, MaxOutputTokens: 128000, Features: reasoningFeatures, Pricing: Pricing{Input: 0.25, Output: 2}, Reasoning: true},
+		"gpt-5-nano":                {ContextWindow: 400000, MaxOutputTokens: 128000, Features: reasoningFeatures, Pricing: Pricing{Input: 0.05, Output: 0.4}, Reasoning: true},
+		"o1":                        {ContextWindow: 200000, MaxOutputTokens: 100000, Features: reasoningFeatures, Pricing: Pricing{Input: 15, Output: 60}, Reasoning: true},
+		"o1-preview":                {ContextWindow: 128000, MaxOutputTokens: 32768, Features: previewReasoningFeatures, Pricing: Pricing{Input: 15, Output: 60}, Reasoning: true},
+		"o1-mini":                   {ContextWindow: 128000, MaxOutputTokens: 65536, Features: previewReasoningFeatures, Pricing: Pricing{Input: 1.1, Output: 4.4}, Reasoning: true},
+		"o3":                        {ContextWindow: 200000, MaxOutputTokens: 100000, Features: reasoningFeatures, Pricing: Pricing{Input: 2, Output: 8}, Reasoning: true},
+		"o3-mini":                   {ContextWindow: 200000, MaxOutputTokens: 100000, Features: textReasoningFeatures, Pricing: Pricing{Input: 1.1, Output: 4.4}, Reasoning: true},
+		"o4-mini":                   {ContextWindow: 200000, MaxOutputTokens: 100000, Features: reasoningFeatures, Pricing: Pricing{Input: 1.1, Output: 4.4}, Reasoning: true},
+	}
+)
+
+// RegisterModel adds or replaces the profile of the models whose names start with prefix, e.g. for fine-tuned or newer models.
+func RegisterModel(prefix string, profile ModelProfile) {
+	modelsMu.Lock()
+	defer modelsMu.Unlock()
+	modelProfiles[prefix] = profile
+}
+
+// LookupModel returns the profile of model, and false if no registered prefix matches it. A fine-tuned model that isn't
+// registered, e.g. ft:gpt-4o-mini-2024-07-18:acme::abc123, has the profile of the model it was tuned from without its
+// Pricing, since fine-tuned models cost more; register it to set its pricing.
+func LookupModel(model string) (ModelProfile, bool) {
+	modelsMu.RLock()
+	defer modelsMu.RUnlock()
+
+	if profile, ok := lookupModelPrefix(model); ok {
+		return profile, true
+	}
+	if base, ok := fineTunedBaseModel(model); ok {
+		profile, ok := lookupModelPrefix(base)
+		profile.Pricing = Pricing{}
+		return profile, ok
+	}
+	return ModelProfile{}, false
+}
+
+func lookupModelPrefix(model string) (ModelProfile, bool) {
+	var best string
+	var profile ModelProfile
+	for prefix, p := range modelProfiles {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(best) {
+			best, profile = prefix, p
+		}
+	}
+	return profile, best != ""
+}
+
+// fineTunedBaseModel returns the model a fine-tuned model was tuned from; fine-tuned models are named
+// ft:{base model}:{organization}:{suffix}:{id}.
+func fineTunedBaseModel(model string) (string, bool) {
+	if !strings.HasPrefix(model, "ft:") {
+		return "", false
+	}
+	base, _, _ := strings.Cut(strings.TrimPrefix(model, "ft:"), ":")
+	return base, base != ""
+}
+
+// RequireFeatures returns an UnsupportedFeatureError for the first of features that model doesn't have. Models that aren't
+// registered are assumed to have every feature, so newer models can be used before they are added.
+func RequireFeatures(model string, features ...Feature) error {
+	profile, ok := LookupModel(model)
+	if !ok {
+		return nil
+	}
+	for _, f := range features {
+		if !profile.Supports(f) {
+			return &UnsupportedFeatureError{Model: model, Feature: string(f)}
+		}
+	}
+	return nil
+}
+
+// Token limits of models that aren't registered.
+const (
+	defaultMaxTotalTokens    = 4000
+	defaultMaxResponseTokens = 300
+	defaultMaxHistoryTokens  = 1000
+)
+
+// defaultMaxSourceTokens keeps the sources of a prompt to what they were limited to before token limits defaulted to the
+// model's context window.
+const defaultMaxSourceTokens = 4000
+
+// modelTokenDefaults returns the default MaxTotalTokens, MaxResponseTokens and MaxHistoryTokens of model: its whole context
+// window, with up to a quarter of it for the response and a quarter for the history.
+func modelTokenDefaults(model string) (total, response, history int) {
+	profile, ok := LookupModel(model)
+	if !ok {
+		return defaultMaxTotalTokens, defaultMaxResponseTokens, defaultMaxHistoryTokens
+	}
+
+	response = profile.MaxOutputTokens
+	if response > profile.ContextWindow/4 {
+		response = profile.ContextWindow / 4
+	}
+	return profile.ContextWindow, response, profile.ContextWindow / 4
+}
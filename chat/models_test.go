@@ -0,0 +1,119 @@
+package chat
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	gogpt "github.com/sashabaranov/go-openai"
+)
+
+func TestImagesRequireVision(t *testing.T) {
+	for model, want := range map[string]bool{
+		gogpt.GPT3Dot5Turbo: false,
+		gogpt.GPT4:          false,
+		gogpt.GPT4o:         true,
+		gogpt.GPT4oMini:     true,
+		gogpt.O1Mini:        false,
+		gogpt.O1:            true,
+	} {
+		profile, ok := LookupModel(model)
+		if !ok {
+			t.Fatalf("LookupModel(%q) found no profile", model)
+		}
+		if got := profile.Supports(FeatureVision); got != want {
+			t.Errorf("LookupModel(%q).Supports(FeatureVision) = %v, want %v", model, got, want)
+		}
+	}
+
+	c := NewClient("test", "", WithModel(gogpt.GPT3Dot5Turbo))
+	c.AddSourceImage("https://example.com/bird.png")
+
+	_, _, err := c.ExecutePrompt(context.Background(), "What bird is this?")
+	if !errors.Is(err, ErrUnsupportedFeature) {
+		t.Errorf("ExecutePrompt() error = %v, want ErrUnsupportedFeature", err)
+	}
+}
+
+// registerTestModel registers profile for the test, restoring what was registered for prefix when it ends.
+func registerTestModel(t *testing.T, prefix string, profile ModelProfile) {
+	modelsMu.RLock()
+	previous, ok := modelProfiles[prefix]
+	modelsMu.RUnlock()
+	t.Cleanup(func() {
+		modelsMu.Lock()
+		defer modelsMu.Unlock()
+		if ok {
+			modelProfiles[prefix] = previous
+		} else {
+			delete(modelProfiles, prefix)
+		}
+	})
+	RegisterModel(prefix, profile)
+}
+
+func TestModelTokenDefaults(t *testing.T) {
+	registerTestModel(t, "ft:gpt-4o-mini:acme", ModelProfile{ContextWindow: 8000, MaxOutputTokens: 500})
+
+	tests := []struct {
+		name                     string
+		opt                      []ConfigOption
+		total, response, history int
+	}{
+		{name: "default model", total: 16385, response: 4096, history: 4096},
+		{name: "gpt-4", opt: []ConfigOption{WithModel(gogpt.GPT4)}, total: 8192, response: 2048, history: 2048},
+		{name: "snapshot", opt: []ConfigOption{WithModel("gpt-4o-2024-08-06")}, total: 128000, response: 16384, history: 32000},
+		{name: "registered", opt: []ConfigOption{WithModel("ft:gpt-4o-mini:acme:bot:123")}, total: 8000, response: 500, history: 2000},
+		{name: "fine-tuned", opt: []ConfigOption{WithModel("ft:gpt-4o-2024-08-06:other::abc123")}, total: 128000, response: 16384, history: 32000},
+		{name: "unknown", opt: []ConfigOption{WithModel("local-llama")}, total: 4000, response: 300, history: 1000},
+		{name: "explicit", opt: []ConfigOption{WithModel(gogpt.GPT4), WithMaxResponseTokens(100)}, total: 8192, response: 100, history: 2048},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewConfig(tt.opt...)
+			if c.MaxTotalTokens != tt.total || c.MaxResponseTokens != tt.response || c.MaxHistoryTokens != tt.history {
+				t.Errorf("NewConfig() tokens = %d, %d, %d; want %d, %d, %d",
+					c.MaxTotalTokens, c.MaxResponseTokens, c.MaxHistoryTokens, tt.total, tt.response, tt.history)
+			}
+		})
+	}
+}
+
+func TestNewThreadModelDefaults(t *testing.T) {
+	// the explicit response limit is the gpt-4 default, and is kept anyway
+	c := NewClient("test", "", WithModel(gogpt.GPT4), WithMaxResponseTokens(2048), WithMaxHistoryTokens(500))
+
+	child := c.NewThread(WithModel(gogpt.GPT4o))
+	if got := child.config; got.MaxTotalTokens != 128000 || got.MaxResponseTokens != 2048 || got.MaxHistoryTokens != 500 {
+		t.Errorf("child tokens = %d, %d, %d; want the gpt-4o total and the explicit response and history limits",
+			got.MaxTotalTokens, got.MaxResponseTokens, got.MaxHistoryTokens)
+	}
+
+	grandchild := child.NewThread(WithModel(gogpt.GPT3Dot5Turbo))
+	if got := grandchild.config; got.MaxTotalTokens != 16385 || got.MaxResponseTokens != 2048 || got.MaxHistoryTokens != 500 {
+		t.Errorf("grandchild tokens = %d, %d, %d; want the gpt-3.5-turbo total and the explicit response and history limits",
+			got.MaxTotalTokens, got.MaxResponseTokens, got.MaxHistoryTokens)
+	}
+}
+
+func TestSystemRole(t *testing.T) {
+	ai, requests := testAPI(t, "Paris")
+
+	c := NewClient("test", "Answer in one word.", WithModel(gogpt.O1Mini))
+	c.ai, c.Thread.ai = ai, ai
+
+	if _, _, err := c.ExecutePrompt(context.Background(), "What is the capital of France?"); err != nil {
+		t.Fatalf("ExecutePrompt() error = %v", err)
+	}
+	if got := (*requests)[0].Messages[0].Role; got != roleUser {
+		t.Errorf("instructions were sent with role %s, want %s", got, roleUser)
+	}
+	if req := (*requests)[0]; req.MaxTokens != 0 || req.MaxCompletionTokens != c.config.MaxResponseTokens {
+		t.Errorf("request max tokens = %d, max completion tokens = %d; want only max completion tokens", req.MaxTokens, req.MaxCompletionTokens)
+	}
+	if err := RequireFeatures(gogpt.O1Mini, FeatureStreaming, FeatureTools); !errors.Is(err, ErrUnsupportedFeature) || !strings.Contains(err.Error(), "tools") {
+		t.Errorf("RequireFeatures() error = %v, want an unsupported tools error", err)
+	}
+}
@@ -15,17 +15,38 @@ var (
 	numberRegex = regexp.MustCompile(`-*[0-9\.]+`)
 )
 
+// Parse parses the answer text into v, which must be a pointer. A *RefusalError is returned if the model refused to answer,
+// a *ParseError if the text could not be parsed, and a *ValidationError if v implements Validator and rejects the answer.
 func Parse(text string, v interface{}) error {
 	if strings.HasPrefix(text, "Error:") {
-		return errors.Errorf("Unable to process request with error: %s", strings.TrimPrefix(text, "Error: "))
+		return &RefusalError{Reason: strings.TrimSpace(strings.TrimPrefix(text, "Error:"))}
 	}
 
-	t := reflect.TypeOf(v)
-
-	if t.Kind() != reflect.Pointer {
+	if reflect.TypeOf(v).Kind() != reflect.Pointer {
 		return errors.New("v must be a pointer")
 	}
 
+	err := parse(text, v)
+	if err != nil {
+		return &ParseError{Raw: text, Err: err}
+	}
+
+	validator, ok := v.(Validator)
+	if !ok {
+		validator, ok = reflect.ValueOf(v).Elem().Interface().(Validator)
+	}
+	if ok {
+		if err := validator.Validate(); err != nil {
+			return &ValidationError{Raw: text, Err: err}
+		}
+	}
+
+	return nil
+}
+
+func parse(text string, v interface{}) error {
+	t := reflect.TypeOf(v)
+
 	t = t.Elem()
 
 	// just JSON unmarshal if it's a struct or slice of structs
@@ -64,7 +85,7 @@ func Parse(text string, v interface{}) error {
 		results := reflect.MakeSlice(t, len(splitText), len(splitText))
 		for i, elem := range splitText {
 			elem = strings.TrimSpace(elem)
-			err := Parse(elem, results.Index(i).Addr().Interface())
+			err := parse(elem, results.Index(i).Addr().Interface())
 			if err != nil {
 				return errors.Wrap(err, fmt.Sprintf("failed to parse slice element: [%s]", elem))
 			}
@@ -139,86 +160,198 @@ func answerTypeInstruction(t reflect.Type) (string, error) {
 		return "", nil
 	case reflect.Struct:
 		prompt := "Provide the answer as a JSON object or array that looks like the following\n"
-		d, err := json.Marshal(reflect.Zero(t).Interface())
+		d, err := json.Marshal(exampleValue(t, map[reflect.Type]bool{}).Interface())
 		if err != nil {
 			return "", err
 		}
 		prompt += string(d)
 
-		var fieldExplanations string
-		// targetValue := t.Elem()
-		for i := 0; i < t.NumField(); i++ {
-			customInstruction := t.Field(i).Tag.Get("hardc-instruction")
-			if customInstruction == "" {
-				continue
-			}
+		fieldExplanations := fieldInstructions(t, "", map[reflect.Type]bool{})
+		if len(fieldExplanations) > 0 {
+			prompt += "\nwhere:\n" + strings.Join(fieldExplanations, "\n")
+		}
 
-			if fieldExplanations != "" {
-				fieldExplanations += "; "
-			}
+		return prompt, nil
+	}
+
+	return "", errors.Errorf("unsupported type: %s", t.Kind().String())
+}
+
+// fieldInstructions walks the fields of a struct (including nested structs, slices of structs, pointers and embedded structs) and
+// returns a line for every field that has a hardc-instruction tag or is optional, e.g. "candidate.email: must be a valid email".
+// Fields are named by their JSON names so that they match the example given to the model.
+func fieldInstructions(t reflect.Type, prefix string, visited map[reflect.Type]bool) []string {
+	t = indirectType(t)
+	if t.Kind() != reflect.Struct || visited[t] {
+		return nil
+	}
+	visited[t] = true
+	defer delete(visited, t)
+
+	var explanations []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, optional, ok := jsonFieldName(field)
+		if !ok {
+			continue
+		}
+
+		fieldType := indirectType(field.Type)
 
-			fieldExplanations += t.Field(i).Name + " " + customInstruction
+		// embedded structs without a JSON name are flattened into the parent by encoding/json, so do the same here
+		if field.Anonymous && fieldType.Kind() == reflect.Struct && field.Tag.Get("json") == "" {
+			explanations = append(explanations, fieldInstructions(fieldType, prefix, visited)...)
+			continue
 		}
 
-		if fieldExplanations != "" {
-			prompt += "\n where " + fieldExplanations
+		path := name
+		if prefix != "" {
+			path = prefix + "." + name
 		}
 
-		return prompt, nil
+		var instruction []string
+		if optional {
+			instruction = append(instruction, "(optional)")
+		}
+		if customInstruction := field.Tag.Get("hardc-instruction"); customInstruction != "" {
+			instruction = append(instruction, customInstruction)
+		}
+		if len(instruction) > 0 {
+			explanations = append(explanations, path+": "+strings.Join(instruction, " "))
+		}
+
+		switch {
+		case fieldType.Kind() == reflect.Struct:
+			explanations = append(explanations, fieldInstructions(fieldType, path, visited)...)
+		case fieldType.Kind() == reflect.Slice || fieldType.Kind() == reflect.Array:
+			explanations = append(explanations, fieldInstructions(fieldType.Elem(), path+"[]", visited)...)
+		}
 	}
 
-	return "", errors.Errorf("unsupported type: %s", t.Kind().String())
+	return explanations
+}
+
+// jsonFieldName returns the name encoding/json would use for the field, whether it is optional, and false if the field is not encoded at all.
+func jsonFieldName(field reflect.StructField) (string, bool, bool) {
+	if !field.IsExported() && !(field.Anonymous && indirectType(field.Type).Kind() == reflect.Struct) {
+		return "", false, false
+	}
+
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false, false
+	}
+
+	name, opts, _ := strings.Cut(tag, ",")
+	if name == "" {
+		name = field.Name
+	}
+
+	optional := field.Tag.Get("hardc-optional") == "true"
+	for _, opt := range strings.Split(opts, ",") {
+		if opt == "omitempty" {
+			optional = true
+		}
+	}
+
+	return name, optional, true
+}
+
+// exampleValue builds a value of type t where pointers are allocated and slices contain a single element, so that the
+// marshaled JSON shows the model the full shape of the expected answer instead of nulls.
+func exampleValue(t reflect.Type, visited map[reflect.Type]bool) reflect.Value {
+	v := reflect.New(t).Elem()
+	if visited[t] {
+		return v
+	}
+	visited[t] = true
+	defer delete(visited, t)
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		if visited[t.Elem()] {
+			return v
+		}
+		elem := reflect.New(t.Elem())
+		elem.Elem().Set(exampleValue(t.Elem(), visited))
+		v.Set(elem)
+	case reflect.Slice:
+		if visited[t.Elem()] {
+			return v
+		}
+		v.Set(reflect.Append(reflect.MakeSlice(t, 0, 1), exampleValue(t.Elem(), visited)))
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if !v.Field(i).CanSet() {
+				continue
+			}
+			v.Field(i).Set(exampleValue(t.Field(i).Type, visited))
+		}
+	}
+
+	return v
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t
 }
 
 type PromptInput interface {
 	PromptInput() string
 }
 
+// ConvertInput converts v to text for the prompt, rendering structs, slices and maps as indented JSON. Use RenderInput to choose a different format.
 func ConvertInput(v interface{}) (string, error) {
+	return renderInput(v, InputFormatJSON)
+}
+
+// convertBasicInput converts inputs that implement PromptInput and basic types; ok is false for anything else.
+func convertBasicInput(v interface{}) (string, bool) {
 	switch t := v.(type) {
 	case PromptInput:
-		return t.PromptInput(), nil
+		return t.PromptInput(), true
 	case bool:
-		return strconv.FormatBool(t), nil
+		return strconv.FormatBool(t), true
 	case int:
-		return strconv.Itoa(t), nil
+		return strconv.Itoa(t), true
 	case uint:
-		strconv.FormatUint(uint64(t), 10)
+		return strconv.FormatUint(uint64(t), 10), true
 	case string:
-		return t, nil
-	case float32, float64:
-		return strconv.FormatFloat(t.(float64), 'f', -1, 64), nil
+		return t, true
+	case float32:
+		return strconv.FormatFloat(float64(t), 'f', -1, 32), true
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64), true
 	case []string:
-		return strings.Join(t, ", "), nil
+		return strings.Join(t, ", "), true
 	case []int:
 		var s []string
 		for _, i := range t {
 			s = append(s, strconv.Itoa(i))
 		}
-		return strings.Join(s, ", "), nil
+		return strings.Join(s, ", "), true
 	case []float64:
 		var s []string
 		for _, f := range t {
 			s = append(s, strconv.FormatFloat(f, 'f', -1, 64))
 		}
-		return strings.Join(s, ", "), nil
+		return strings.Join(s, ", "), true
 	case []float32:
 		var s []string
 		for _, f := range t {
 			s = append(s, strconv.FormatFloat(float64(f), 'f', -1, 64))
 		}
-		return strings.Join(s, ", "), nil
+		return strings.Join(s, ", "), true
 	case []bool:
 		var s []string
 		for _, b := range t {
 			s = append(s, strconv.FormatBool(b))
 		}
-		return strings.Join(s, ", "), nil
+		return strings.Join(s, ", "), true
 	}
 
-	b, err := json.MarshalIndent(v, "", "    ")
-	if err != nil {
-		return "", err
-	}
-	return string(b), nil
+	return "", false
 }
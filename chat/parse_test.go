@@ -0,0 +1,132 @@
+package chat
+
+import (
+	"errors"
+	"testing"
+)
+
+type testAddress struct {
+	Street string `json:"street" hardc-instruction:"street name and number only"`
+	Zip    string `json:"zip,omitempty"`
+}
+
+type testContact struct {
+	Email string `json:"email" hardc-instruction:"must be a valid email address"`
+}
+
+type testCandidate struct {
+	testContact
+	Name      string         `json:"name"`
+	Addresses []testAddress  `json:"addresses"`
+	Manager   *testCandidate `json:"manager,omitempty"`
+	Internal  string         `json:"-" hardc-instruction:"never shown"`
+}
+
+type testAnswer struct {
+	Candidate testCandidate `json:"candidate"`
+	Score     int           `hardc-instruction:"between 0 and 100"`
+}
+
+func TestParseInstruction(t *testing.T) {
+	tests := []struct {
+		name string
+		v    interface{}
+		want string
+	}{
+		{
+			name: "int",
+			v:    0,
+			want: "Answer this with an integer only, no punctuation or explanation: ",
+		},
+		{
+			name: "slice of strings",
+			v:    []string{},
+			want: " (separate multiple answers with commas): ",
+		},
+		{
+			name: "nested struct",
+			v:    testAnswer{},
+			want: `Provide the answer as a JSON object or array that looks like the following
+{"candidate":{"email":"","name":"","addresses":[{"street":""}]},"Score":0}
+where:
+candidate.email: must be a valid email address
+candidate.addresses[].street: street name and number only
+candidate.addresses[].zip: (optional)
+candidate.manager: (optional)
+Score: between 0 and 100: `,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseInstruction(tt.v)
+			if err != nil {
+				t.Errorf("ParseInstruction() error = %v", err)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseInstruction() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+type testScore int
+
+func (s testScore) Validate() error {
+	if s < 0 || s > 100 {
+		return errors.New("score must be between 0 and 100")
+	}
+	return nil
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		v       interface{}
+		wantErr error
+	}{
+		{
+			name:    "refusal",
+			text:    "Error: there is no resume",
+			v:       new(int),
+			wantErr: ErrRefused,
+		},
+		{
+			name:    "parse failure",
+			text:    "not a number",
+			v:       new(int),
+			wantErr: ErrParse,
+		},
+		{
+			name:    "validation failure",
+			text:    "150",
+			v:       new(testScore),
+			wantErr: ErrValidation,
+		},
+		{
+			name: "valid",
+			text: "50",
+			v:    new(testScore),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Parse(tt.text, tt.v)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Errorf("Parse() error = %v, want nil", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Parse() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	var refusal *RefusalError
+	if err := Parse("Error: no resume", new(string)); !errors.As(err, &refusal) || refusal.Reason != "no resume" {
+		t.Errorf("Parse() error = %v, want RefusalError with reason", err)
+	}
+}
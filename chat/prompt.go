@@ -0,0 +1,24 @@
+package chat
+
+import (
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// MustParsePromptTemplate parses a question prompt written as a text/template. Generated clients call this for prompts
+// that reference their inputs, e.g. "Write to {{.Candidate.Name}}"; the templates are type-checked by `hardc generate`.
+func MustParsePromptTemplate(name, text string) *template.Template {
+	return template.Must(template.New(name).Option("missingkey=error").Parse(text))
+}
+
+// ExecutePromptTemplate renders a prompt template with the question's input.
+func ExecutePromptTemplate(tmpl *template.Template, data interface{}) (string, error) {
+	var sb strings.Builder
+	err := tmpl.Execute(&sb, data)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to render prompt template")
+	}
+	return sb.String(), nil
+}
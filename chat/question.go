@@ -0,0 +1,113 @@
+package chat
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	gogpt "github.com/sashabaranov/go-openai"
+	"github.com/troylelandshields/hardconversations/logger"
+)
+
+// Question is a prompt along with the parts it was built from, so that each part can be used on its own, e.g. to retrieve sources.
+type Question struct {
+	Name       string        // name of the question's function
+	Prompt     string        // the question's prompt, without the parse instruction or input
+	FullPrompt string        // what is sent to the model
+	Input      RenderedInput // the question's input, if it is appended to the prompt
+	InputValue interface{}   // the question's input before it was rendered, passed to source providers; see sources.SourceQuery
+}
+
+// QueryMode is what is used as the query to find relevant sources.
+type QueryMode string
+
+const (
+	// QueryFullPrompt uses the whole prompt sent to the model, including the parse instruction and input. This is the default.
+	QueryFullPrompt QueryMode = "full_prompt"
+	// QueryPrompt uses only the question's prompt, without the parse instruction and input.
+	QueryPrompt QueryMode = "prompt"
+	// QueryInput uses only the question's rendered input, or the question's prompt if it has no input.
+	QueryInput QueryMode = "input"
+	// QueryGenerated asks the model to write a search query for the question first.
+	QueryGenerated QueryMode = "generated"
+	// QueryHypotheticalAnswer asks the model to write a hypothetical answer to the question first, and uses it as the query
+	// (HyDE), since an answer is often more similar to the relevant sources than the question is.
+	QueryHypotheticalAnswer QueryMode = "hypothetical_answer"
+)
+
+const (
+	generatedQueryInstruction          = "Write a short search query to find information that would help answer the following request. Respond with only the query."
+	hypotheticalAnswerQueryInstruction = "Write a short, plausible answer to the following request, as if you had all of the information needed. Respond with only the answer."
+	maxGeneratedQueryTokens            = 150
+	// reasoning models count their reasoning in max_completion_tokens, so they need room to reason before they write the query
+	maxReasoningGeneratedQueryTokens = 2000
+)
+
+// retrievalQuery returns the query used to find relevant sources for q, and the usage of the request that generated it, if any.
+func (t *Thread) retrievalQuery(ctx context.Context, q Question) (string, gogpt.Usage, error) {
+	question := q.Prompt
+	if question == "" {
+		question = q.FullPrompt
+	}
+
+	switch t.config.RetrievalQuery {
+	case QueryPrompt:
+		return question, gogpt.Usage{}, nil
+	case QueryInput:
+		if q.Input.Text != "" {
+			return q.Input.Text, gogpt.Usage{}, nil
+		}
+		return question, gogpt.Usage{}, nil
+	case QueryGenerated:
+		return t.generateQuery(ctx, generatedQueryInstruction, q)
+	case QueryHypotheticalAnswer:
+		return t.generateQuery(ctx, hypotheticalAnswerQueryInstruction, q)
+	}
+	return q.FullPrompt, gogpt.Usage{}, nil
+}
+
+// generateQuery asks the model to write a query for q with instruction. If the model doesn't write one, e.g. because it used
+// its whole budget for reasoning, the question's prompt is used instead.
+func (t *Thread) generateQuery(ctx context.Context, instruction string, q Question) (string, gogpt.Usage, error) {
+	question := q.Prompt
+	if question == "" {
+		question = q.FullPrompt
+	}
+	request := question
+	if q.Input.Text != "" {
+		request += "\n" + q.Input.Text
+	}
+
+	req := gogpt.ChatCompletionRequest{
+		Model: t.config.Model,
+		Messages: []gogpt.ChatCompletionMessage{
+			{Role: t.systemRole(), Content: instruction},
+			{Role: roleUser, Content: request},
+		},
+		Temperature: 0.0,
+		TopP:        1.0,
+		User:        t.config.UserID,
+	}
+	maxTokens := maxGeneratedQueryTokens
+	if profile, ok := LookupModel(t.config.Model); ok && profile.Reasoning {
+		maxTokens = maxReasoningGeneratedQueryTokens
+	}
+	t.setMaxTokens(&req, maxTokens)
+
+	resp, err := t.ai.CreateChatCompletion(ctx, req)
+	if err != nil {
+		return "", gogpt.Usage{}, classifyAPIError(err)
+	}
+	if len(resp.Choices) == 0 {
+		return "", resp.Usage, &TransportError{StatusCode: http.StatusOK, Err: errors.New("response contained no choices")}
+	}
+
+	query := strings.TrimSpace(resp.Choices[0].Message.Content)
+	if query == "" {
+		logger.Debugf("No retrieval query was generated (finish reason %s), using the prompt", resp.Choices[0].FinishReason)
+		return question, resp.Usage, nil
+	}
+	logger.Debugf("Generated retrieval query: %s", query)
+	return query, resp.Usage, nil
+}
@@ -0,0 +1,37 @@
+package chat
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRetrievalQuery(t *testing.T) {
+	q := Question{
+		Prompt:     "Which resume fits best?",
+		FullPrompt: "Answer with an integer only. Which resume fits best?\nSenior Go engineer",
+		Input:      RenderedInput{Text: "Senior Go engineer"},
+	}
+
+	tests := []struct {
+		mode QueryMode
+		q    Question
+		want string
+	}{
+		{QueryFullPrompt, q, q.FullPrompt},
+		{QueryPrompt, q, "Which resume fits best?"},
+		{QueryInput, q, "Senior Go engineer"},
+		{QueryInput, Question{Prompt: "Which rules?", FullPrompt: "x Which rules?"}, "Which rules?"},
+		{QueryPrompt, Question{FullPrompt: "raw prompt"}, "raw prompt"},
+	}
+
+	for _, tt := range tests {
+		thread := &Thread{config: NewConfig(WithRetrievalQuery(tt.mode))}
+		got, _, err := thread.retrievalQuery(context.Background(), tt.q)
+		if err != nil {
+			t.Fatalf("retrievalQuery(%s) error = %v", tt.mode, err)
+		}
+		if got != tt.want {
+			t.Errorf("retrievalQuery(%s) = %q, want %q", tt.mode, got, tt.want)
+		}
+	}
+}
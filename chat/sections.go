@@ -0,0 +1,183 @@
+package chat
+
+import (
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"github.com/troylelandshields/hardconversations/sources"
+)
+
+// SourceSection is the texts of one source (or of all sources with the same name and description) as they are passed to the
+// source template.
+type SourceSection struct {
+	Name        string
+	Description string
+	Items       []SourceItem
+}
+
+// Title is the section's label: its name, followed by its description in parentheses, e.g. "In-state resumes (preferred)".
+func (s SourceSection) Title() string {
+	switch {
+	case s.Name == "":
+		return s.Description
+	case s.Description == "":
+		return s.Name
+	}
+	return s.Name + " (" + s.Description + ")"
+}
+
+// SourceItem is one text of a SourceSection. Header is the label to show for the text: its citation label if citations are
+// enabled, otherwise what the configured SourceHeader returns (the text's Identifier by default) if the section has a Title.
+type SourceItem struct {
+	Header string
+	Text   string
+	Source sources.TextEmbedding
+}
+
+// DefaultSourceTemplate renders sections with a title as a Markdown heading followed by their texts, each on its own line and
+// starting with its header in square brackets if it has one.
+var DefaultSourceTemplate = template.Must(template.New("sources").Parse(
+	`{{- range $i, $s := . }}{{ if $i }}{{ "\n\n" }}{{ end }}` +
+		`{{- with $s.Title }}## {{ . }}{{ "\n" }}{{ end }}` +
+		`{{- range $j, $item := $s.Items }}{{ if $j }}{{ "\n" }}{{ end }}{{ with $item.Header }}[{{ . }}] {{ end }}{{ $item.Text }}{{ end }}` +
+		`{{- end }}`))
+
+// sourceSections groups texts by the source they came from, in the order each source's first text appears. labels are the
+// citation labels of the texts, or nil if citations aren't enabled.
+func (t *Thread) sourceSections(usedSources []sources.TextEmbedding, labels []string) []SourceSection {
+	type sectionKey struct{ name, description string }
+	var sections []SourceSection
+	idxs := map[sectionKey]int{}
+	for i, s := range usedSources {
+		key := sectionKey{s.SourceName(), s.SourceDescription()}
+		idx, ok := idxs[key]
+		if !ok {
+			idx = len(sections)
+			idxs[key] = idx
+			sections = append(sections, SourceSection{Name: key.name, Description: key.description})
+		}
+
+		item := SourceItem{Text: s.Text, Source: s}
+		if labels != nil {
+			item.Header = labels[i]
+		} else if sections[idx].Title() != "" {
+			item.Header = t.sourceHeader(s)
+		}
+		sections[idx].Items = append(sections[idx].Items, item)
+	}
+	return sections
+}
+
+func (t *Thread) sourceHeader(s sources.TextEmbedding) string {
+	if t.config.SourceHeader != nil {
+		return t.config.SourceHeader(s)
+	}
+	return s.Identifier
+}
+
+// renderSources renders the texts with the configured source template. Texts are dropped from the end until the rendered
+// text, including headers, fits in allowedTokens. What each text and each section's title add to the rendered text is counted
+// once, so the texts to drop are found without rendering and counting the rest again for every one of them.
+func (t *Thread) renderSources(usedSources []sources.TextEmbedding, allowedTokens int) (string, []sources.TextEmbedding, []string, error) {
+	var labels []string
+	if t.config.Citations {
+		labels = citationLabels(usedSources)
+	}
+
+	rendered, tokenCount, err := t.renderTemplate(t.sourceSections(usedSources, labels))
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if len(usedSources) == 0 || tokenCount <= allowedTokens {
+		return rendered, usedSources, labels, nil
+	}
+
+	itemTokens, titleTokens, err := t.sourceTokens(usedSources, labels)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	n := len(usedSources)
+	for n > 0 && tokenCount > allowedTokens {
+		for n > 0 && tokenCount > allowedTokens {
+			n--
+			tokenCount -= itemTokens[n] + titleTokens[n]
+		}
+
+		// the separators between texts aren't counted and tokens can merge where texts are joined, so the count is
+		// checked on the rendered text
+		rendered, tokenCount, err = t.renderTemplate(t.sourceSections(usedSources[:n], prefix(labels, n)))
+		if err != nil {
+			return "", nil, nil, err
+		}
+	}
+
+	// the estimate may have dropped a text that fits after all
+	for n < len(usedSources) {
+		next, nextTokens, err := t.renderTemplate(t.sourceSections(usedSources[:n+1], prefix(labels, n+1)))
+		if err != nil {
+			return "", nil, nil, err
+		}
+		if nextTokens > allowedTokens {
+			break
+		}
+		rendered, n = next, n+1
+	}
+	return rendered, usedSources[:n], prefix(labels, n), nil
+}
+
+// prefix returns the first n labels, or nil if citations aren't enabled.
+func prefix(labels []string, n int) []string {
+	if labels == nil {
+		return nil
+	}
+	return labels[:n]
+}
+
+// renderTemplate renders sections with the configured source template and counts the rendered text's tokens.
+func (t *Thread) renderTemplate(sections []SourceSection) (string, int, error) {
+	tmpl := t.config.SourceTemplate
+	if tmpl == nil {
+		tmpl = DefaultSourceTemplate
+	}
+
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, sections); err != nil {
+		return "", 0, errors.Wrap(err, "error rendering sources")
+	}
+	return sb.String(), t.countTokens(sb.String()), nil
+}
+
+// sourceTokens returns the tokens each text adds to its rendered section, and the tokens of the section's title for the
+// first text of each section, which are only rendered while that text is used.
+func (t *Thread) sourceTokens(usedSources []sources.TextEmbedding, labels []string) (itemTokens []int, titleTokens []int, err error) {
+	type sectionKey struct{ name, description string }
+	titles := map[sectionKey]int{}
+
+	itemTokens = make([]int, len(usedSources))
+	titleTokens = make([]int, len(usedSources))
+	for i, s := range usedSources {
+		key := sectionKey{s.SourceName(), s.SourceDescription()}
+		title, ok := titles[key]
+		if !ok {
+			// the section with only its title
+			if _, title, err = t.renderTemplate([]SourceSection{{Name: key.name, Description: key.description}}); err != nil {
+				return nil, nil, err
+			}
+			titles[key] = title
+			titleTokens[i] = title
+		}
+
+		var label []string
+		if labels != nil {
+			label = labels[i : i+1]
+		}
+		_, item, err := t.renderTemplate(t.sourceSections(usedSources[i:i+1], label))
+		if err != nil {
+			return nil, nil, err
+		}
+		itemTokens[i] = item - title
+	}
+	return itemTokens, titleTokens, nil
+}
@@ -0,0 +1,110 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"text/template"
+
+	"github.com/troylelandshields/hardconversations/sources"
+)
+
+func TestSourceSections(t *testing.T) {
+	newThread := func(opt ...ConfigOption) *Thread {
+		m := sources.New(nil, sources.WithDefaultEmbedder(sources.HashingEmbedder{}))
+		m.AddSourceTextEmbeddingProvider(staticSources{
+			{Identifier: "alice", Text: "Alice, Utah", Metadata: "123"},
+			{Identifier: "bob", Text: "Bob, Utah"},
+		}, sources.WithName("In-state resumes"), sources.WithDescription("preferred"), sources.WithWeight(2))
+		m.AddSourceTextEmbeddingProvider(staticSources{{Identifier: "carol", Text: "Carol, Texas"}}, sources.WithName("Out-of-state resumes"))
+		m.AddSourceText("Hire quickly.")
+		return &Thread{config: NewConfig(opt...), Manager: m}
+	}
+
+	tests := []struct {
+		name    string
+		opt     []ConfigOption
+		allowed int
+		want    string
+	}{
+		{
+			name:    "default template",
+			allowed: 1000,
+			want:    "## In-state resumes (preferred)\n[alice] Alice, Utah\n[bob] Bob, Utah\n\n## Out-of-state resumes\n[carol] Carol, Texas\n\nHire quickly.",
+		},
+		{
+			name: "custom header",
+			opt: []ConfigOption{WithSourceHeader(func(te sources.TextEmbedding) string {
+				return fmt.Sprintf("%s %v", te.Identifier, te.Metadata)
+			})},
+			allowed: 1000,
+			want:    "## In-state resumes (preferred)\n[alice 123] Alice, Utah\n[bob <nil>] Bob, Utah\n\n## Out-of-state resumes\n[carol <nil>] Carol, Texas\n\nHire quickly.",
+		},
+		{
+			name:    "custom template",
+			opt:     []ConfigOption{WithSourceTemplate(template.Must(template.New("").Parse(`{{range .}}{{.Name}}:{{range .Items}} {{.Text}};{{end}}{{"\n"}}{{end}}`)))},
+			allowed: 1000,
+			want:    "In-state resumes: Alice, Utah; Bob, Utah;\nOut-of-state resumes: Carol, Texas;\n: Hire quickly.;\n",
+		},
+		{
+			name:    "headers count against the budget",
+			allowed: 16,
+			want:    "## In-state resumes (preferred)\n[alice] Alice, Utah",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, _, _, err := newThread(tt.opt...).sourceText(context.Background(), tt.allowed, Question{}, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("sourceText() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+type staticSources []sources.TextEmbedding
+
+func (s staticSources) Sources(ctx context.Context, prompt string) ([]sources.TextEmbedding, error) {
+	return s, nil
+}
+
+func TestRenderSourcesBudget(t *testing.T) {
+	var used []sources.TextEmbedding
+	for i := 0; i < 12; i++ {
+		te := sources.TextEmbedding{Identifier: fmt.Sprintf("resume-%d", i), Text: fmt.Sprintf("Candidate %d knows Go and SQL.", i)}
+		used = append(used, te)
+	}
+
+	for _, citations := range []bool{false, true} {
+		var opt []ConfigOption
+		if citations {
+			opt = append(opt, WithCitations())
+		}
+		thread := &Thread{config: NewConfig(opt...)}
+		full, _, _, _ := thread.renderSources(used, 1000)
+
+		for allowed := 0; allowed <= thread.countTokens(full); allowed++ {
+			rendered, got, _, err := thread.renderSources(used, allowed)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the most texts whose rendered text fits
+			want := 0
+			for n := len(used); n > 0; n-- {
+				if r, _, _, _ := thread.renderSources(used[:n], 1000); thread.countTokens(r) <= allowed {
+					want = n
+					break
+				}
+			}
+			if len(got) != want || thread.countTokens(rendered) > allowed {
+				t.Errorf("renderSources(citations %t, %d tokens) used %d texts with %d tokens, want %d texts", citations, allowed,
+					len(got), thread.countTokens(rendered), want)
+			}
+		}
+	}
+}
@@ -2,7 +2,11 @@ package chat
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
 
+	"github.com/pkg/errors"
 	gogpt "github.com/sashabaranov/go-openai"
 	"github.com/troylelandshields/hardconversations/internal/tokens"
 	"github.com/troylelandshields/hardconversations/logger"
@@ -23,14 +27,16 @@ const (
 )
 
 type Thread struct {
+	id     string
 	config Config
 	ai     *gogpt.Client
 
-	systemMessage       string
-	systemMessageTokens int
+	systemMessage string
 
 	history           []gogpt.ChatCompletionMessage
-	historyTokenCount int
+	historyTokenCount int // including the chat format overhead of each message
+
+	tokenizer tokens.Tokenizer // chosen from config.Model
 
 	*sources.Manager
 }
@@ -42,73 +48,209 @@ func (t *Thread) NewThread(opt ...ConfigOption) *Thread {
 		o(&config)
 	}
 
-	return &Thread{
+	child := &Thread{
+		id:     newThreadID(),
 		config: config,
 
-		ai:                  t.ai,
-		systemMessage:       t.systemMessage,
-		systemMessageTokens: t.systemMessageTokens,
+		ai:            t.ai,
+		systemMessage: t.systemMessage,
 
 		history:           t.history,
 		historyTokenCount: t.historyTokenCount,
 
-		Manager: sources.NewFromParent(t.Manager),
+		tokenizer: t.tokenizer,
+	}
+
+	// a different model may count tokens differently and have different limits
+	if config.Model != t.config.Model {
+		child.config = config.withModelDefaults(t.config)
+		child.tokenizer = tokens.ForModel(config.Model)
+		child.historyTokenCount = child.countHistory()
+	}
+	managerOpts := append(config.managerOptions(t.ai, &t.config), sources.WithTokenizer(child.Tokenizer()))
+	child.Manager = sources.NewFromParent(t.Manager, managerOpts...)
+
+	return child
+}
+
+// Tokenizer returns the tokenizer of the thread's model, which all of its token budgets are counted with.
+func (t *Thread) Tokenizer() tokens.Tokenizer {
+	if t.tokenizer == nil {
+		return tokens.Default()
+	}
+	return t.tokenizer
+}
+
+func (t *Thread) countTokens(text string) int {
+	return tokens.MustCountWith(t.tokenizer, text)
+}
+
+// countMessage returns the tokens m adds to a request to the thread's model, including the chat format's overhead. Images
+// aren't counted; see sources.Image.TokenCount.
+func (t *Thread) countMessage(m gogpt.ChatCompletionMessage) int {
+	return tokens.MustCountMessage(t.tokenizer, t.config.Model, tokenMessage(m))
+}
+
+// countMessages returns the prompt tokens of a request to the thread's model with messages, except for images.
+func (t *Thread) countMessages(messages []gogpt.ChatCompletionMessage) int {
+	counted := make([]tokens.Message, len(messages))
+	for i, m := range messages {
+		counted[i] = tokenMessage(m)
 	}
+	return tokens.MustCountMessages(t.tokenizer, t.config.Model, counted)
+}
+
+// countRequest returns the prompt tokens of a request with messages and the images attached to them.
+func (t *Thread) countRequest(messages []gogpt.ChatCompletionMessage, images []sources.Image) int {
+	count := t.countMessages(messages)
+	for _, img := range images {
+		count += img.TokenCount()
+	}
+	return count
+}
+
+func (t *Thread) countHistory() int {
+	var count int
+	for _, m := range t.history {
+		count += t.countMessage(m)
+	}
+	return count
+}
+
+// tokenMessage returns the text of m that is counted in prompt tokens.
+func tokenMessage(m gogpt.ChatCompletionMessage) tokens.Message {
+	content := m.Content
+	for _, part := range m.MultiContent {
+		if part.Type == gogpt.ChatMessagePartTypeText {
+			content += part.Text
+		}
+	}
+	return tokens.Message{Role: m.Role, Name: m.Name, Content: content}
+}
+
+// ID returns the thread's randomly generated ID, which is passed to source providers in their SourceQuery.
+func (t *Thread) ID() string {
+	return t.id
+}
+
+func newThreadID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
 }
 
 // Completely replaces existing history with the given history.
 func (t *Thread) ReplaceHistory(history []gogpt.ChatCompletionMessage) {
 	t.history = history
-	for _, m := range history {
-		t.historyTokenCount += tokens.MustCount(m.Content)
-	}
+	t.historyTokenCount = t.countHistory()
 }
 
 func (t *Thread) ExecutePrompt(ctx context.Context, prompt string) (string, Metadata, error) {
-	if t.systemMessageTokens == 0 {
-		t.systemMessageTokens = tokens.MustCount(t.systemMessage)
+	return t.ExecuteQuestion(ctx, Question{FullPrompt: prompt})
+}
+
+// ExecuteQuestion sends q.FullPrompt to the model, like ExecutePrompt, using the other parts of q to find relevant sources.
+func (t *Thread) ExecuteQuestion(ctx context.Context, q Question) (_ string, _ Metadata, err error) {
+	prompt := q.FullPrompt
+
+	if t.Manager.HasImages() {
+		if err := RequireFeatures(t.config.Model, FeatureVision); err != nil {
+			return "", Metadata{}, err
+		}
 	}
 
+	// if the question fails, the history is restored so the prompt isn't sent again with the next one
+	history, historyTokenCount := t.history, t.historyTokenCount
+	defer func() {
+		if err != nil {
+			t.history, t.historyTokenCount = history, historyTokenCount
+		}
+	}()
+
 	// check if we need to drop any previous history
-	if t.systemMessageTokens > t.config.MaxHistoryTokens {
+	if t.historyTokenCount > t.config.MaxHistoryTokens {
 		t.dropHistory(t.historyTokenCount - t.config.MaxHistoryTokens)
 	}
 
 	// push new user message to history
 	t.pushHistory(roleUser, prompt)
 
-	// find the source text information and append it to the system message
-	contextInfoStr, usedSources, err := t.sourceText(
-		ctx,
-		t.config.MaxTotalTokens-
-			(t.historyTokenCount+t.systemMessageTokens+t.config.MaxResponseTokens),
-		prompt)
+	// find the source text information and append it to the system message; the request without sources is counted as it
+	// will be sent, with every message's overhead and the reply priming
+	allowedSourceTokens := t.config.MaxTotalTokens -
+		(t.countMessages(t.requestMessages(t.systemMessage, nil)) + t.config.MaxResponseTokens)
+	if t.config.Citations {
+		allowedSourceTokens -= t.countTokens(citationInstruction)
+	}
+	if t.config.MaxSourceTokens >= 0 && allowedSourceTokens > t.config.MaxSourceTokens {
+		allowedSourceTokens = t.config.MaxSourceTokens
+	}
+
+	// images are sent with the prompt and use up tokens before the text sources
+	usedImages, err := t.Manager.GetSourceImages(ctx, allowedSourceTokens)
 	if err != nil {
 		return "", Metadata{}, err
 	}
-	systemMessage := t.systemMessage + contextInfoStr
-	logger.Debugf("Sytem message: %s", systemMessage)
+	for _, img := range usedImages {
+		allowedSourceTokens -= img.TokenCount()
+	}
 
-	messages := []gogpt.ChatCompletionMessage{
-		{
-			Role:    roleSystem,
-			Content: systemMessage,
-		},
+	var query string
+	var queryUsage gogpt.Usage
+	if t.config.UseEmbeddings {
+		query, queryUsage, err = t.retrievalQuery(ctx, q)
+		if err != nil {
+			return "", Metadata{}, err
+		}
+	}
+
+	contextInfoStr, usedSources, labels, report, err := t.sourceText(ctx, allowedSourceTokens, q, query)
+	if err != nil {
+		return "", Metadata{}, err
+	}
+
+	// tokens can merge where the sources are joined to the system message, so the request is counted again as it will be
+	// sent, dropping sources until it fits
+	var messages []gogpt.ChatCompletionMessage
+	var promptTokens int
+	for {
+		systemMessage := t.systemMessage + contextInfoStr
+		if t.config.Citations {
+			systemMessage += citationInstruction
+		}
+		messages = t.requestMessages(systemMessage, usedImages)
+		promptTokens = t.countRequest(messages, usedImages)
+		if promptTokens+t.config.MaxResponseTokens <= t.config.MaxTotalTokens {
+			logger.Debugf("Sytem message: %s", systemMessage)
+			break
+		}
+		if len(usedSources) == 0 {
+			return "", Metadata{}, &ContextLengthError{Err: errors.Errorf("request needs %d prompt tokens and %d response tokens, more than MaxTotalTokens %d",
+				promptTokens, t.config.MaxResponseTokens, t.config.MaxTotalTokens)}
+		}
+
+		report.Drop(usedSources[len(usedSources)-1:], sources.DispositionGlobalBudget)
+		contextInfoStr, usedSources, labels, err = t.renderSources(usedSources[:len(usedSources)-1], allowedSourceTokens)
+		if err != nil {
+			return "", Metadata{}, err
+		}
 	}
-	messages = append(messages, t.history...)
 
 	logger.Debugf("Sending question: %s", prompt)
 	completionRequest := gogpt.ChatCompletionRequest{
 		Model:       t.config.Model,
 		Messages:    messages,
-		MaxTokens:   t.config.MaxResponseTokens,
 		Temperature: 0.0,
 		TopP:        1.0,
 	}
+	t.setMaxTokens(&completionRequest, t.config.MaxResponseTokens)
 
 	resp, err := t.ai.CreateChatCompletion(ctx, completionRequest)
 	if err != nil {
-		return "", Metadata{}, err
+		return "", Metadata{}, classifyAPIError(err)
+	}
+	if len(resp.Choices) == 0 {
+		return "", Metadata{}, &TransportError{StatusCode: http.StatusOK, Err: errors.New("response contained no choices")}
 	}
 
 	responseText := resp.Choices[0].Message.Content
@@ -116,64 +258,190 @@ func (t *Thread) ExecutePrompt(ctx context.Context, prompt string) (string, Meta
 	logger.Debugf("Received answer: %s", responseText)
 	t.pushHistory(roleAssistant, responseText)
 
-	return responseText,
-		Metadata{
-			RawResponse:     resp,
-			UsedTextSources: usedSources,
-		},
-		nil
+	md := Metadata{
+		RawResponse:      resp,
+		PromptTokens:     promptTokens,
+		UsedTextSources:  usedSources,
+		UsedImageSources: usedImages,
+		RetrievalQuery:   query,
+		QueryUsage:       queryUsage,
+		RetrievalReport:  report,
+		Input:            q.Input,
+	}
+	if t.config.Citations {
+		responseText, md.CitedSources = extractCitations(responseText, usedSources, labels)
+	}
+
+	return responseText, md, nil
 }
 
-func (t *Thread) sourceText(ctx context.Context, allowedTokens int, prompt string) (string, []sources.TextEmbedding, error) {
-	sources, err := t.Manager.GetSourceText(ctx, t.config.UseEmbeddings, t.config.CosineSimilarityThreshold, allowedTokens, prompt, t.config.UserID)
+// RenderInput renders a question's input in the given format and passes it to the configured InputInspector, if any.
+func (t *Thread) RenderInput(v interface{}, format InputFormat) (RenderedInput, error) {
+	rendered, err := RenderInput(v, format)
 	if err != nil {
-		return "", nil, err
+		return RenderedInput{}, err
 	}
+	rendered.Tokens = t.countTokens(rendered.Text)
 
-	var contextualInfo string
-	for _, s := range sources {
-		if contextualInfo != "" {
-			contextualInfo += "\n"
-		}
-		contextualInfo += s.Text
+	return rendered, t.inspectInput(rendered)
+}
+
+// RenderInputs renders a question's named inputs as labeled sections and passes them to the configured InputInspector, if any.
+func (t *Thread) RenderInputs(inputs ...NamedInput) (RenderedInput, error) {
+	rendered, err := RenderInputs(inputs...)
+	if err != nil {
+		return RenderedInput{}, err
 	}
+	rendered.Tokens = t.countTokens(rendered.Text)
 
-	return contextualInfo, sources, nil
+	return rendered, t.inspectInput(rendered)
 }
 
-func (t *Thread) pushHistory(role, text string) {
-	t.historyTokenCount += tokens.MustCount(text)
+func (t *Thread) inspectInput(rendered RenderedInput) error {
+	if t.config.InputInspector != nil {
+		return t.config.InputInspector(rendered)
+	}
+	return nil
+}
+
+// sourceText returns the rendered source texts, the texts that were used, their citation labels if citations are enabled, and
+// the retrieval report if diagnostics are enabled.
+func (t *Thread) sourceText(ctx context.Context, allowedTokens int, q Question, query string) (string, []sources.TextEmbedding, []string, *sources.RetrievalReport, error) {
+	req := sources.RetrievalRequest{
+		SortByRelevance:     t.config.UseEmbeddings,
+		MinCosineSimilarity: t.config.CosineSimilarityThreshold,
+		AllowedTokens:       allowedTokens,
+		Prompt:              q.FullPrompt,
+		Query:               query,
+		UserID:              t.config.UserID,
+		Input:               q.InputValue,
+		Question:            q.Name,
+		ThreadID:            t.id,
+		Filters:             t.config.SourceFilters,
+	}
+
+	var retrieved []sources.TextEmbedding
+	var report *sources.RetrievalReport
+	var err error
+	if t.config.Diagnostics {
+		retrieved, report, err = t.Manager.RetrieveWithReport(ctx, req)
+	} else {
+		retrieved, err = t.Manager.Retrieve(ctx, req)
+	}
+	if err != nil {
+		return "", nil, nil, nil, classifySourceError(err)
+	}
+
+	// headers and the template can push the rendered texts over the budget, in which case the last ones are dropped
+	rendered, used, labels, err := t.renderSources(retrieved, allowedTokens)
+	if err != nil {
+		return "", nil, nil, nil, err
+	}
+	report.Drop(retrieved[len(used):], sources.DispositionGlobalBudget)
+	return rendered, used, labels, report, nil
+}
+
+// requestMessages returns the messages of a request: the system message followed by the history, with images sent along with
+// the last message.
+func (t *Thread) requestMessages(systemMessage string, images []sources.Image) []gogpt.ChatCompletionMessage {
+	messages := []gogpt.ChatCompletionMessage{
+		{
+			Role:    t.systemRole(),
+			Content: systemMessage,
+		},
+	}
+	messages = append(messages, t.history...)
+	if len(images) > 0 {
+		last := messages[len(messages)-1]
+		messages[len(messages)-1] = imageMessage(last.Content, images)
+	}
+	return messages
+}
+
+// setMaxTokens limits the response of req to maxTokens, with the parameter the thread's model takes.
+func (t *Thread) setMaxTokens(req *gogpt.ChatCompletionRequest, maxTokens int) {
+	if profile, ok := LookupModel(t.config.Model); ok && profile.Reasoning {
+		req.MaxCompletionTokens = maxTokens
+		return
+	}
+	req.MaxTokens = maxTokens
+}
+
+// systemRole returns the role of the system message: models without a system role get it as a user message instead.
+func (t *Thread) systemRole() string {
+	if RequireFeatures(t.config.Model, FeatureSystemRole) != nil {
+		return roleUser
+	}
+	return roleSystem
+}
 
-	t.history = append(t.history, gogpt.ChatCompletionMessage{
+// imageMessage returns the prompt as a user message with the images after it.
+func imageMessage(prompt string, images []sources.Image) gogpt.ChatCompletionMessage {
+	parts := []gogpt.ChatMessagePart{{Type: gogpt.ChatMessagePartTypeText, Text: prompt}}
+	for _, img := range images {
+		parts = append(parts, gogpt.ChatMessagePart{
+			Type: gogpt.ChatMessagePartTypeImageURL,
+			ImageURL: &gogpt.ChatMessageImageURL{
+				URL:    img.RequestURL(),
+				Detail: gogpt.ImageURLDetail(img.Detail),
+			},
+		})
+	}
+
+	return gogpt.ChatCompletionMessage{
+		Role:         roleUser,
+		MultiContent: parts,
+	}
+}
+
+func (t *Thread) pushHistory(role, text string) {
+	msg := gogpt.ChatCompletionMessage{
 		Role:    role,
 		Content: text,
-	})
+	}
+	t.historyTokenCount += t.countMessage(msg)
+	t.history = append(t.history, msg)
 }
 
+// dropHistory drops the oldest messages until at least tokensToDrop tokens were dropped.
 func (t *Thread) dropHistory(tokensToDrop int) {
 	if tokensToDrop >= t.historyTokenCount {
 		t.history = nil
+		t.historyTokenCount = 0
 		return
 	}
 
-	var droppedTokens int
-	var dropToIdx int
-	for i, msg := range t.history {
-		droppedTokens += tokens.MustCount(msg.Content)
-		if droppedTokens >= tokensToDrop {
-			dropToIdx = i
-			break
-		}
+	for len(t.history) > 0 && tokensToDrop > 0 {
+		dropped := t.countMessage(t.history[0])
+		t.history = t.history[1:]
+		t.historyTokenCount -= dropped
+		tokensToDrop -= dropped
 	}
-
-	t.history = t.history[dropToIdx:]
 }
 
+// PurgeSources removes all of the thread's sources, including the ones inherited from its parent. To remove or override only
+// some of them, use the SourceHandles returned when they were added, e.g. with RemoveSource or DisableSource.
 func (t *Thread) PurgeSources() {
-	t.Manager = sources.New(t.ai)
+	t.Manager = t.Manager.WithoutSources()
 }
 
 type Metadata struct {
-	RawResponse     gogpt.ChatCompletionResponse
-	UsedTextSources []sources.TextEmbedding
+	RawResponse      gogpt.ChatCompletionResponse
+	UsedTextSources  []sources.TextEmbedding
+	CitedSources     []sources.TextEmbedding // the sources the answer says it used; only set if citations are enabled
+	UsedImageSources []sources.Image
+	PromptTokens     int                      // the prompt tokens of the request as counted before it was sent, see RawResponse.Usage
+	RetrievalQuery   string                   // the query used to find relevant sources, if UseEmbeddings is true
+	QueryUsage       gogpt.Usage              // the usage of the request that generated RetrievalQuery, if one was made; not in RawResponse.Usage
+	RetrievalReport  *sources.RetrievalReport // why each candidate source text was or wasn't used; only set if Diagnostics is enabled
+	Input            RenderedInput            // the question input as it was sent, if the question has an input
+}
+
+// Usage returns the total usage of the requests made for the answer, including the one that generated the retrieval query.
+func (m Metadata) Usage() gogpt.Usage {
+	return gogpt.Usage{
+		PromptTokens:     m.RawResponse.Usage.PromptTokens + m.QueryUsage.PromptTokens,
+		CompletionTokens: m.RawResponse.Usage.CompletionTokens + m.QueryUsage.CompletionTokens,
+		TotalTokens:      m.RawResponse.Usage.TotalTokens + m.QueryUsage.TotalTokens,
+	}
 }
@@ -0,0 +1,219 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	gogpt "github.com/sashabaranov/go-openai"
+	"github.com/troylelandshields/hardconversations/sources"
+)
+
+// testAPI starts a server that answers every chat completion with answer and records the requests.
+func testAPI(t *testing.T, answer string) (*gogpt.Client, *[]gogpt.ChatCompletionRequest) {
+	var requests []gogpt.ChatCompletionRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req gogpt.ChatCompletionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("error decoding request: %v", err)
+		}
+		requests = append(requests, req)
+
+		_ = json.NewEncoder(w).Encode(gogpt.ChatCompletionResponse{
+			Choices: []gogpt.ChatCompletionChoice{{Message: gogpt.ChatCompletionMessage{Role: roleAssistant, Content: answer}}},
+			Usage:   gogpt.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15},
+		})
+	}))
+	t.Cleanup(srv.Close)
+
+	config := gogpt.DefaultConfig("test")
+	config.BaseURL = srv.URL + "/v1"
+	return gogpt.NewClientWithConfig(config), &requests
+}
+
+func TestExecutePromptMaxTotalTokens(t *testing.T) {
+	ai, requests := testAPI(t, "Paris")
+
+	c := NewClient("test", "Answer in one word.", WithModel(gogpt.GPT4o), WithMaxTotalTokens(400), WithMaxResponseTokens(50))
+	c.ai, c.Thread.ai = ai, ai
+	for i := 0; i < 20; i++ {
+		c.AddSourceText(strings.Repeat("Paris is the capital and largest city of France. ", 3))
+	}
+
+	_, md, err := c.ExecutePrompt(context.Background(), "What is the capital of France?")
+	if err != nil {
+		t.Fatalf("ExecutePrompt() error = %v", err)
+	}
+	if len(*requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(*requests))
+	}
+
+	req := (*requests)[0]
+	if got := c.countMessages(req.Messages); got != md.PromptTokens {
+		t.Errorf("request has %d prompt tokens, Metadata.PromptTokens = %d", got, md.PromptTokens)
+	}
+	if md.PromptTokens+req.MaxTokens > 400 {
+		t.Errorf("request has %d prompt and %d response tokens, more than MaxTotalTokens", md.PromptTokens, req.MaxTokens)
+	}
+	if len(md.UsedTextSources) == 0 || len(md.UsedTextSources) == 20 {
+		t.Errorf("used %d of 20 sources, want some but not all of them", len(md.UsedTextSources))
+	}
+}
+
+func TestExecutePromptTooLong(t *testing.T) {
+	ai, requests := testAPI(t, "Paris")
+
+	c := NewClient("test", "Answer in one word.", WithMaxTotalTokens(150), WithMaxResponseTokens(50))
+	c.ai, c.Thread.ai = ai, ai
+
+	_, _, err := c.ExecutePrompt(context.Background(), strings.Repeat("What is the capital of France? ", 10))
+	if !errors.Is(err, ErrContextLengthExceeded) {
+		t.Errorf("ExecutePrompt() error = %v, want ErrContextLengthExceeded", err)
+	}
+	if len(*requests) != 0 {
+		t.Errorf("got %d requests, want none", len(*requests))
+	}
+	if len(c.history) != 0 || c.historyTokenCount != 0 {
+		t.Errorf("history has %d messages and %d tokens after the failed prompt, want it unchanged", len(c.history), c.historyTokenCount)
+	}
+
+	if _, _, err := c.ExecutePrompt(context.Background(), "Capital of France?"); err != nil {
+		t.Fatalf("ExecutePrompt() of a short prompt error = %v", err)
+	}
+	if got := (*requests)[0].Messages; len(got) != 2 || got[1].Content != "Capital of France?" {
+		t.Errorf("request messages = %+v, want the system message and only the short prompt", got)
+	}
+}
+
+func TestExecutePromptImageTokens(t *testing.T) {
+	ai, requests := testAPI(t, "Paris")
+
+	c := NewClient("test", "Answer in one word.", WithModel(gogpt.GPT4o), WithMaxTotalTokens(400), WithMaxResponseTokens(50))
+	c.ai, c.Thread.ai = ai, ai
+	for i := 0; i < 20; i++ {
+		c.AddSourceText(fmt.Sprintf("Fact %d: %s", i, strings.Repeat("Paris is the capital and largest city of France. ", 3)))
+	}
+	c.AddSourceImage("https://example.com/paris.png", sources.WithImageDetail(sources.ImageDetailLow))
+
+	_, md, err := c.ExecutePrompt(context.Background(), "What is the capital of France?")
+	if err != nil {
+		t.Fatalf("ExecutePrompt() error = %v", err)
+	}
+
+	if len(md.UsedImageSources) != 1 {
+		t.Fatalf("used %d images, want 1", len(md.UsedImageSources))
+	}
+	req := (*requests)[0]
+	if got := c.countRequest(req.Messages, md.UsedImageSources); got != md.PromptTokens || got+req.MaxTokens > 400 {
+		t.Errorf("request has %d prompt tokens with images, Metadata.PromptTokens = %d", got, md.PromptTokens)
+	}
+}
+
+func TestExecutePromptDroppedSourcesReport(t *testing.T) {
+	ai, _ := testAPI(t, "Paris")
+
+	c := NewClient("test", "Answer in one word.", WithModel(gogpt.GPT4o), WithMaxTotalTokens(400), WithMaxResponseTokens(50),
+		WithDiagnostics(), WithSourceHeader(func(sources.TextEmbedding) string { return "a header that isn't in the retrieval budget" }))
+	c.ai, c.Thread.ai = ai, ai
+	for i := 0; i < 20; i++ {
+		c.AddSourceText(fmt.Sprintf("Fact %d: %s", i, strings.Repeat("Paris is the capital and largest city of France. ", 3)),
+			sources.WithName("facts"))
+	}
+
+	_, md, err := c.ExecutePrompt(context.Background(), "What is the capital of France?")
+	if err != nil {
+		t.Fatalf("ExecutePrompt() error = %v", err)
+	}
+
+	if got := len(md.RetrievalReport.Included()); got != len(md.UsedTextSources) {
+		t.Errorf("report includes %d candidates, but %d texts were used", got, len(md.UsedTextSources))
+	}
+}
+
+func TestExecutePromptEmptyGeneratedQuery(t *testing.T) {
+	// a reasoning model can use its whole budget for reasoning and answer with nothing
+	ai, requests := testAPI(t, "")
+
+	c := NewClient("test", "Answer in one word.", WithModel(gogpt.O3Mini), WithUseEmbeddings(true), WithRetrievalQuery(QueryGenerated),
+		WithEmbedder(sources.HashingEmbedder{}))
+	c.ai, c.Thread.ai = ai, ai
+	c.AddSourceText("Paris is the capital and largest city of France.")
+
+	_, md, err := c.ExecutePrompt(context.Background(), "What is the capital of France?")
+	if err != nil {
+		t.Fatalf("ExecutePrompt() error = %v", err)
+	}
+	if len(*requests) != 2 {
+		t.Fatalf("got %d requests, want 2", len(*requests))
+	}
+	if got := (*requests)[0].MaxCompletionTokens; got != maxReasoningGeneratedQueryTokens {
+		t.Errorf("query request has max_completion_tokens %d, want %d", got, maxReasoningGeneratedQueryTokens)
+	}
+	if md.RetrievalQuery != "What is the capital of France?" {
+		t.Errorf("RetrievalQuery = %q, want the prompt", md.RetrievalQuery)
+	}
+	if md.QueryUsage.TotalTokens != 15 || md.Usage().TotalTokens != 30 {
+		t.Errorf("QueryUsage = %+v, Usage() = %+v, want the query request counted", md.QueryUsage, md.Usage())
+	}
+}
+
+func TestExecutePromptMaxSourceTokens(t *testing.T) {
+	ai, _ := testAPI(t, "Paris")
+
+	c := NewClient("test", "Answer in one word.", WithModel("gpt-4.1"), WithMaxSourceTokens(200))
+	c.ai, c.Thread.ai = ai, ai
+	for i := 0; i < 20; i++ {
+		c.AddSourceText(strings.Repeat("Paris is the capital and largest city of France. ", 3))
+	}
+
+	_, md, err := c.ExecutePrompt(context.Background(), "What is the capital of France?")
+	if err != nil {
+		t.Fatalf("ExecutePrompt() error = %v", err)
+	}
+	var sourceTokens int
+	for _, te := range md.UsedTextSources {
+		sourceTokens += te.TokenCount()
+	}
+	if len(md.UsedTextSources) == 0 || sourceTokens > 200 {
+		t.Errorf("used %d sources with %d tokens, want some within MaxSourceTokens", len(md.UsedTextSources), sourceTokens)
+	}
+}
+
+// recordingEmbedder records the texts it embeds.
+type recordingEmbedder struct {
+	sources.HashingEmbedder
+	texts *[]string
+}
+
+func (e recordingEmbedder) Embed(ctx context.Context, texts []string, userID string) ([][]float32, error) {
+	*e.texts = append(*e.texts, texts...)
+	return e.HashingEmbedder.Embed(ctx, texts, userID)
+}
+
+func TestNewThreadSourceOptions(t *testing.T) {
+	ai, _ := testAPI(t, "Paris")
+
+	var parentTexts, childTexts []string
+	c := NewClient("test", "Answer in one word.", WithUseEmbeddings(true), WithCosineSimilarityThreshold(0),
+		WithEmbedder(recordingEmbedder{texts: &parentTexts}))
+	c.ai, c.Thread.ai = ai, ai
+	c.AddSourceText("Paris is the capital and largest city of France.")
+
+	child := c.NewThread(WithEmbedder(recordingEmbedder{texts: &childTexts}), WithDiversity(0.5), WithSourceTimeout(time.Second))
+	if _, _, err := child.ExecutePrompt(context.Background(), "What is the capital of France?"); err != nil {
+		t.Fatalf("ExecutePrompt() error = %v", err)
+	}
+	if len(parentTexts) != 0 || len(childTexts) == 0 {
+		t.Errorf("parent embedder embedded %d texts and child embedder %d, want only the child's used", len(parentTexts), len(childTexts))
+	}
+
+	if got := len(child.config.managerOptions(ai, &c.config)); got != 3 {
+		t.Errorf("managerOptions() returned %d options, want one for each of the 3 changed settings", got)
+	}
+}
@@ -3,20 +3,23 @@ module github.com/troylelandshields/hardconversations
 go 1.19
 
 require (
-	github.com/dlclark/regexp2 v1.7.0 // indirect
-	github.com/drewlanenga/govector v0.0.0-20220726163947-b958ac08bc93 // indirect
+	github.com/dlclark/regexp2 v1.7.0
+	github.com/drewlanenga/govector v0.0.0-20220726163947-b958ac08bc93
+	github.com/pkg/errors v0.9.1
+	github.com/samber/go-gpt-3-encoder v0.3.1
+	github.com/sashabaranov/go-openai v1.38.1
+	github.com/spf13/cobra v1.6.1
+	github.com/spf13/pflag v1.0.5
+	golang.org/x/sync v0.1.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
 	github.com/inconshreveable/mousetrap v1.0.1 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.18 // indirect
-	github.com/pkg/errors v0.9.1 // indirect
 	github.com/rs/zerolog v1.29.0 // indirect
-	github.com/samber/go-gpt-3-encoder v0.3.1 // indirect
 	github.com/samber/lo v1.37.0 // indirect
-	github.com/sashabaranov/go-openai v1.38.1 // indirect
-	github.com/spf13/cobra v1.6.1 // indirect
-	github.com/spf13/pflag v1.0.5 // indirect
 	golang.org/x/exp v0.0.0-20220303212507-bbda1eaf7a17 // indirect
-	golang.org/x/sync v0.1.0 // indirect
 	golang.org/x/sys v0.6.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
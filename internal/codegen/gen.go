@@ -2,6 +2,8 @@ package codegen
 
 import (
 	"bytes"
+	"sort"
+	"strconv"
 	"strings"
 	"text/template"
 
@@ -9,7 +11,7 @@ import (
 )
 
 func ExecuteTemplate(convo config.Conversation) ([]byte, error) {
-	tmpl := template.Must(template.New("tmpl").Parse(templateStr))
+	tmpl := template.Must(template.New("tmpl").Funcs(template.FuncMap{"quote": strconv.Quote}).Parse(templateStr))
 
 	var buf bytes.Buffer
 	tmplData := buildTmplCtx(convo)
@@ -28,25 +30,43 @@ func buildTmplCtx(convo config.Conversation) tmplCtx {
 		if q.InputParsed.ImportPath != "" {
 			imports[q.InputParsed.ImportPath] = struct{}{}
 		}
+		for _, in := range q.Inputs {
+			if in.TypeParsed.ImportPath != "" {
+				imports[in.TypeParsed.ImportPath] = struct{}{}
+			}
+		}
 		if q.OutputParsed.ImportPath != "" {
 			imports[q.OutputParsed.ImportPath] = struct{}{}
 		}
 	}
 
-	var importList []string
+	for _, k := range config.GeneratedImports {
+		imports[k] = struct{}{}
+	}
+
+	// standard library packages are grouped first, like goimports does
+	var stdImports, importList []string
 	for k := range imports {
-		importList = append(importList, k)
+		if strings.Contains(strings.Split(k, "/")[0], ".") {
+			importList = append(importList, k)
+		} else {
+			stdImports = append(stdImports, k)
+		}
 	}
+	sort.Strings(stdImports)
+	sort.Strings(importList)
 
 	return tmplCtx{
 		Conversation: convo,
 		Package:      strings.Trim(convo.Path, "./"),
+		StdImports:   stdImports,
 		Imports:      importList,
 	}
 }
 
 type tmplCtx struct {
 	config.Conversation
-	Package string
-	Imports []string
+	Package    string
+	StdImports []string
+	Imports    []string
 }
@@ -0,0 +1,46 @@
+package codegen
+
+import (
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+
+	"github.com/troylelandshields/hardconversations/internal/config"
+)
+
+func TestExecuteTemplatePromptQuoting(t *testing.T) {
+	no := false
+	convo := config.Conversation{
+		Path: "./ai",
+		Questions: []config.Question{
+			{
+				FunctionName: "Plain",
+				Prompt:       "Answer with `yes` or \"no\"",
+				InputParsed:  &config.ParsedGoType{},
+				OutputParsed: &config.ParsedGoType{TypeName: "string"},
+			},
+			{
+				FunctionName:   "Literal",
+				Prompt:         "Keep the `{{.Name}}` placeholder",
+				PromptTemplate: &no,
+				InputParsed:    &config.ParsedGoType{TypeName: "string"},
+				OutputParsed:   &config.ParsedGoType{TypeName: "string"},
+			},
+		},
+	}
+
+	src, err := ExecuteTemplate(convo)
+	if err != nil {
+		t.Fatalf("ExecuteTemplate() error = %v", err)
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), "client.gen.go", src, 0); err != nil {
+		t.Fatalf("generated code doesn't parse: %v\n%s", err, src)
+	}
+	if !strings.Contains(string(src), `const prompt = "Answer with `+"`yes`"+` or \"no\""`) {
+		t.Errorf("generated code doesn't quote the prompt:\n%s", src)
+	}
+	if strings.Contains(string(src), "promptTemplateLiteral") {
+		t.Errorf("generated code renders a prompt that isn't a template:\n%s", src)
+	}
+}
@@ -9,17 +9,24 @@ import (
 )
 
 type Compiler struct {
-	conf config.Conversation
+	conf    config.Conversation
+	prompts *promptChecker
 }
 
 func NewCompiler(conf config.Conversation) *Compiler {
-	c := &Compiler{conf: conf}
+	c := &Compiler{conf: conf, prompts: newPromptChecker()}
 	return c
 }
 
 func (c *Compiler) Compile(ctx context.Context) ([]CompileResult, error) {
 	var results []CompileResult
 
+	for _, q := range c.conf.Questions {
+		if err := c.prompts.check(q); err != nil {
+			return nil, err
+		}
+	}
+
 	b, err := codegen.ExecuteTemplate(c.conf)
 	if err != nil {
 		return nil, err
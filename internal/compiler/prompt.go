@@ -0,0 +1,311 @@
+package compiler
+
+import (
+	"fmt"
+	"go/importer"
+	"go/token"
+	"go/types"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/troylelandshields/hardconversations/internal/config"
+)
+
+// promptChecker type-checks prompt templates against the types of the question inputs so that a typo in a field name fails
+// `hardc generate` instead of failing at runtime.
+type promptChecker struct {
+	importer types.Importer
+}
+
+func newPromptChecker() *promptChecker {
+	return &promptChecker{
+		importer: importer.ForCompiler(token.NewFileSet(), "source", nil),
+	}
+}
+
+func (c *promptChecker) check(q config.Question) error {
+	if !q.IsPromptTemplate() {
+		return nil
+	}
+
+	tmpl, err := template.New(q.FunctionName).Parse(q.Prompt)
+	if err != nil {
+		return fmt.Errorf("invalid prompt template for %s (set prompt_template to false if it isn't one): %w", q.FunctionName, err)
+	}
+
+	dot, err := c.promptDataType(q)
+	if err != nil {
+		return fmt.Errorf("invalid prompt template for %s: %w", q.FunctionName, err)
+	}
+
+	s := &templateScope{vars: map[string]types.Type{"$": dot}}
+	if err := s.checkList(tmpl.Tree.Root, dot); err != nil {
+		return fmt.Errorf("invalid prompt template for %s: %w", q.FunctionName, err)
+	}
+
+	return nil
+}
+
+// promptDataType returns the type that will be passed to the template: the input itself, or a struct with a field for each of the named inputs.
+func (c *promptChecker) promptDataType(q config.Question) (types.Type, error) {
+	if len(q.Inputs) == 0 {
+		if q.InputParsed == nil || q.InputParsed.TypeName == "" {
+			return nil, fmt.Errorf("prompt templates require an input")
+		}
+		return c.lookupType(q.InputParsed)
+	}
+
+	var fields []*types.Var
+	for _, in := range q.Inputs {
+		t, err := c.lookupType(in.TypeParsed)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, types.NewField(token.NoPos, nil, in.TemplateName(), t, false))
+	}
+
+	return types.NewStruct(fields, nil), nil
+}
+
+func (c *promptChecker) lookupType(parsed *config.ParsedGoType) (types.Type, error) {
+	typeName := parsed.TypeName
+
+	var wrappers []string
+	for {
+		if strings.HasPrefix(typeName, "[]") {
+			wrappers = append(wrappers, "[]")
+			typeName = typeName[2:]
+		} else if strings.HasPrefix(typeName, "*") {
+			wrappers = append(wrappers, "*")
+			typeName = typeName[1:]
+		} else {
+			break
+		}
+	}
+
+	var t types.Type
+	if parsed.ImportPath == "" {
+		obj := types.Universe.Lookup(typeName)
+		if obj == nil {
+			return nil, fmt.Errorf("unknown type %s", parsed.TypeName)
+		}
+		t = obj.Type()
+	} else {
+		pkg, err := c.importer.Import(parsed.ImportPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load package %s: %w", parsed.ImportPath, err)
+		}
+		name := typeName[strings.LastIndex(typeName, ".")+1:]
+		obj := pkg.Scope().Lookup(name)
+		if obj == nil {
+			return nil, fmt.Errorf("type %s not found in %s", name, parsed.ImportPath)
+		}
+		t = obj.Type()
+	}
+
+	for i := len(wrappers) - 1; i >= 0; i-- {
+		if wrappers[i] == "[]" {
+			t = types.NewSlice(t)
+		} else {
+			t = types.NewPointer(t)
+		}
+	}
+
+	return t, nil
+}
+
+// templateScope tracks the types of template variables. A nil type means the type is unknown, e.g. the result of a function,
+// and anything done with it is not checked.
+type templateScope struct {
+	vars map[string]types.Type
+}
+
+func (s *templateScope) checkList(list *parse.ListNode, dot types.Type) error {
+	if list == nil {
+		return nil
+	}
+	for _, node := range list.Nodes {
+		if err := s.checkNode(node, dot); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *templateScope) checkNode(node parse.Node, dot types.Type) error {
+	switch n := node.(type) {
+	case *parse.ActionNode:
+		_, err := s.checkPipe(n.Pipe, dot)
+		return err
+	case *parse.IfNode:
+		if _, err := s.checkPipe(n.Pipe, dot); err != nil {
+			return err
+		}
+		if err := s.checkList(n.List, dot); err != nil {
+			return err
+		}
+		return s.checkList(n.ElseList, dot)
+	case *parse.WithNode:
+		t, err := s.checkPipe(n.Pipe, dot)
+		if err != nil {
+			return err
+		}
+		if err := s.checkList(n.List, t); err != nil {
+			return err
+		}
+		return s.checkList(n.ElseList, dot)
+	case *parse.RangeNode:
+		t, err := s.checkPipe(n.Pipe, dot)
+		if err != nil {
+			return err
+		}
+		key, elem := rangeTypes(t)
+		switch len(n.Pipe.Decl) {
+		case 1:
+			s.vars[n.Pipe.Decl[0].Ident[0]] = elem
+		case 2:
+			s.vars[n.Pipe.Decl[0].Ident[0]] = key
+			s.vars[n.Pipe.Decl[1].Ident[0]] = elem
+		}
+		if err := s.checkList(n.List, elem); err != nil {
+			return err
+		}
+		return s.checkList(n.ElseList, dot)
+	}
+	return nil
+}
+
+func (s *templateScope) checkPipe(pipe *parse.PipeNode, dot types.Type) (types.Type, error) {
+	if pipe == nil {
+		return nil, nil
+	}
+
+	var t types.Type
+	for i, cmd := range pipe.Cmds {
+		var err error
+		t, err = s.checkCommand(cmd, dot)
+		if err != nil {
+			return nil, err
+		}
+		// the result of a pipeline stage is passed to a function, so the type of the whole pipeline is unknown
+		if i > 0 {
+			t = nil
+		}
+	}
+
+	if len(pipe.Decl) == 1 && len(pipe.Cmds) > 0 {
+		s.vars[pipe.Decl[0].Ident[0]] = t
+	}
+
+	return t, nil
+}
+
+func (s *templateScope) checkCommand(cmd *parse.CommandNode, dot types.Type) (types.Type, error) {
+	var t types.Type
+	for i, arg := range cmd.Args {
+		argType, err := s.checkArg(arg, dot)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			t = argType
+		}
+	}
+
+	// function calls like printf or len have an unknown result
+	if len(cmd.Args) > 0 {
+		if _, ok := cmd.Args[0].(*parse.IdentifierNode); ok {
+			return nil, nil
+		}
+	}
+
+	return t, nil
+}
+
+func (s *templateScope) checkArg(arg parse.Node, dot types.Type) (types.Type, error) {
+	switch n := arg.(type) {
+	case *parse.DotNode:
+		return dot, nil
+	case *parse.FieldNode:
+		return lookupFields(dot, n.Ident)
+	case *parse.VariableNode:
+		t, ok := s.vars[n.Ident[0]]
+		if !ok {
+			return nil, fmt.Errorf("undefined variable %s", n.Ident[0])
+		}
+		return lookupFields(t, n.Ident[1:])
+	case *parse.ChainNode:
+		t, err := s.checkArg(n.Node, dot)
+		if err != nil {
+			return nil, err
+		}
+		return lookupFields(t, n.Field)
+	case *parse.PipeNode:
+		return s.checkPipe(n, dot)
+	}
+	return nil, nil
+}
+
+// lookupFields follows a chain of field or method names (e.g. .Candidate.Name) starting from t.
+func lookupFields(t types.Type, names []string) (types.Type, error) {
+	for _, name := range names {
+		if t == nil {
+			return nil, nil
+		}
+
+		underlying := t
+		if ptr, ok := underlying.Underlying().(*types.Pointer); ok {
+			underlying = ptr.Elem()
+		}
+
+		switch u := underlying.Underlying().(type) {
+		case *types.Map:
+			t = u.Elem()
+			continue
+		case *types.Interface:
+			if u.Empty() {
+				t = nil
+				continue
+			}
+		}
+
+		if !token.IsExported(name) {
+			return nil, fmt.Errorf("can't evaluate unexported field %s in type %s", name, t)
+		}
+
+		obj, _, _ := types.LookupFieldOrMethod(t, true, nil, name)
+		switch obj := obj.(type) {
+		case *types.Var:
+			t = obj.Type()
+		case *types.Func:
+			sig := obj.Type().(*types.Signature)
+			if sig.Results().Len() == 0 {
+				return nil, fmt.Errorf("method %s of type %s has no result", name, t)
+			}
+			t = sig.Results().At(0).Type()
+		default:
+			return nil, fmt.Errorf("can't evaluate field %s in type %s", name, t)
+		}
+	}
+	return t, nil
+}
+
+func rangeTypes(t types.Type) (key types.Type, elem types.Type) {
+	if t == nil {
+		return nil, nil
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Slice:
+		return types.Typ[types.Int], u.Elem()
+	case *types.Array:
+		return types.Typ[types.Int], u.Elem()
+	case *types.Map:
+		return u.Key(), u.Elem()
+	case *types.Basic:
+		if u.Info()&types.IsInteger != 0 {
+			return t, t
+		}
+	}
+	return nil, nil
+}
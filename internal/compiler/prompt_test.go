@@ -0,0 +1,63 @@
+package compiler
+
+import (
+	"testing"
+
+	"github.com/troylelandshields/hardconversations/internal/config"
+)
+
+func TestPromptCheckerCheck(t *testing.T) {
+	inputs := []config.QuestionInput{
+		{Name: "candidate", TypeParsed: &config.ParsedGoType{ImportPath: "github.com/troylelandshields/hardconversations/samples/recruiter/resumes", TypeName: "resumes.Candidate"}},
+		{Name: "skills", TypeParsed: &config.ParsedGoType{TypeName: "[]string"}},
+		{Name: "jobTitle", TypeParsed: &config.ParsedGoType{TypeName: "string"}},
+	}
+
+	tests := []struct {
+		name    string
+		prompt  string
+		wantErr bool
+	}{
+		{
+			name:   "not a template",
+			prompt: "Write to the candidate about the job",
+		},
+		{
+			name:   "valid fields",
+			prompt: "Write to {{.Candidate.Name}} at {{ .Candidate.Email }} about {{.JobTitle}}",
+		},
+		{
+			name:   "range and variables",
+			prompt: "{{ range $i, $s := .Skills }}{{ $i }}: {{ $s }} {{ end }}{{ with .Candidate }}{{ .Name }}{{ end }}{{ printf \"%s\" $.JobTitle }}",
+		},
+		{
+			name:    "typo in field",
+			prompt:  "Write to {{.Candidate.Nmae}}",
+			wantErr: true,
+		},
+		{
+			name:    "field of basic type",
+			prompt:  "Write about {{.JobTitle.Name}}",
+			wantErr: true,
+		},
+		{
+			name:    "field inside with",
+			prompt:  "{{ with .Candidate }}{{ .JobTitle }}{{ end }}",
+			wantErr: true,
+		},
+		{
+			name:    "syntax error",
+			prompt:  "Write to {{.Candidate.Name",
+			wantErr: true,
+		},
+	}
+	c := newPromptChecker()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := c.check(config.Question{FunctionName: "Q", Prompt: tt.prompt, Inputs: inputs})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
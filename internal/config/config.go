@@ -5,6 +5,9 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
+	"strings"
+	"text/template"
+	"text/template/parse"
 
 	"gopkg.in/yaml.v3"
 )
@@ -60,15 +63,77 @@ type Conversation struct {
 }
 
 type Question struct {
-	FunctionName string `json:"function_name" yaml:"function_name"`
-	Prompt       string `json:"prompt" yaml:"prompt"`
-	Input        GoType `json:"input" yaml:"input"`
-	Output       GoType `json:"output" yaml:"output"`
+	FunctionName   string          `json:"function_name" yaml:"function_name"`
+	Prompt         string          `json:"prompt" yaml:"prompt"`
+	PromptTemplate *bool           `json:"prompt_template" yaml:"prompt_template"` // defaults to whether Prompt has template actions
+	Input          GoType          `json:"input" yaml:"input"`
+	Inputs         []QuestionInput `json:"inputs" yaml:"inputs"`
+	InputFormat    string          `json:"input_format" yaml:"input_format"`
+	AppendInput    *bool           `json:"append_input" yaml:"append_input"` // defaults to true
+	Output         GoType          `json:"output" yaml:"output"`
 
 	InputParsed  *ParsedGoType
 	OutputParsed *ParsedGoType
 }
 
+// HasInput returns true if the generated function takes any inputs.
+func (q Question) HasInput() bool {
+	return len(q.Inputs) > 0 || (q.InputParsed != nil && q.InputParsed.TypeName != "")
+}
+
+// IsPromptTemplate returns true if the prompt is a Go text/template that references the inputs: prompt_template if it is set,
+// otherwise whether the prompt has any actions. Prompts that don't parse are templates, so that `hardc generate` reports why.
+func (q Question) IsPromptTemplate() bool {
+	if q.PromptTemplate != nil {
+		return *q.PromptTemplate
+	}
+
+	tmpl, err := template.New(q.FunctionName).Parse(q.Prompt)
+	if err != nil {
+		return true
+	}
+	if tmpl.Tree == nil || tmpl.Tree.Root == nil {
+		return false
+	}
+
+	// comments and trim markers leave only text, but change it
+	var text strings.Builder
+	for _, node := range tmpl.Tree.Root.Nodes {
+		textNode, ok := node.(*parse.TextNode)
+		if !ok {
+			return true
+		}
+		text.Write(textNode.Text)
+	}
+	return text.String() != q.Prompt
+}
+
+// AppendsInput returns true if the rendered inputs should be added to the end of the prompt.
+func (q Question) AppendsInput() bool {
+	return q.HasInput() && (q.AppendInput == nil || *q.AppendInput)
+}
+
+// QuestionInput is one of multiple named inputs to a question; each becomes an argument of the generated function.
+type QuestionInput struct {
+	Name     string `json:"name" yaml:"name"`
+	Type     GoType `json:"type" yaml:"type"`
+	Format   string `json:"format" yaml:"format"` // defaults to the question's input_format
+	Optional bool   `json:"optional" yaml:"optional"`
+
+	TypeParsed *ParsedGoType
+}
+
+// GeneratedImports are the packages every generated client imports, in addition to the packages of its questions' types.
+var GeneratedImports = []string{"context", "github.com/troylelandshields/hardconversations/chat"}
+
+// TemplateName is the name used to reference the input in a prompt template, e.g. "resumeText" is {{.ResumeText}}.
+func (in QuestionInput) TemplateName() string {
+	if in.Name == "" {
+		return ""
+	}
+	return strings.ToUpper(in.Name[:1]) + in.Name[1:]
+}
+
 var ErrMissingEngine = errors.New("unknown engine")
 var ErrMissingVersion = errors.New("no version number")
 var ErrNoOutPath = errors.New("no output path")
@@ -87,6 +152,10 @@ var ErrPluginNoType = errors.New("plugin: field `process` or `wasm` required")
 var ErrPluginBothTypes = errors.New("plugin: both `process` and `wasm` cannot both be defined")
 var ErrPluginProcessNoCmd = errors.New("plugin: missing process command")
 
+var ErrUnknownInputFormat = errors.New("invalid input format")
+var ErrInputAndInputs = errors.New("only one of `input` or `inputs` can be set")
+var ErrInvalidInputName = errors.New("invalid input name")
+
 var ErrInvalidQueryParameterLimit = errors.New("invalid query parameter limit")
 
 func ParseConfig(rd io.Reader) (Config, error) {
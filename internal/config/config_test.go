@@ -0,0 +1,30 @@
+package config
+
+import "testing"
+
+func TestQuestionIsPromptTemplate(t *testing.T) {
+	yes, no := true, false
+
+	tests := []struct {
+		name     string
+		prompt   string
+		template *bool
+		want     bool
+	}{
+		{name: "plain text", prompt: "Who is the best fit?"},
+		{name: "field", prompt: "Write to {{.Candidate.Name}}", want: true},
+		{name: "comment", prompt: "Write a message{{/* to the candidate */}}", want: true},
+		{name: "syntax error", prompt: "Write to {{.Candidate.Name", want: true},
+		{name: "not a template", prompt: "Fill in the {{.Name}} placeholder", template: &no},
+		{name: "always a template", prompt: "Who is the best fit?", template: &yes, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := Question{FunctionName: "Ask", Prompt: tt.prompt, PromptTemplate: tt.template}
+			if got := q.IsPromptTemplate(); got != tt.want {
+				t.Errorf("IsPromptTemplate() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
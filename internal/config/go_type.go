@@ -164,6 +164,16 @@ func (gt GoType) Parse() (*ParsedGoType, error) {
 	return &o, nil
 }
 
+// PackageName returns the name the type's package is referred to by in generated code, e.g. "resumes" for []*resumes.Job, or ""
+// for basic types.
+func (o ParsedGoType) PackageName() string {
+	name := strings.TrimLeft(o.TypeName, "[]*")
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		return name[:i]
+	}
+	return ""
+}
+
 // GoStructTag is a raw Go struct tag.
 type GoStructTag string
 
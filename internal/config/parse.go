@@ -1,8 +1,13 @@
 package config
 
 import (
+	"fmt"
+	"go/token"
 	"io"
+	"path"
+	"regexp"
 
+	"github.com/troylelandshields/hardconversations/chat"
 	yaml "gopkg.in/yaml.v3"
 )
 
@@ -24,17 +29,12 @@ func parseConfig(rd io.Reader) (Config, error) {
 	}
 	for i := range conf.Conversations {
 		for j := range conf.Conversations[i].Questions {
-			inParsedType, err := conf.Conversations[i].Questions[j].Input.Parse()
-			if err != nil {
+			if err := parseQuestion(&conf.Conversations[i].Questions[j]); err != nil {
 				return conf, err
 			}
-			conf.Conversations[i].Questions[j].InputParsed = inParsedType
-
-			outParsedType, err := conf.Conversations[i].Questions[j].Output.Parse()
-			if err != nil {
-				return conf, err
-			}
-			conf.Conversations[i].Questions[j].OutputParsed = outParsedType
+		}
+		if err := validateInputNames(conf.Conversations[i]); err != nil {
+			return conf, err
 		}
 	}
 
@@ -83,6 +83,94 @@ func parseConfig(rd io.Reader) (Config, error) {
 	return conf, nil
 }
 
+// reservedInputNames are identifiers used by the generated question functions that can't be used as input names. The names of
+// the packages the generated file imports are reserved too; see validateInputNames.
+var reservedInputNames = map[string]bool{
+	"ctx": true, "t": true, "result": true, "md": true, "err": true,
+	"prompt": true, "parseInstruction": true, "fullPrompt": true, "renderedInput": true, "output": true,
+}
+
+var validInputName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+func parseQuestion(q *Question) error {
+	inParsedType, err := q.Input.Parse()
+	if err != nil {
+		return err
+	}
+	q.InputParsed = inParsedType
+
+	if !chat.InputFormat(q.InputFormat).Valid() {
+		return fmt.Errorf("%w %q for %s, must be one of %v", ErrUnknownInputFormat, q.InputFormat, q.FunctionName, chat.InputFormats)
+	}
+
+	if len(q.Inputs) > 0 && q.InputParsed.TypeName != "" {
+		return fmt.Errorf("%w: %s", ErrInputAndInputs, q.FunctionName)
+	}
+
+	// inputs are also fields of the prompt template's data, by their TemplateName
+	templateNames := map[string]bool{}
+	for k := range q.Inputs {
+		input := &q.Inputs[k]
+		if !validInputName.MatchString(input.Name) || reservedInputNames[input.Name] || token.IsKeyword(input.Name) {
+			return fmt.Errorf("%w %q for %s", ErrInvalidInputName, input.Name, q.FunctionName)
+		}
+		if templateNames[input.TemplateName()] {
+			return fmt.Errorf("%w %q for %s: duplicate name %s", ErrInvalidInputName, input.Name, q.FunctionName, input.TemplateName())
+		}
+		templateNames[input.TemplateName()] = true
+
+		if input.Format == "" {
+			input.Format = q.InputFormat
+		}
+		if !chat.InputFormat(input.Format).Valid() {
+			return fmt.Errorf("%w %q for %s input %s, must be one of %v", ErrUnknownInputFormat, input.Format, q.FunctionName, input.Name, chat.InputFormats)
+		}
+
+		input.TypeParsed, err = input.Type.Parse()
+		if err != nil {
+			return err
+		}
+		if input.TypeParsed.TypeName == "" {
+			return fmt.Errorf("input %s for %s is missing a type", input.Name, q.FunctionName)
+		}
+	}
+
+	outParsedType, err := q.Output.Parse()
+	if err != nil {
+		return err
+	}
+	q.OutputParsed = outParsedType
+
+	return nil
+}
+
+// validateInputNames checks that no input of the conversation's questions shadows a package the generated file imports.
+func validateInputNames(convo Conversation) error {
+	packages := map[string]bool{}
+	for _, imp := range GeneratedImports {
+		packages[path.Base(imp)] = true
+	}
+	for _, q := range convo.Questions {
+		for _, typ := range []*ParsedGoType{q.InputParsed, q.OutputParsed} {
+			if typ != nil {
+				packages[typ.PackageName()] = true
+			}
+		}
+		for _, in := range q.Inputs {
+			packages[in.TypeParsed.PackageName()] = true
+		}
+	}
+
+	for _, q := range convo.Questions {
+		for _, in := range q.Inputs {
+			if packages[in.Name] {
+				return fmt.Errorf("%w %q for %s: it is the name of an imported package", ErrInvalidInputName, in.Name, q.FunctionName)
+			}
+		}
+	}
+	return nil
+}
+
 // func (c *Config) validateGlobalOverrides() error {
 // 	engines := map[Engine]struct{}{}
 // 	for _, pkg := range c.SQL {
@@ -0,0 +1,42 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseConfigInputNames(t *testing.T) {
+	tests := []struct {
+		name    string
+		inputs  string
+		wantErr bool
+	}{
+		{name: "valid", inputs: "[{name: candidate, type: github.com/acme/resumes.Candidate}, {name: resumeText, type: string}]"},
+		{name: "reserved", inputs: "[{name: prompt, type: string}]", wantErr: true},
+		{name: "generated import", inputs: "[{name: chat, type: string}]", wantErr: true},
+		{name: "standard import", inputs: "[{name: context, type: string}]", wantErr: true},
+		{name: "type import", inputs: "[{name: resumes, type: github.com/acme/resumes.Candidate}]", wantErr: true},
+		{name: "duplicate template name", inputs: "[{name: name, type: string}, {name: Name, type: string}]", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yaml := fmt.Sprintf(`version: 1
+conversations:
+  - path: ./ai
+    questions:
+      - function_name: Ask
+        prompt: Who?
+        inputs: %s
+        output: string
+`, tt.inputs)
+
+			_, err := ParseConfig(strings.NewReader(yaml))
+			if tt.wantErr != errors.Is(err, ErrInvalidInputName) {
+				t.Errorf("ParseConfig() error = %v, want invalid input name: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
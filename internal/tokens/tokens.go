@@ -1,65 +1,93 @@
 package tokens
 
 import (
-	"github.com/pkg/errors"
-	tokenizer "github.com/samber/go-gpt-3-encoder"
+	"sync"
+
+	"github.com/troylelandshields/hardconversations/tokens"
 )
 
-var encoder *tokenizer.Encoder
+// Tokenizer is tokens.Tokenizer, so packages that import this one don't also need to import the public package.
+type Tokenizer = tokens.Tokenizer
 
-func init() {
-	var err error
-	encoder, err = tokenizer.NewEncoder()
-	if err != nil {
-		panic(err)
-	}
+// defaultModel is the model whose tokenizer is used when no model is known: the embedding model, since text is chunked to fit it.
+const defaultModel = "text-embedding-3-small"
+
+var (
+	defaultOnce      sync.Once
+	defaultTokenizer Tokenizer
+)
+
+// Default returns the tokenizer of the embedding models, cl100k_base.
+func Default() Tokenizer {
+	defaultOnce.Do(func() {
+		defaultTokenizer = tokens.ForModel(defaultModel)
+	})
+	return defaultTokenizer
 }
 
 func MustCount(t string) int {
-	count, err := Count(t)
+	return tokens.MustCount(Default(), t)
+}
+
+func Count(t string) (int, error) {
+	return tokens.Count(Default(), t)
+}
+
+// CountWith counts the tokens of t with tk, or the default tokenizer if tk is nil.
+func CountWith(tk Tokenizer, t string) (int, error) {
+	if tk == nil {
+		tk = Default()
+	}
+	return tokens.Count(tk, t)
+}
+
+// MustCountWith is like CountWith but panics if t can't be encoded.
+func MustCountWith(tk Tokenizer, t string) int {
+	count, err := CountWith(tk, t)
 	if err != nil {
 		panic(err)
 	}
 	return count
 }
 
-func Count(t string) (int, error) {
-	encoded, err := encoder.Encode(t)
-	if err != nil {
-		return 0, errors.Wrap(err, "error encoding text")
-	}
+func Chunk(t string, maxTokenSize int) ([]string, error) {
+	return ChunkWithOverlap(t, maxTokenSize, 0)
+}
 
-	return len(encoded), nil
+// ChunkWithOverlap splits t into chunks of at most maxTokenSize tokens, where each chunk repeats the last overlap tokens of the previous one.
+func ChunkWithOverlap(t string, maxTokenSize int, overlap int) ([]string, error) {
+	return tokens.Chunk(Default(), t, maxTokenSize, overlap)
 }
 
-func Chunk(t string, maxTokenSize int) ([]string, error) {
-	encoded, err := encoder.Encode(t)
-	if err != nil {
-		return nil, errors.Wrap(err, "error encoding text")
-	}
+// ForModel returns the tokenizer of model; see tokens.ForModel.
+func ForModel(model string) Tokenizer {
+	return tokens.ForModel(model)
+}
 
-	if len(encoded) <= maxTokenSize {
-		return []string{t}, nil
-	}
+// Message is tokens.Message.
+type Message = tokens.Message
 
-	var chunks []string
-	var chunkSize int
-	var chunk string
-	for _, token := range encoded {
-		if chunkSize+1 > maxTokenSize {
-			chunks = append(chunks, chunk)
-			chunkSize = 0
-			chunk = ""
-		}
-
-		str := encoder.Decode([]int{token})
-		chunkSize += 1
-		chunk += str
+// MustCountMessages returns the prompt tokens of a chat request to model with messages, counted with tk or the default
+// tokenizer if tk is nil; see tokens.CountMessages. It panics if the messages can't be encoded.
+func MustCountMessages(tk Tokenizer, model string, messages []Message) int {
+	if tk == nil {
+		tk = Default()
 	}
-
-	if chunk != "" {
-		chunks = append(chunks, chunk)
+	count, err := tokens.CountMessages(tk, model, messages)
+	if err != nil {
+		panic(err)
 	}
+	return count
+}
 
-	return chunks, nil
+// MustCountMessage returns the tokens m adds to a chat request to model, not including the reply priming.
+func MustCountMessage(tk Tokenizer, model string, m Message) int {
+	if tk == nil {
+		tk = Default()
+	}
+	count, err := tokens.MessageFormatForModel(model).CountMessage(tk, m)
+	if err != nil {
+		panic(err)
+	}
+	return count
 }
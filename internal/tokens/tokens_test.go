@@ -86,3 +86,25 @@ func TestCount(t *testing.T) {
 		})
 	}
 }
+
+func TestChunkWithOverlap(t *testing.T) {
+	text := "one two three four five six seven eight nine ten"
+
+	got, err := ChunkWithOverlap(text, 4, 1)
+	if err != nil {
+		t.Fatalf("ChunkWithOverlap() error = %v", err)
+	}
+
+	want := []string{
+		"one two three four",
+		" four five six seven",
+		" seven eight nine ten",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ChunkWithOverlap() = %q, want %q", got, want)
+	}
+
+	if _, err := ChunkWithOverlap(text, 4, 4); err == nil {
+		t.Errorf("ChunkWithOverlap() expected error when overlap is not less than the chunk size")
+	}
+}
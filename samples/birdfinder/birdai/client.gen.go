@@ -18,9 +18,9 @@ type Client struct {
 	*chat.Client
 }
 
-func NewClient(openAIKey string) *Client {
+func NewClient(openAIKey string, opt ...chat.ConfigOption) *Client {
 	c := &Client{
-		Client: chat.NewClient(openAIKey, instruction),
+		Client: chat.NewClient(openAIKey, instruction, opt...),
 	}
 
 	return c
@@ -30,9 +30,15 @@ type Thread struct {
 	*chat.Thread
 }
 
-func (c *Client) NewThread() *Thread {
+func (c *Client) NewThread(opt ...chat.ConfigOption) *Thread {
 	return &Thread{
-		Thread: c.Client.NewThread(),
+		Thread: c.Thread.NewThread(opt...),
+	}
+}
+
+func (c *Thread) NewThread(opt ...chat.ConfigOption) *Thread {
+	return &Thread{
+		Thread: c.Thread.NewThread(opt...),
 	}
 }
 
@@ -40,7 +46,7 @@ func (c *Client) NewThread() *Thread {
 
 // TODO: handle different input and output types, arrays, structs, etc
 func (t *Thread) CountBirds(ctx context.Context, input string) (result int, md chat.Metadata, err error) {
-	const prompt = `How many birds are mentioned in the text?` // TODO initialize text embedding
+	const prompt = "How many birds are mentioned in the text?" // TODO initialize text embedding
 
 	parseInstruction, err := chat.ParseInstruction(result)
 	if err != nil {
@@ -48,14 +54,20 @@ func (t *Thread) CountBirds(ctx context.Context, input string) (result int, md c
 	}
 
 	fullPrompt := parseInstruction + prompt
-	inputStr, err := chat.ConvertInput(input)
+	renderedInput, err := t.Thread.RenderInput(input, "")
 	if err != nil {
 		return result, chat.Metadata{}, err
 	}
-	fullPrompt += "\n" + inputStr
+	fullPrompt += "\n" + renderedInput.Text
 	
 
-	output, md, err := t.Thread.ExecutePrompt(ctx, fullPrompt)
+	output, md, err := t.Thread.ExecuteQuestion(ctx, chat.Question{
+		Name:       "CountBirds",
+		Prompt:     prompt,
+		FullPrompt: fullPrompt,
+		Input:      renderedInput,
+		InputValue: input,
+	})
 	if err != nil {
 		return result, chat.Metadata{}, err
 	}
@@ -72,7 +84,7 @@ func (t *Thread) CountBirds(ctx context.Context, input string) (result int, md c
 
 // TODO: handle different input and output types, arrays, structs, etc
 func (t *Thread) ParseBird(ctx context.Context) (result []bird.Bird, md chat.Metadata, err error) {
-	const prompt = `Can you parse the details of each bird?` // TODO initialize text embedding
+	const prompt = "Can you parse the details of each bird?" // TODO initialize text embedding
 
 	parseInstruction, err := chat.ParseInstruction(result)
 	if err != nil {
@@ -81,7 +93,11 @@ func (t *Thread) ParseBird(ctx context.Context) (result []bird.Bird, md chat.Met
 
 	fullPrompt := parseInstruction + prompt
 
-	output, md, err := t.Thread.ExecutePrompt(ctx, fullPrompt)
+	output, md, err := t.Thread.ExecuteQuestion(ctx, chat.Question{
+		Name:       "ParseBird",
+		Prompt:     prompt,
+		FullPrompt: fullPrompt,
+	})
 	if err != nil {
 		return result, chat.Metadata{}, err
 	}
@@ -98,7 +114,7 @@ func (t *Thread) ParseBird(ctx context.Context) (result []bird.Bird, md chat.Met
 
 // TODO: handle different input and output types, arrays, structs, etc
 func (t *Thread) DescribeBird(ctx context.Context, input bird.Bird) (result string, md chat.Metadata, err error) {
-	const prompt = `Describe the bird with the given properties and add a fun fact (make it up if you have to)` // TODO initialize text embedding
+	const prompt = "Describe the bird with the given properties and add a fun fact (make it up if you have to)" // TODO initialize text embedding
 
 	parseInstruction, err := chat.ParseInstruction(result)
 	if err != nil {
@@ -106,14 +122,20 @@ func (t *Thread) DescribeBird(ctx context.Context, input bird.Bird) (result stri
 	}
 
 	fullPrompt := parseInstruction + prompt
-	inputStr, err := chat.ConvertInput(input)
+	renderedInput, err := t.Thread.RenderInput(input, "markdown")
 	if err != nil {
 		return result, chat.Metadata{}, err
 	}
-	fullPrompt += "\n" + inputStr
+	fullPrompt += "\n" + renderedInput.Text
 	
 
-	output, md, err := t.Thread.ExecutePrompt(ctx, fullPrompt)
+	output, md, err := t.Thread.ExecuteQuestion(ctx, chat.Question{
+		Name:       "DescribeBird",
+		Prompt:     prompt,
+		FullPrompt: fullPrompt,
+		Input:      renderedInput,
+		InputValue: input,
+	})
 	if err != nil {
 		return result, chat.Metadata{}, err
 	}
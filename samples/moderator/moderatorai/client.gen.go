@@ -17,9 +17,9 @@ type Client struct {
 	*chat.Client
 }
 
-func NewClient(openAIKey string) *Client {
+func NewClient(openAIKey string, opt ...chat.ConfigOption) *Client {
 	c := &Client{
-		Client: chat.NewClient(openAIKey, instruction),
+		Client: chat.NewClient(openAIKey, instruction, opt...),
 	}
 
 	return c
@@ -29,9 +29,15 @@ type Thread struct {
 	*chat.Thread
 }
 
-func (c *Client) NewThread() *Thread {
+func (c *Client) NewThread(opt ...chat.ConfigOption) *Thread {
 	return &Thread{
-		Thread: c.Client.NewThread(),
+		Thread: c.Thread.NewThread(opt...),
+	}
+}
+
+func (c *Thread) NewThread(opt ...chat.ConfigOption) *Thread {
+	return &Thread{
+		Thread: c.Thread.NewThread(opt...),
 	}
 }
 
@@ -39,7 +45,7 @@ func (c *Client) NewThread() *Thread {
 
 // TODO: handle different input and output types, arrays, structs, etc
 func (t *Thread) LikelihoodToBreakRules(ctx context.Context, input string) (result int, md chat.Metadata, err error) {
-	const prompt = `How likely is it that the text breaks the rules? (Answer must be an integer between 0 and 100)` // TODO initialize text embedding
+	const prompt = "How likely is it that the text breaks the rules? (Answer must be an integer between 0 and 100)" // TODO initialize text embedding
 
 	parseInstruction, err := chat.ParseInstruction(result)
 	if err != nil {
@@ -47,14 +53,20 @@ func (t *Thread) LikelihoodToBreakRules(ctx context.Context, input string) (resu
 	}
 
 	fullPrompt := parseInstruction + prompt
-	inputStr, err := chat.ConvertInput(input)
+	renderedInput, err := t.Thread.RenderInput(input, "")
 	if err != nil {
 		return result, chat.Metadata{}, err
 	}
-	fullPrompt += "\n" + inputStr
+	fullPrompt += "\n" + renderedInput.Text
 	
 
-	output, md, err := t.Thread.ExecutePrompt(ctx, fullPrompt)
+	output, md, err := t.Thread.ExecuteQuestion(ctx, chat.Question{
+		Name:       "LikelihoodToBreakRules",
+		Prompt:     prompt,
+		FullPrompt: fullPrompt,
+		Input:      renderedInput,
+		InputValue: input,
+	})
 	if err != nil {
 		return result, chat.Metadata{}, err
 	}
@@ -71,7 +83,7 @@ func (t *Thread) LikelihoodToBreakRules(ctx context.Context, input string) (resu
 
 // TODO: handle different input and output types, arrays, structs, etc
 func (t *Thread) WhichRulesDoesItBreak(ctx context.Context) (result []int, md chat.Metadata, err error) {
-	const prompt = `Which rule numbers does the text break? (Answer must be a comma-separated list of integers)` // TODO initialize text embedding
+	const prompt = "Which rule numbers does the text break? (Answer must be a comma-separated list of integers)" // TODO initialize text embedding
 
 	parseInstruction, err := chat.ParseInstruction(result)
 	if err != nil {
@@ -80,7 +92,11 @@ func (t *Thread) WhichRulesDoesItBreak(ctx context.Context) (result []int, md ch
 
 	fullPrompt := parseInstruction + prompt
 
-	output, md, err := t.Thread.ExecutePrompt(ctx, fullPrompt)
+	output, md, err := t.Thread.ExecuteQuestion(ctx, chat.Question{
+		Name:       "WhichRulesDoesItBreak",
+		Prompt:     prompt,
+		FullPrompt: fullPrompt,
+	})
 	if err != nil {
 		return result, chat.Metadata{}, err
 	}
@@ -97,7 +113,7 @@ func (t *Thread) WhichRulesDoesItBreak(ctx context.Context) (result []int, md ch
 
 // TODO: handle different input and output types, arrays, structs, etc
 func (t *Thread) WhyDoesItBreakTheRules(ctx context.Context) (result string, md chat.Metadata, err error) {
-	const prompt = `Why does it break the rules?` // TODO initialize text embedding
+	const prompt = "Why does it break the rules?" // TODO initialize text embedding
 
 	parseInstruction, err := chat.ParseInstruction(result)
 	if err != nil {
@@ -106,7 +122,11 @@ func (t *Thread) WhyDoesItBreakTheRules(ctx context.Context) (result string, md
 
 	fullPrompt := parseInstruction + prompt
 
-	output, md, err := t.Thread.ExecutePrompt(ctx, fullPrompt)
+	output, md, err := t.Thread.ExecuteQuestion(ctx, chat.Question{
+		Name:       "WhyDoesItBreakTheRules",
+		Prompt:     prompt,
+		FullPrompt: fullPrompt,
+	})
 	if err != nil {
 		return result, chat.Metadata{}, err
 	}
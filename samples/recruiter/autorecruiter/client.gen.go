@@ -45,8 +45,8 @@ func (c *Thread) NewThread(opt ...chat.ConfigOption) *Thread {
 
 
 // TODO: handle different input and output types, arrays, structs, etc
-func (t *Thread) RankResumes(ctx context.Context, input string) (result []int, md chat.Metadata, err error) {
-	const prompt = `Return just the IDs of between 1 and 3 resumes in a comma-separated list, ranked from best to worst fit for the job description. Do not include resumes that are not a good fit.` // TODO initialize text embedding
+func (t *Thread) RankResumes(ctx context.Context, input resumes.Job) (result []int, md chat.Metadata, err error) {
+	const prompt = "Return just the IDs of between 1 and 3 resumes in a comma-separated list, ranked from best to worst fit for the job description. Do not include resumes that are not a good fit." // TODO initialize text embedding
 
 	parseInstruction, err := chat.ParseInstruction(result)
 	if err != nil {
@@ -54,14 +54,20 @@ func (t *Thread) RankResumes(ctx context.Context, input string) (result []int, m
 	}
 
 	fullPrompt := parseInstruction + prompt
-	inputStr, err := chat.ConvertInput(input)
+	renderedInput, err := t.Thread.RenderInput(input, "")
 	if err != nil {
 		return result, chat.Metadata{}, err
 	}
-	fullPrompt += "\n" + inputStr
+	fullPrompt += "\n" + renderedInput.Text
 	
 
-	output, md, err := t.Thread.ExecutePrompt(ctx, fullPrompt)
+	output, md, err := t.Thread.ExecuteQuestion(ctx, chat.Question{
+		Name:       "RankResumes",
+		Prompt:     prompt,
+		FullPrompt: fullPrompt,
+		Input:      renderedInput,
+		InputValue: input,
+	})
 	if err != nil {
 		return result, chat.Metadata{}, err
 	}
@@ -78,7 +84,7 @@ func (t *Thread) RankResumes(ctx context.Context, input string) (result []int, m
 
 // TODO: handle different input and output types, arrays, structs, etc
 func (t *Thread) GetCandidateInfo(ctx context.Context, input string) (result resumes.Candidate, md chat.Metadata, err error) {
-	const prompt = `Return the candidate info from the resume` // TODO initialize text embedding
+	const prompt = "Return the candidate info from the resume" // TODO initialize text embedding
 
 	parseInstruction, err := chat.ParseInstruction(result)
 	if err != nil {
@@ -86,14 +92,20 @@ func (t *Thread) GetCandidateInfo(ctx context.Context, input string) (result res
 	}
 
 	fullPrompt := parseInstruction + prompt
-	inputStr, err := chat.ConvertInput(input)
+	renderedInput, err := t.Thread.RenderInput(input, "")
 	if err != nil {
 		return result, chat.Metadata{}, err
 	}
-	fullPrompt += "\n" + inputStr
+	fullPrompt += "\n" + renderedInput.Text
 	
 
-	output, md, err := t.Thread.ExecutePrompt(ctx, fullPrompt)
+	output, md, err := t.Thread.ExecuteQuestion(ctx, chat.Question{
+		Name:       "GetCandidateInfo",
+		Prompt:     prompt,
+		FullPrompt: fullPrompt,
+		Input:      renderedInput,
+		InputValue: input,
+	})
 	if err != nil {
 		return result, chat.Metadata{}, err
 	}
@@ -108,9 +120,18 @@ func (t *Thread) GetCandidateInfo(ctx context.Context, input string) (result res
 
 
 
+var promptTemplateGenerateRecruiterMessage = chat.MustParsePromptTemplate("GenerateRecruiterMessage", "Generate a message to send to {{.Candidate.Name}} about the job; mention what you like about their resume and why you think they would be a good fit for the job.")
+
 // TODO: handle different input and output types, arrays, structs, etc
-func (t *Thread) GenerateRecruiterMessage(ctx context.Context, input resumes.RecruiterMessageRequest) (result resumes.Email, md chat.Metadata, err error) {
-	const prompt = `Generate a message to send to the candidate about the job; mention what you like about their resume and why you think they would be a good fit for the job.` // TODO initialize text embedding
+func (t *Thread) GenerateRecruiterMessage(ctx context.Context, candidate resumes.Candidate, resumeText string) (result resumes.Email, md chat.Metadata, err error) {
+	prompt, err := chat.ExecutePromptTemplate(promptTemplateGenerateRecruiterMessage, struct {
+		Candidate resumes.Candidate
+		ResumeText string
+		
+	}{ candidate, resumeText, })
+	if err != nil {
+		return result, chat.Metadata{}, err
+	}
 
 	parseInstruction, err := chat.ParseInstruction(result)
 	if err != nil {
@@ -118,14 +139,23 @@ func (t *Thread) GenerateRecruiterMessage(ctx context.Context, input resumes.Rec
 	}
 
 	fullPrompt := parseInstruction + prompt
-	inputStr, err := chat.ConvertInput(input)
+	renderedInput, err := t.Thread.RenderInputs(
+		chat.NamedInput{Name: "candidate", Value: candidate, Format: "", Optional: false},
+		chat.NamedInput{Name: "resumeText", Value: resumeText, Format: "", Optional: false},
+	)
 	if err != nil {
 		return result, chat.Metadata{}, err
 	}
-	fullPrompt += "\n" + inputStr
+	fullPrompt += "\n" + renderedInput.Text
 	
 
-	output, md, err := t.Thread.ExecutePrompt(ctx, fullPrompt)
+	output, md, err := t.Thread.ExecuteQuestion(ctx, chat.Question{
+		Name:       "GenerateRecruiterMessage",
+		Prompt:     prompt,
+		FullPrompt: fullPrompt,
+		Input:      renderedInput,
+		InputValue: map[string]interface{}{"candidate": candidate, "resumeText": resumeText},
+	})
 	if err != nil {
 		return result, chat.Metadata{}, err
 	}
@@ -30,24 +30,24 @@ func main() {
 	aiRecruiter := autorecruiter.NewClient(openAIKey, chat.WithUseEmbeddings(true), chat.WithCosineSimilarityThreshold(0.7))
 
 	// returns all in-state resumes
-	aiRecruiter.AddSourceTextProvider(resumes.ResumeProvider{})
+	inState := aiRecruiter.AddSourceTextQueryProvider(resumes.ResumeProvider{}, sources.WithName("In-state resumes"), sources.WithDescription("preferred"))
 
 	// out of state resumes are less likely to be a good fit, so we'll provide them but with a lower weight.
-	aiRecruiter.AddSourceTextProvider(resumes.OutOfStateResumeProvider{}, sources.WithWeight(0.95))
+	outOfState := aiRecruiter.AddSourceTextQueryProvider(resumes.OutOfStateResumeProvider{}, sources.WithWeight(0.95), sources.WithName("Out-of-state resumes"))
 
 	ctx := context.Background()
-	ctx = context.WithValue(ctx, "job_state", jobState)
 
 	t := aiRecruiter.NewThread()
 
-	rankedResumeIDs, _, err := t.RankResumes(ctx, developerJobDescription)
+	rankedResumeIDs, _, err := t.RankResumes(ctx, resumes.Job{Description: developerJobDescription, State: jobState})
 	if err != nil {
 		fmt.Println("error:", err)
 		os.Exit(1)
 	}
 
-	// don't need resume sources anymore so we can remove it.
-	t.PurgeSources()
+	// don't need the resume sources anymore so we can remove them from this thread.
+	t.RemoveSource(inState)
+	t.RemoveSource(outOfState)
 
 	for _, id := range rankedResumeIDs {
 		resume, err := resumes.LookupResume(id)
@@ -62,10 +62,7 @@ func main() {
 			continue
 		}
 
-		msg, _, err := t.GenerateRecruiterMessage(ctx, resumes.RecruiterMessageRequest{
-			Candidate:  candidate,
-			ResumeText: resume.Text,
-		})
+		msg, _, err := t.GenerateRecruiterMessage(ctx, candidate, resume.Text)
 		if err != nil {
 			fmt.Println("error generating recruiter message, will try next one", err)
 			continue
@@ -3,6 +3,8 @@ package resumes
 import (
 	"context"
 	"fmt"
+
+	"github.com/troylelandshields/hardconversations/sources"
 )
 
 var (
@@ -56,16 +58,26 @@ func LookupResume(id int) (Resume, error) {
 	return Resume{}, fmt.Errorf("no resume with id %d", id)
 }
 
+// jobFromQuery returns the Job that resumes are being found for, which is the input of the question.
+func jobFromQuery(q sources.SourceQuery) (Job, error) {
+	job, ok := q.Input.(Job)
+	if !ok {
+		return Job{}, fmt.Errorf("question %s has no job input", q.Question)
+	}
+	return job, nil
+}
+
+// ResumeProvider returns the resumes from the job's state.
 type ResumeProvider struct {
 }
 
-func (r ResumeProvider) Sources(ctx context.Context, prompt string) ([]string, error) {
-	state, ok := ctx.Value("job_state").(string)
-	if !ok {
-		return nil, fmt.Errorf("no job state in context")
+func (r ResumeProvider) SourcesForQuery(ctx context.Context, q sources.SourceQuery) ([]string, error) {
+	job, err := jobFromQuery(q)
+	if err != nil {
+		return nil, err
 	}
 
-	resumes, err := LookupResumes(state)
+	resumes, err := LookupResumes(job.State)
 	if err != nil {
 		return nil, err
 	}
@@ -78,18 +90,19 @@ func (r ResumeProvider) Sources(ctx context.Context, prompt string) ([]string, e
 	return result, nil
 }
 
+// OutOfStateResumeProvider returns the resumes from every state except the job's.
 type OutOfStateResumeProvider struct {
 }
 
-func (r OutOfStateResumeProvider) Sources(ctx context.Context, prompt string) ([]string, error) {
-	jobState, ok := ctx.Value("job_state").(string)
-	if !ok {
-		return nil, fmt.Errorf("no job state in context")
+func (r OutOfStateResumeProvider) SourcesForQuery(ctx context.Context, q sources.SourceQuery) ([]string, error) {
+	job, err := jobFromQuery(q)
+	if err != nil {
+		return nil, err
 	}
 
 	var result []string
 	for _, state := range allStates {
-		if jobState == state {
+		if job.State == state {
 			continue
 		}
 
@@ -1,7 +1,6 @@
 package resumes
 
 import (
-	"fmt"
 	"strconv"
 )
 
@@ -29,22 +28,23 @@ func (r Resume) PromptInput() string {
 	return "ResumeID: " + strconv.Itoa(r.ID) + "\n" + r.Text
 }
 
-type Candidate struct {
-	Name  string
-	Email string
+// Job is a job opening that resumes are ranked for; only resumes from the job's state are preferred.
+type Job struct {
+	Description string
+	State       string
 }
 
-type RecruiterMessageRequest struct {
-	Candidate  Candidate
-	ResumeText string
+func (j Job) PromptInput() string {
+	return j.Description
 }
 
-func (r RecruiterMessageRequest) PromptInput() string {
-	return fmt.Sprintf("Candidate: %s\nEmail: %s\n Resume: %s", r.Candidate.Name, r.Candidate.Email, r.ResumeText)
+type Candidate struct {
+	Name  string `json:"name" hardc-instruction:"the candidate's full name as written on the resume"`
+	Email string `json:"email" hardc-instruction:"the candidate's email address exactly as written on the resume"`
 }
 
 type Email struct {
-	To      string
-	Subject string
-	Body    string
+	To      string `json:"to" hardc-instruction:"the candidate's email address"`
+	Subject string `json:"subject" hardc-instruction:"a short subject line that mentions the job title"`
+	Body    string `json:"body" hardc-instruction:"the full message, addressed to the candidate by name and signed by the recruiter"`
 }
@@ -0,0 +1,170 @@
+package sources
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+
+	defaultRRFK = 60
+)
+
+// FusionMethod is how the lexical (BM25) and semantic (embedding) scores of a text are combined.
+type FusionMethod string
+
+const (
+	// FusionWeightedSum adds the cosine similarity and the BM25 score (divided by the best BM25 score of the source), each times its weight.
+	FusionWeightedSum FusionMethod = "weighted_sum"
+	// FusionReciprocalRank adds weight/(K+rank) for the text's rank by cosine similarity and its rank by BM25 score.
+	FusionReciprocalRank FusionMethod = "rrf"
+)
+
+// Fusion configures hybrid retrieval, which ranks source texts by both their embeddings and BM25 keyword matching so that
+// exact matches on names, IDs or skills aren't missed. Texts that match the query's keywords well enough (see MinLexicalScore)
+// are kept even when their cosine similarity is below the threshold.
+type Fusion struct {
+	Method         FusionMethod // defaults to FusionWeightedSum
+	SemanticWeight float64      // weight of the embedding score; if both weights are 0, both default to 0.5
+	LexicalWeight  float64      // weight of the BM25 score
+	K              int          // rank constant for FusionReciprocalRank, defaults to 60
+	// MinLexicalScore is the fraction of the best possible BM25 score for the query that a text needs to be kept below the
+	// similarity threshold, so sharing a common word like "with" isn't enough; defaults to 0.5, and more than 1 never keeps them.
+	MinLexicalScore float64
+}
+
+const defaultMinLexicalScore = 0.5
+
+func (f Fusion) minLexicalScore() float64 {
+	if f.MinLexicalScore == 0 {
+		return defaultMinLexicalScore
+	}
+	return f.MinLexicalScore
+}
+
+func (f Fusion) weights() (semantic float64, lexical float64) {
+	if f.SemanticWeight == 0 && f.LexicalWeight == 0 {
+		return 0.5, 0.5
+	}
+	return f.SemanticWeight, f.LexicalWeight
+}
+
+// fuse combines the cosine similarities and BM25 scores of a source's texts into a score between 0 and 1 for each.
+func (f Fusion) fuse(semantic []float64, lexical []float64) []float64 {
+	semanticWeight, lexicalWeight := f.weights()
+	fused := make([]float64, len(semantic))
+	if len(semantic) == 0 || semanticWeight+lexicalWeight == 0 {
+		return fused
+	}
+
+	if f.Method == FusionReciprocalRank {
+		k := f.K
+		if k <= 0 {
+			k = defaultRRFK
+		}
+		semanticRanks := ranks(semantic)
+		lexicalRanks := ranks(lexical)
+		// divide by the best possible score so scores can be compared with other sources
+		best := (semanticWeight + lexicalWeight) / float64(k+1)
+		for i := range fused {
+			score := semanticWeight / float64(k+semanticRanks[i])
+			if lexical[i] > 0 {
+				score += lexicalWeight / float64(k+lexicalRanks[i])
+			}
+			fused[i] = score / best
+		}
+		return fused
+	}
+
+	var maxLexical float64
+	for _, l := range lexical {
+		maxLexical = math.Max(maxLexical, l)
+	}
+	for i := range fused {
+		score := semanticWeight * semantic[i]
+		if maxLexical > 0 {
+			score += lexicalWeight * lexical[i] / maxLexical
+		}
+		fused[i] = score / (semanticWeight + lexicalWeight)
+	}
+	return fused
+}
+
+// ranks returns the 1-based rank of each score, highest first; equal scores share a rank.
+func ranks(scores []float64) []int {
+	r := make([]int, len(scores))
+	for i, s := range scores {
+		r[i] = 1
+		for _, other := range scores {
+			if other > s {
+				r[i]++
+			}
+		}
+	}
+	return r
+}
+
+// bm25Scores scores each text for query with Okapi BM25, using the texts themselves as the corpus. best is the score of a
+// text of average length that has every query term found in the corpus once, which scores can be normalized with.
+func bm25Scores(query string, texts []string) (scores []float64, best float64) {
+	scores = make([]float64, len(texts))
+	if len(texts) == 0 {
+		return scores, 0
+	}
+
+	queryTerms := map[string]bool{}
+	for _, term := range lexicalTerms(query) {
+		queryTerms[term] = true
+	}
+
+	termFreqs := make([]map[string]int, len(texts))
+	docLens := make([]int, len(texts))
+	docFreqs := map[string]int{}
+	var totalLen int
+	for i, text := range texts {
+		terms := lexicalTerms(text)
+		freqs := map[string]int{}
+		for _, term := range terms {
+			if queryTerms[term] {
+				freqs[term]++
+			}
+		}
+		for term := range freqs {
+			docFreqs[term]++
+		}
+		termFreqs[i] = freqs
+		docLens[i] = len(terms)
+		totalLen += len(terms)
+	}
+
+	avgLen := float64(totalLen) / float64(len(texts))
+	if avgLen == 0 {
+		return scores, 0
+	}
+
+	n := float64(len(texts))
+	idf := func(term string) float64 {
+		df := float64(docFreqs[term])
+		return math.Log(1 + (n-df+0.5)/(df+0.5))
+	}
+	for i, freqs := range termFreqs {
+		for term, tf := range freqs {
+			f := float64(tf)
+			scores[i] += idf(term) * f * (bm25K1 + 1) / (f + bm25K1*(1-bm25B+bm25B*float64(docLens[i])/avgLen))
+		}
+	}
+	for term := range docFreqs {
+		best += idf(term)
+	}
+	return scores, best
+}
+
+// lexicalTerms splits text into lowercase words and numbers.
+func lexicalTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
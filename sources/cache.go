@@ -0,0 +1,207 @@
+package sources
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"github.com/troylelandshields/hardconversations/logger"
+)
+
+const defaultEmbeddingCacheSize = 1000
+
+// EmbeddingCache stores embeddings so the same text doesn't need to be embedded again. Keys are created with EmbeddingCacheKey.
+// Implementations must be safe for concurrent use.
+type EmbeddingCache interface {
+	Get(key string) ([]float32, bool)
+	Set(key string, embedding []float32)
+}
+
+// EmbeddingCacheKey returns the cache key for text embedded with model: the model name plus a hash of the text.
+func EmbeddingCacheKey(model string, text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return model + ":" + hex.EncodeToString(sum[:])
+}
+
+// EmbeddingCacheStats are the number of cache hits and misses since the Manager (and any Managers created from it) was created.
+type EmbeddingCacheStats struct {
+	Hits   uint64
+	Misses uint64
+}
+
+// countingCache wraps an EmbeddingCache to count hits and misses; it is shared between a Manager and the Managers created from it.
+type countingCache struct {
+	cache  EmbeddingCache
+	hits   uint64
+	misses uint64
+}
+
+func (c *countingCache) Get(key string) ([]float32, bool) {
+	if c == nil || c.cache == nil {
+		return nil, false
+	}
+
+	embedding, ok := c.cache.Get(key)
+	if ok {
+		atomic.AddUint64(&c.hits, 1)
+	} else {
+		atomic.AddUint64(&c.misses, 1)
+	}
+	return embedding, ok
+}
+
+func (c *countingCache) Set(key string, embedding []float32) {
+	if c == nil || c.cache == nil {
+		return
+	}
+	c.cache.Set(key, embedding)
+}
+
+func (c *countingCache) stats() EmbeddingCacheStats {
+	if c == nil {
+		return EmbeddingCacheStats{}
+	}
+	return EmbeddingCacheStats{
+		Hits:   atomic.LoadUint64(&c.hits),
+		Misses: atomic.LoadUint64(&c.misses),
+	}
+}
+
+// LRUEmbeddingCache is an in-memory EmbeddingCache that evicts the least recently used embedding once it is full.
+type LRUEmbeddingCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type lruEntry struct {
+	key       string
+	embedding []float32
+}
+
+// NewLRUEmbeddingCache returns an in-memory cache that holds up to size embeddings.
+func NewLRUEmbeddingCache(size int) *LRUEmbeddingCache {
+	return &LRUEmbeddingCache{
+		size:    size,
+		entries: map[string]*list.Element{},
+		order:   list.New(),
+	}
+}
+
+func (c *LRUEmbeddingCache) Get(key string) ([]float32, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*lruEntry).embedding, true
+}
+
+func (c *LRUEmbeddingCache) Set(key string, embedding []float32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*lruEntry).embedding = embedding
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, embedding: embedding})
+	for c.size > 0 && c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).key)
+	}
+}
+
+// Len returns the number of embeddings in the cache.
+func (c *LRUEmbeddingCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// DiskEmbeddingCache is an EmbeddingCache that persists embeddings in a directory, one file per embedding, so they survive restarts.
+type DiskEmbeddingCache struct {
+	dir string
+}
+
+// NewDiskEmbeddingCache returns a cache that stores embeddings in dir, creating it if it doesn't exist.
+func NewDiskEmbeddingCache(dir string) (*DiskEmbeddingCache, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating embedding cache directory")
+	}
+	return &DiskEmbeddingCache{dir: dir}, nil
+}
+
+func (c *DiskEmbeddingCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(c.dir, name[:2], name)
+}
+
+func (c *DiskEmbeddingCache) Get(key string) ([]float32, bool) {
+	b, err := os.ReadFile(c.path(key))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Debugf("Error reading embedding cache: %v", err)
+		}
+		return nil, false
+	}
+
+	if len(b)%4 != 0 {
+		logger.Debugf("Embedding cache file for %s is corrupt, ignoring", key)
+		return nil, false
+	}
+
+	embedding := make([]float32, len(b)/4)
+	for i := range embedding {
+		embedding[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[i*4:]))
+	}
+	return embedding, true
+}
+
+func (c *DiskEmbeddingCache) Set(key string, embedding []float32) {
+	b := make([]byte, len(embedding)*4)
+	for i, f := range embedding {
+		binary.LittleEndian.PutUint32(b[i*4:], math.Float32bits(f))
+	}
+
+	path := c.path(key)
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		logger.Debugf("Error writing embedding cache: %v", err)
+		return
+	}
+
+	// write to a temp file and rename so concurrent readers never see a partial embedding
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		logger.Debugf("Error writing embedding cache: %v", err)
+		return
+	}
+	_, err = tmp.Write(b)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		logger.Debugf("Error writing embedding cache: %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+package sources
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLRUEmbeddingCache(t *testing.T) {
+	c := NewLRUEmbeddingCache(2)
+	c.Set("a", []float32{1})
+	c.Set("b", []float32{2})
+
+	// using "a" makes "b" the least recently used, so it's evicted when "c" is added
+	if _, ok := c.Get("a"); !ok {
+		t.Fatalf("Get(a) missing")
+	}
+	c.Set("c", []float32{3})
+
+	if _, ok := c.Get("b"); ok {
+		t.Errorf("Get(b) should have been evicted")
+	}
+	if got, ok := c.Get("c"); !ok || !reflect.DeepEqual(got, []float32{3}) {
+		t.Errorf("Get(c) = %v, %v", got, ok)
+	}
+	if c.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", c.Len())
+	}
+}
+
+func TestDiskEmbeddingCache(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewDiskEmbeddingCache(dir)
+	if err != nil {
+		t.Fatalf("NewDiskEmbeddingCache() error = %v", err)
+	}
+
+	key := EmbeddingCacheKey("model", "some text")
+	if _, ok := c.Get(key); ok {
+		t.Errorf("Get() found embedding in empty cache")
+	}
+
+	want := []float32{0.5, -1.25, 3}
+	c.Set(key, want)
+
+	// a new cache in the same directory sees the embedding
+	c, err = NewDiskEmbeddingCache(dir)
+	if err != nil {
+		t.Fatalf("NewDiskEmbeddingCache() error = %v", err)
+	}
+	if got, ok := c.Get(key); !ok || !reflect.DeepEqual(got, want) {
+		t.Errorf("Get() = %v, %v, want %v", got, ok, want)
+	}
+	if _, ok := c.Get(EmbeddingCacheKey("other-model", "some text")); ok {
+		t.Errorf("Get() found embedding for a different model")
+	}
+}
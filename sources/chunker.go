@@ -0,0 +1,254 @@
+package sources
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/troylelandshields/hardconversations/internal/tokens"
+)
+
+// Chunker splits source text that is too long to embed (or to be useful as a single source) into smaller chunks.
+type Chunker interface {
+	Chunk(text string) ([]string, error)
+}
+
+// TokenChunker splits text purely by token count, so chunks can start and end mid-word.
+type TokenChunker struct {
+	Size    int // max tokens per chunk
+	Overlap int // tokens repeated from the end of the previous chunk
+}
+
+func (c TokenChunker) Chunk(text string) ([]string, error) {
+	return tokens.ChunkWithOverlap(text, c.Size, c.Overlap)
+}
+
+// SentenceChunker packs whole sentences into chunks of up to Size tokens. Sentences longer than Size are split by tokens.
+type SentenceChunker struct {
+	Size    int // max tokens per chunk
+	Overlap int // up to this many tokens of whole sentences are repeated from the end of the previous chunk
+}
+
+var sentenceEnd = regexp.MustCompile(`[.!?]+["')\]]*\s+`)
+
+func (c SentenceChunker) Chunk(text string) ([]string, error) {
+	return packUnits(splitAfter(text, sentenceEnd), c.Size, c.Overlap)
+}
+
+// ParagraphChunker packs whole paragraphs (separated by blank lines) into chunks of up to Size tokens. Paragraphs longer than
+// Size are split by sentences.
+type ParagraphChunker struct {
+	Size    int // max tokens per chunk
+	Overlap int // up to this many tokens of whole paragraphs are repeated from the end of the previous chunk
+}
+
+var paragraphEnd = regexp.MustCompile(`\n[ \t]*\n\s*`)
+
+func (c ParagraphChunker) Chunk(text string) ([]string, error) {
+	var units []string
+	for _, paragraph := range splitAfter(text, paragraphEnd) {
+		cnt, err := tokens.Count(paragraph)
+		if err != nil {
+			return nil, err
+		}
+		if cnt <= c.Size {
+			units = append(units, paragraph)
+			continue
+		}
+		units = append(units, splitAfter(paragraph, sentenceEnd)...)
+	}
+	return packUnits(units, c.Size, c.Overlap)
+}
+
+// MarkdownChunker splits Markdown into sections at headings and packs each section's paragraphs into chunks of up to Size tokens.
+// Every chunk of a section starts with the section's heading so it has context on its own.
+type MarkdownChunker struct {
+	Size    int // max tokens per chunk
+	Overlap int // up to this many tokens of whole paragraphs are repeated from the end of the previous chunk in the same section
+}
+
+var markdownHeading = regexp.MustCompile(`(?m)^#{1,6}[ \t]+\S.*$`)
+
+func (c MarkdownChunker) Chunk(text string) ([]string, error) {
+	var chunks []string
+
+	headings := markdownHeading.FindAllStringIndex(text, -1)
+	sectionStarts := []int{0}
+	for _, h := range headings {
+		if h[0] != 0 {
+			sectionStarts = append(sectionStarts, h[0])
+		}
+	}
+
+	for i, start := range sectionStarts {
+		end := len(text)
+		if i+1 < len(sectionStarts) {
+			end = sectionStarts[i+1]
+		}
+		section := text[start:end]
+		if strings.TrimSpace(section) == "" {
+			continue
+		}
+
+		var heading string
+		if loc := markdownHeading.FindStringIndex(section); loc != nil && loc[0] == 0 {
+			heading = section[:loc[1]]
+			section = strings.TrimLeft(section[loc[1]:], "\n")
+		}
+
+		headingTokens, err := tokens.Count(heading + "\n")
+		if err != nil {
+			return nil, err
+		}
+		size := c.Size - headingTokens
+		if heading == "" {
+			size = c.Size
+		}
+		if size <= c.Overlap {
+			return nil, errors.Errorf("chunk size %d is too small for heading %q", c.Size, heading)
+		}
+
+		sectionChunks, err := ParagraphChunker{Size: size, Overlap: c.Overlap}.Chunk(section)
+		if err != nil {
+			return nil, err
+		}
+		if len(sectionChunks) == 0 && heading != "" {
+			sectionChunks = []string{""}
+		}
+
+		for _, chunk := range sectionChunks {
+			if heading != "" {
+				chunk = heading + "\n" + chunk
+			}
+			chunks = append(chunks, chunk)
+		}
+	}
+
+	return chunks, nil
+}
+
+// splitAfter splits text after each match of sep, keeping the separator with the preceding unit.
+func splitAfter(text string, sep *regexp.Regexp) []string {
+	var units []string
+	start := 0
+	for _, loc := range sep.FindAllStringIndex(text, -1) {
+		units = append(units, text[start:loc[1]])
+		start = loc[1]
+	}
+	if start < len(text) {
+		units = append(units, text[start:])
+	}
+	return units
+}
+
+// packUnits greedily packs units into chunks of up to size tokens, starting each chunk with up to overlap tokens of whole units
+// from the end of the previous chunk. Units bigger than size are split by tokens.
+func packUnits(units []string, size int, overlap int) ([]string, error) {
+	if size <= 0 {
+		return nil, errors.New("chunk size must be positive")
+	}
+	if overlap < 0 || overlap >= size {
+		return nil, errors.New("overlap must be at least 0 and less than the chunk size")
+	}
+
+	type unit struct {
+		text   string
+		tokens int
+	}
+
+	var all []unit
+	for _, u := range units {
+		if u == "" {
+			continue
+		}
+		cnt, err := tokens.Count(u)
+		if err != nil {
+			return nil, err
+		}
+		if cnt <= size {
+			all = append(all, unit{text: u, tokens: cnt})
+			continue
+		}
+
+		pieces, err := tokens.ChunkWithOverlap(u, size, 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range pieces {
+			cnt, err := tokens.Count(p)
+			if err != nil {
+				return nil, err
+			}
+			all = append(all, unit{text: p, tokens: cnt})
+		}
+	}
+
+	var chunks []string
+	var current []unit
+	var currentTokens int
+	newUnits := 0 // units in current that weren't carried over from the previous chunk
+
+	flush := func() {
+		var sb strings.Builder
+		for _, u := range current {
+			sb.WriteString(u.text)
+		}
+		chunks = append(chunks, strings.TrimSpace(sb.String()))
+
+		// carry over whole units from the end of the chunk, up to overlap tokens
+		var carried []unit
+		var carriedTokens int
+		for i := len(current) - 1; i >= 0; i-- {
+			if carriedTokens+current[i].tokens > overlap {
+				break
+			}
+			carried = append([]unit{current[i]}, carried...)
+			carriedTokens += current[i].tokens
+		}
+		current = carried
+		currentTokens = carriedTokens
+		newUnits = 0
+	}
+
+	for _, u := range all {
+		if newUnits > 0 && currentTokens+u.tokens > size {
+			flush()
+		}
+		// the carried over units and this one don't fit together, so drop carried units until they do
+		for len(current) > 0 && currentTokens+u.tokens > size {
+			currentTokens -= current[0].tokens
+			current = current[1:]
+		}
+		current = append(current, u)
+		currentTokens += u.tokens
+		newUnits++
+	}
+	if newUnits > 0 {
+		flush()
+	}
+
+	return chunks, nil
+}
+
+// chunkIdentifier returns a unique identifier for chunk i of a text. The text's identifier is always escaped so that it never
+// contains "#", which means identifiers of chunks can't collide with each other or with identifiers of texts that weren't split.
+func chunkIdentifier(identifier string, i int, totalChunks int) string {
+	if totalChunks <= 1 {
+		return escapeIdentifier(identifier)
+	}
+	return escapeIdentifier(identifier) + "#" + strconv.Itoa(i)
+}
+
+// sectionIdentifier returns a unique identifier for section i of a text, which is escaped like chunkIdentifier so it can't
+// collide with the identifiers of chunks.
+func sectionIdentifier(identifier string, i int, totalSections int) string {
+	if totalSections <= 1 {
+		return escapeIdentifier(identifier)
+	}
+	return escapeIdentifier(identifier) + "#section-" + strconv.Itoa(i)
+}
+
+func escapeIdentifier(identifier string) string {
+	return strings.NewReplacer("%", "%25", "#", "%23").Replace(identifier)
+}
@@ -0,0 +1,127 @@
+package sources
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/troylelandshields/hardconversations/internal/tokens"
+)
+
+func TestChunkers(t *testing.T) {
+	sentences := strings.Repeat("The quick brown fox jumps over the lazy dog. ", 20)
+
+	tests := []struct {
+		name    string
+		chunker Chunker
+		text    string
+		check   func(t *testing.T, chunks []string)
+	}{
+		{
+			name:    "sentences are kept whole",
+			chunker: SentenceChunker{Size: 25},
+			text:    sentences,
+			check: func(t *testing.T, chunks []string) {
+				if len(chunks) != 10 {
+					t.Errorf("expected 10 chunks, got %d", len(chunks))
+				}
+				for _, c := range chunks {
+					if !strings.HasPrefix(c, "The quick") || !strings.HasSuffix(c, "dog.") {
+						t.Errorf("chunk splits a sentence: %q", c)
+					}
+				}
+			},
+		},
+		{
+			name:    "sentence overlap",
+			chunker: SentenceChunker{Size: 25, Overlap: 10},
+			text:    "One two three. Four five six. Seven eight nine. Ten eleven twelve.",
+			check: func(t *testing.T, chunks []string) {
+				for i := 1; i < len(chunks); i++ {
+					last := chunks[i-1][strings.LastIndex(chunks[i-1], ". ")+2:]
+					if !strings.HasPrefix(chunks[i], last) {
+						t.Errorf("chunk %d %q does not start with the end of the previous chunk %q", i, chunks[i], last)
+					}
+				}
+			},
+		},
+		{
+			name:    "paragraphs",
+			chunker: ParagraphChunker{Size: 10},
+			text:    "First paragraph here.\n\nSecond paragraph here.\n\nThird paragraph here.",
+			check: func(t *testing.T, chunks []string) {
+				if len(chunks) != 2 || chunks[0] != "First paragraph here.\n\nSecond paragraph here." {
+					t.Errorf("unexpected chunks %q", chunks)
+				}
+			},
+		},
+		{
+			name:    "markdown headings are repeated",
+			chunker: MarkdownChunker{Size: 20},
+			text:    "Intro.\n\n# Skills\n\n" + strings.Repeat("Go is a language. ", 6) + "\n\n## Jobs\n\nEngineer.",
+			check: func(t *testing.T, chunks []string) {
+				if chunks[0] != "Intro." {
+					t.Errorf("expected intro first, got %q", chunks[0])
+				}
+				var skills int
+				for _, c := range chunks[1 : len(chunks)-1] {
+					if !strings.HasPrefix(c, "# Skills\n") {
+						t.Errorf("chunk missing heading: %q", c)
+					}
+					skills++
+				}
+				if skills < 2 {
+					t.Errorf("expected the skills section to be split, got %q", chunks)
+				}
+				if chunks[len(chunks)-1] != "## Jobs\nEngineer." {
+					t.Errorf("unexpected last chunk %q", chunks[len(chunks)-1])
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks, err := tt.chunker.Chunk(tt.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, c := range chunks {
+				if n := tokens.MustCount(c); n > 25 {
+					t.Errorf("chunk has %d tokens: %q", n, c)
+				}
+			}
+			tt.check(t, chunks)
+		})
+	}
+}
+
+func TestChunkIdentifier(t *testing.T) {
+	seen := map[string]bool{}
+	for _, id := range []struct {
+		identifier string
+		i, total   int
+	}{
+		{"doc", 0, 1},
+		{"doc", 0, 2},
+		{"doc", 1, 2},
+		{"doc#1", 0, 1},
+		{"doc#1", 0, 2},
+		{"doc%231", 0, 1},
+		{"doc%231", 0, 2},
+		{"doc--1", 0, 1},
+		{"doc--1", 1, 2},
+	} {
+		got := chunkIdentifier(id.identifier, id.i, id.total)
+		if seen[got] {
+			t.Errorf("duplicate identifier %q", got)
+		}
+		seen[got] = true
+	}
+	for i, identifier := range []string{"doc", "doc#1", "doc%231"} {
+		got := sectionIdentifier(identifier, i, 3)
+		if seen[got] {
+			t.Errorf("duplicate identifier %q", got)
+		}
+		seen[got] = true
+	}
+}
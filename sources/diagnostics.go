@@ -0,0 +1,160 @@
+package sources
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// Disposition is what happened to a candidate text during retrieval.
+type Disposition string
+
+const (
+	DispositionIncluded       Disposition = "included"
+	DispositionBelowThreshold Disposition = "below threshold"
+	DispositionProviderBudget Disposition = "provider budget exhausted" // the source's WithMaxTokens was used up
+	DispositionGlobalBudget   Disposition = "global budget exhausted"   // the request's AllowedTokens were used up
+	DispositionProviderError  Disposition = "provider error"
+)
+
+const maxReportTextLength = 60
+
+// CandidateReport explains why a text was or wasn't used.
+type CandidateReport struct {
+	// Provider is the source's name, or the type of its provider if it wasn't added WithName
+	Provider string
+	// Text is the candidate; it is empty for provider errors
+	Text TextEmbedding
+	// CosineSimilarity is the raw similarity between the text and the query; 1 for sources added WithSkipEmbeddings, and 0 when
+	// the request isn't sorted by relevance
+	CosineSimilarity float64
+	Weight           float64
+	// Score is what texts are ranked by: the cosine similarity (or fused score) times Weight
+	Score       float64
+	Tokens      int
+	Disposition Disposition
+	// Err is the provider's error, for DispositionProviderError
+	Err error
+
+	id int // the order the candidate was added in, which stays the same when the candidates are sorted
+}
+
+// RetrievalReport lists every candidate text considered by a retrieval. When sorting by relevance they are in order of Score,
+// otherwise in the order of the sources.
+type RetrievalReport struct {
+	Query      string
+	Candidates []CandidateReport
+}
+
+// Included returns the candidates that were used.
+func (r *RetrievalReport) Included() []CandidateReport {
+	var included []CandidateReport
+	for _, c := range r.Candidates {
+		if c.Disposition == DispositionIncluded {
+			included = append(included, c)
+		}
+	}
+	return included
+}
+
+// Print writes the report as a table, with one candidate per row.
+func (r *RetrievalReport) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if r.Query != "" {
+		fmt.Fprintf(tw, "Query: %s\n", truncateForReport(r.Query))
+	}
+	fmt.Fprintln(tw, "PROVIDER\tIDENTIFIER\tCOSINE\tWEIGHT\tSCORE\tTOKENS\tDISPOSITION\tTEXT")
+	for _, c := range r.Candidates {
+		text := truncateForReport(c.Text.Text)
+		if c.Err != nil {
+			text = c.Err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%.3f\t%.2f\t%.3f\t%d\t%s\t%s\n",
+			c.Provider, c.Text.Identifier, c.CosineSimilarity, c.Weight, c.Score, c.Tokens, c.Disposition, text)
+	}
+	return tw.Flush()
+}
+
+// String returns the report as printed by Print.
+func (r *RetrievalReport) String() string {
+	var sb strings.Builder
+	_ = r.Print(&sb)
+	return sb.String()
+}
+
+func truncateForReport(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if len(text) > maxReportTextLength {
+		return text[:maxReportTextLength-3] + "..."
+	}
+	return text
+}
+
+// Drop marks the included candidates that dropped texts were selected for with d, for texts that were retrieved but left out of
+// the request afterwards, e.g. because the rendered sources didn't fit.
+func (r *RetrievalReport) Drop(dropped []TextEmbedding, d Disposition) {
+	if r == nil {
+		return
+	}
+	ids := map[int]bool{}
+	for _, te := range dropped {
+		for _, id := range te.candidates {
+			ids[id] = true
+		}
+	}
+	for i, c := range r.Candidates {
+		if c.Disposition == DispositionIncluded && ids[c.id] {
+			r.Candidates[i].Disposition = d
+		}
+	}
+}
+
+// RetrieveWithReport is like Retrieve, but also reports every candidate text and why it was or wasn't used.
+func (t *Manager) RetrieveWithReport(ctx context.Context, req RetrievalRequest) ([]TextEmbedding, *RetrievalReport, error) {
+	report := &RetrievalReport{}
+	texts, err := t.retrieve(ctx, req, report)
+	if err != nil {
+		return nil, nil, err
+	}
+	return texts, report, nil
+}
+
+// add appends a candidate to the report and returns its index; it does nothing for a nil report, so retrieval doesn't need to
+// check whether a report was requested.
+func (r *RetrievalReport) add(c CandidateReport) int {
+	if r == nil {
+		return -1
+	}
+	c.id = len(r.Candidates)
+	r.Candidates = append(r.Candidates, c)
+	return c.id
+}
+
+func (r *RetrievalReport) setDisposition(i int, d Disposition) {
+	if r == nil || i < 0 {
+		return
+	}
+	r.Candidates[i].Disposition = d
+}
+
+// providerName returns the source's name, or the type of the provider it was added with.
+func providerName(s source[TextEmbeddingProvider]) string {
+	if s.name != "" {
+		return s.name
+	}
+
+	var provider interface{} = s.provider
+	switch p := s.provider.(type) {
+	case textProviderAdapter:
+		provider = p.TextProvider
+	case textQueryProviderAdapter:
+		provider = p.TextQueryProvider
+	case queryProviderAdapter:
+		provider = p.QueryProvider
+	case hardCodedTextProvider:
+		return "text"
+	}
+	return fmt.Sprintf("%T", provider)
+}
@@ -0,0 +1,113 @@
+package sources
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type errorTextProvider struct{}
+
+func (errorTextProvider) Sources(ctx context.Context, prompt string) ([]string, error) {
+	return nil, errors.New("database is down")
+}
+
+func TestManagerRetrieveWithReport(t *testing.T) {
+	m := New(nil, WithDefaultEmbedder(HashingEmbedder{}), WithEmbeddingCache(nil))
+	m.AddSourceTextProvider(staticTextProvider{"Go developer", "Go engineer"}, WithName("In-state"), WithSkipEmbeddings(), WithMaxTokens(2))
+	m.AddSourceTextProvider(staticTextProvider{"Go programmer in Utah"}, WithName("Out-of-state"), WithSkipEmbeddings(), WithWeight(0.9))
+	m.AddSourceTextProvider(staticTextProvider{"Go intern in Texas"}, WithSkipEmbeddings(), WithWeight(0.1))
+	m.AddSourceTextProvider(errorTextProvider{}, WithAllowErrors(), WithWeight(0.05))
+
+	tests := []struct {
+		name string
+		req  RetrievalRequest
+		want []string
+	}{
+		{
+			name: "relevant",
+			req:  RetrievalRequest{SortByRelevance: true, MinCosineSimilarity: 0.5, AllowedTokens: 4, Prompt: "Go"},
+			want: []string{
+				"In-state Go developer included",
+				"In-state Go engineer provider budget exhausted",
+				"Out-of-state Go programmer in Utah global budget exhausted",
+				"sources.staticTextProvider Go intern in Texas below threshold",
+				"sources.errorTextProvider  provider error",
+			},
+		},
+		{
+			name: "simple",
+			req:  RetrievalRequest{AllowedTokens: 4, Prompt: "Go"},
+			want: []string{
+				"In-state Go developer included",
+				"In-state Go engineer provider budget exhausted",
+				"Out-of-state Go programmer in Utah global budget exhausted",
+				"sources.staticTextProvider Go intern in Texas global budget exhausted",
+				"sources.errorTextProvider  provider error",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, report, err := m.RetrieveWithReport(context.Background(), tt.req)
+			if err != nil {
+				t.Fatalf("RetrieveWithReport() error = %v", err)
+			}
+			if len(got) != 1 || got[0].Text != "Go developer" {
+				t.Errorf("RetrieveWithReport() = %v, want only Go developer", got)
+			}
+
+			if len(report.Candidates) != len(tt.want) {
+				t.Fatalf("report has %d candidates, want %d:\n%s", len(report.Candidates), len(tt.want), report)
+			}
+			for i, c := range report.Candidates {
+				if s := c.Provider + " " + c.Text.Text + " " + string(c.Disposition); s != tt.want[i] {
+					t.Errorf("candidate %d = %q, want %q", i, s, tt.want[i])
+				}
+			}
+
+			if printed := report.String(); !strings.Contains(printed, "DISPOSITION") || !strings.Contains(printed, "database is down") {
+				t.Errorf("String() = %q, want a table with the provider error", printed)
+			}
+		})
+	}
+}
+
+func TestRetrievalReportDrop(t *testing.T) {
+	m := New(nil, WithDefaultEmbedder(HashingEmbedder{}), WithEmbeddingCache(nil))
+	// the second text contains the first, so only the candidate a text was selected for may be dropped with it
+	m.AddSourceTextProvider(staticTextProvider{"Go developer", "Go developer in Utah"}, WithName("resumes"), WithSkipEmbeddings())
+
+	for _, req := range []RetrievalRequest{
+		{SortByRelevance: true, AllowedTokens: 100, Prompt: "Go"},
+		{AllowedTokens: 100, Prompt: "Go"},
+	} {
+		got, report, err := m.RetrieveWithReport(context.Background(), req)
+		if err != nil {
+			t.Fatalf("RetrieveWithReport() error = %v", err)
+		}
+		if len(got) != 2 {
+			t.Fatalf("RetrieveWithReport() = %v, want both texts", got)
+		}
+
+		var dropped TextEmbedding
+		for _, te := range got {
+			if te.Text == "Go developer in Utah" {
+				dropped = te
+			}
+		}
+		report.Drop([]TextEmbedding{dropped}, DispositionGlobalBudget)
+
+		for _, c := range report.Candidates {
+			want := DispositionIncluded
+			if c.Text.Text == dropped.Text {
+				want = DispositionGlobalBudget
+			}
+			if c.Disposition != want {
+				t.Errorf("sorted by relevance %t: %q is %s, want %s", req.SortByRelevance, c.Text.Text, c.Disposition, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package sources
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+	gogpt "github.com/sashabaranov/go-openai"
+)
+
+// Embedder creates embeddings for text. Model identifies the model (and any settings that change the embeddings, like the
+// dimensions) so that embeddings from different models are never compared or cached under the same key.
+type Embedder interface {
+	Model() string
+	Embed(ctx context.Context, texts []string, userID string) ([][]float32, error)
+}
+
+// OpenAIEmbedder creates embeddings with the OpenAI embeddings API.
+type OpenAIEmbedder struct {
+	ai         *gogpt.Client
+	model      gogpt.EmbeddingModel
+	dimensions int
+}
+
+// NewOpenAIEmbedder returns an Embedder that uses the given OpenAI model. If dimensions is 0, the model's default is used;
+// otherwise the embeddings are shortened to that many dimensions (only supported by text-embedding-3 and later).
+func NewOpenAIEmbedder(openAIClient *gogpt.Client, model string, dimensions int) *OpenAIEmbedder {
+	return &OpenAIEmbedder{
+		ai:         openAIClient,
+		model:      gogpt.EmbeddingModel(model),
+		dimensions: dimensions,
+	}
+}
+
+func (e *OpenAIEmbedder) Model() string {
+	if e.dimensions == 0 {
+		return string(e.model)
+	}
+	return string(e.model) + "@" + strconv.Itoa(e.dimensions)
+}
+
+func (e *OpenAIEmbedder) Embed(ctx context.Context, texts []string, userID string) ([][]float32, error) {
+	resp, err := e.ai.CreateEmbeddings(ctx, gogpt.EmbeddingRequest{
+		Input:      texts,
+		Model:      e.model,
+		User:       userID,
+		Dimensions: e.dimensions,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	embeddings := make([][]float32, len(texts))
+	for _, embedding := range resp.Data {
+		if embedding.Index < 0 || embedding.Index >= len(texts) {
+			return nil, errors.Errorf("embedding response has unexpected index %d", embedding.Index)
+		}
+		embeddings[embedding.Index] = embedding.Embedding
+	}
+	for i := range embeddings {
+		if embeddings[i] == nil {
+			return nil, errors.Errorf("embedding response is missing index %d", i)
+		}
+	}
+
+	return embeddings, nil
+}
+
+// HashingEmbedder is an offline Embedder that hashes words and word pairs into a fixed number of dimensions. It only captures
+// lexical similarity, but it is deterministic and needs no network access, so it is useful for tests and air-gapped environments.
+type HashingEmbedder struct {
+	Dimensions int // defaults to 512
+}
+
+func (e HashingEmbedder) dimensions() int {
+	if e.Dimensions <= 0 {
+		return 512
+	}
+	return e.Dimensions
+}
+
+func (e HashingEmbedder) Model() string {
+	return "hashing@" + strconv.Itoa(e.dimensions())
+}
+
+func (e HashingEmbedder) Embed(ctx context.Context, texts []string, userID string) ([][]float32, error) {
+	embeddings := make([][]float32, len(texts))
+	for i, text := range texts {
+		embeddings[i] = e.embed(text)
+	}
+	return embeddings, nil
+}
+
+func (e HashingEmbedder) embed(text string) []float32 {
+	dims := e.dimensions()
+	embedding := make([]float32, dims)
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	add := func(feature string, weight float32) {
+		h := fnv.New64a()
+		h.Write([]byte(feature))
+		sum := h.Sum64()
+		// use one bit of the hash as the sign so that collisions tend to cancel out instead of adding up
+		if sum&(1<<63) != 0 {
+			weight = -weight
+		}
+		embedding[sum%uint64(dims)] += weight
+	}
+
+	for i, word := range words {
+		add(word, 1)
+		if i > 0 {
+			add(words[i-1]+" "+word, 0.5)
+		}
+	}
+
+	var norm float64
+	for _, f := range embedding {
+		norm += float64(f) * float64(f)
+	}
+	if norm == 0 {
+		return embedding
+	}
+	norm = math.Sqrt(norm)
+	for i := range embedding {
+		embedding[i] = float32(float64(embedding[i]) / norm)
+	}
+
+	return embedding
+}
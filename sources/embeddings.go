@@ -2,8 +2,6 @@ package sources
 
 import (
 	"context"
-	"sort"
-	"strconv"
 	"strings"
 
 	"github.com/drewlanenga/govector"
@@ -13,11 +11,15 @@ import (
 )
 
 const (
-	maxEmbeddingTokenCount = 2048
+	maxEmbeddingTokenCount  = 2048
+	maxEmbeddingBatchSize   = 2048   // the most inputs OpenAI accepts in one embeddings request
+	maxEmbeddingBatchTokens = 300000 // the most tokens OpenAI accepts in one embeddings request, across all of its inputs
+	defaultEmbeddingModel   = gogpt.SmallEmbedding3
 )
 
 type TextEmbedding struct {
-	// Identifier that uniquely identifies this text embedding (e.g., a pageID or documentID). If this embedding is chunked, this Identifier will have the chunk idx appended to it
+	// Identifier that uniquely identifies this text embedding (e.g., a pageID or documentID). If this embedding is chunked, this Identifier will have
+	// "#" and the chunk idx appended to it (with any "%" and "#" in the original escaped), and ParentIdentifier returns the original Identifier
 	Identifier string
 	// Text that can be used as a data source for the AI
 	Text string
@@ -29,9 +31,47 @@ type TextEmbedding struct {
 	// Optional weight to use for this specific source text; defaults to the weight of the source provider
 	Weight float64
 
-	chunk       int // if the text is chunked, this is the chunk number
-	totalChunks int
-	tokenCount  int
+	// Scores are set by the Manager when the text is selected by relevance, so callers can see why it was used
+	Scores *RetrievalScores `json:",omitempty"`
+
+	sourceName        string
+	sourceDescription string
+	parentIdentifier  string
+	parentText        string // the text or section this chunk was split from
+	sectionIdentifier string // identifier of parentText, which is parentIdentifier unless the text was split into sections
+	document          int    // index of the text this chunk was split from in what its provider returned
+	chunk             int    // if the text is chunked, this is the chunk number
+	totalChunks       int
+	tokenCount        int
+	candidates        []int // ids of the RetrievalReport candidates the text was selected for
+}
+
+// RetrievalScores are the scores a text was selected with, so it is clear why it was used.
+type RetrievalScores struct {
+	Semantic float64 // cosine similarity between the text and the prompt (1.0 if the source skips embeddings)
+	Lexical  float64 // BM25 score of the text for the prompt; only set for sources that use Fusion
+	Weight   float64 // weight of the text or its source
+	Score    float64 // the combined score times Weight that texts are ranked by
+	// Redundancy is the highest cosine similarity to a text ranked before this one; only set when re-ranking with WithMMR
+	Redundancy float64
+}
+
+// SourceName returns the name of the source this text came from, set with WithName.
+func (t *TextEmbedding) SourceName() string {
+	return t.sourceName
+}
+
+// SourceDescription returns the description of the source this text came from, set with WithDescription.
+func (t *TextEmbedding) SourceDescription() string {
+	return t.sourceDescription
+}
+
+// ParentIdentifier returns the Identifier of the text this chunk was split from.
+func (t *TextEmbedding) ParentIdentifier() string {
+	if t.totalChunks == 0 && t.parentIdentifier == "" {
+		return t.Identifier
+	}
+	return t.parentIdentifier
 }
 
 func (t *TextEmbedding) TokenCount() int {
@@ -51,18 +91,11 @@ type TextEmbeddingProvider interface {
 }
 
 // TODO: figure out how to make this a good idea and make it public if so
-func (t *Manager) AddSourceTextEmbeddingProvider(provider TextEmbeddingProvider, opt ...SourceOption[TextEmbeddingProvider]) {
-	source := source[TextEmbeddingProvider]{provider: provider, weight: 1.0}
-	for _, o := range opt {
-		o(&source)
-	}
-
-	t.textProviders = append(t.textProviders, source)
-
-	// sort by weight
-	sort.Slice(t.textProviders, func(i, j int) bool {
-		return t.textProviders[i].weight > t.textProviders[j].weight
-	})
+func (t *Manager) AddSourceTextEmbeddingProvider(provider TextEmbeddingProvider, opt ...SourceOption[TextEmbeddingProvider]) SourceHandle {
+	source := newSource(provider, opt)
+	source.id = nextSourceID()
+	t.addSource(source)
+	return SourceHandle{id: source.id}
 }
 
 func (t *Manager) CreateTextEmbeddingsFromStrings(ctx context.Context, text []string, userID string) ([]TextEmbedding, error) {
@@ -75,20 +108,45 @@ func (t *Manager) CreateTextEmbeddingsFromStrings(ctx context.Context, text []st
 }
 
 func (t *Manager) CreateTextEmbeddings(ctx context.Context, textEmbeddings []TextEmbedding, userID string) ([]TextEmbedding, error) {
-	return t.prepareForQuerying(ctx, textEmbeddings, userID, false)
+	return t.prepareForQuerying(ctx, t.embedder, t.chunker, textEmbeddings, userID, false)
 }
 
 // TODO: handle userID another way
-func (t *Manager) prepareForQuerying(ctx context.Context, textEmbeddings []TextEmbedding, userID string, skipEmbeddings bool) ([]TextEmbedding, error) {
-	var inputs []string
+func (t *Manager) prepareForQuerying(ctx context.Context, embedder Embedder, chunker Chunker, textEmbeddings []TextEmbedding, userID string, skipEmbeddings bool) ([]TextEmbedding, error) {
+	results, err := chunkTexts(t.tokenizer, chunker, nil, textEmbeddings)
+	if err != nil {
+		return nil, err
+	}
+	if skipEmbeddings {
+		return results, nil
+	}
+
+	pending := make([]*TextEmbedding, len(results))
+	for i := range results {
+		pending[i] = &results[i]
+	}
+	err = t.embedTexts(ctx, embedder, pending, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// chunkTexts splits texts that don't have an embedding yet into chunks and counts the tokens of every text with tk. If
+// sectionChunker is set, texts are first split into sections with it, and then each section is split with chunker.
+func chunkTexts(tk tokens.Tokenizer, chunker Chunker, sectionChunker Chunker, textEmbeddings []TextEmbedding) ([]TextEmbedding, error) {
 	var results []TextEmbedding
 	var err error
-	for _, te := range textEmbeddings {
+	for document, te := range textEmbeddings {
 		if len(te.Embedding) > 0 {
-			te.tokenCount, err = tokens.Count(te.Text)
+			te.tokenCount, err = tokens.CountWith(tk, te.Text)
 			if err != nil {
 				return nil, errors.Wrap(err, "error counting tokens")
 			}
+			te.document = document
+			te.parentIdentifier = te.Identifier
+			te.Identifier = chunkIdentifier(te.Identifier, 0, 1)
 			results = append(results, te)
 			continue
 		}
@@ -96,51 +154,113 @@ func (t *Manager) prepareForQuerying(ctx context.Context, textEmbeddings []TextE
 		identifier := te.Identifier
 		text := te.Text
 
-		chunks, err := tokens.Chunk(text, maxEmbeddingTokenCount)
-		if err != nil {
-			return nil, errors.Wrap(err, "error chunking text")
+		sections := []string{text}
+		if sectionChunker != nil {
+			sections, err = sectionChunker.Chunk(text)
+			if err != nil {
+				return nil, errors.Wrap(err, "error chunking text")
+			}
+		}
+
+		var chunks, parents []string
+		var sectionIdxs []int
+		for s, section := range sections {
+			sectionChunks, err := chunker.Chunk(section)
+			if err != nil {
+				return nil, errors.Wrap(err, "error chunking text")
+			}
+			for _, chunk := range sectionChunks {
+				chunks = append(chunks, chunk)
+				parents = append(parents, section)
+				sectionIdxs = append(sectionIdxs, s)
+			}
 		}
 
 		for i, chunk := range chunks {
-			tokenCnt, err := tokens.Count(chunk)
+			tokenCnt, err := tokens.CountWith(tk, chunk)
 			if err != nil {
 				return nil, errors.Wrap(err, "error counting tokens")
 			}
-			inputs = append(inputs, textEmbeddingPrep(chunk))
 			results = append(results, TextEmbedding{
-				Identifier:  identifier + "--" + strconv.Itoa(i),
-				Text:        chunk,
-				Weight:      te.Weight,
-				Metadata:    te.Metadata,
-				chunk:       i,
-				totalChunks: len(chunks),
-				tokenCount:  tokenCnt,
+				Identifier:        chunkIdentifier(identifier, i, len(chunks)),
+				Text:              chunk,
+				Weight:            te.Weight,
+				Metadata:          te.Metadata,
+				parentIdentifier:  identifier,
+				parentText:        parents[i],
+				sectionIdentifier: sectionIdentifier(identifier, sectionIdxs[i], len(sections)),
+				document:          document,
+				chunk:             i,
+				totalChunks:       len(chunks),
+				tokenCount:        tokenCnt,
 			})
 		}
 	}
 
-	// no inputs were missing embeddings, so no need to make a request
-	if len(inputs) == 0 || skipEmbeddings {
-		return results, nil
-	}
+	return results, nil
+}
 
-	resp, err := t.ai.CreateEmbeddings(ctx, gogpt.EmbeddingRequest{
-		Input: inputs,
-		Model: gogpt.SmallEmbedding3,
-		User:  userID,
-	})
-	if err != nil {
-		return nil, errors.Wrap(err, "error creating embeddings")
-	}
+// embedTexts fills in the embeddings of texts that don't have one, from the cache if possible. Texts that are the same are only
+// embedded once, and the rest are embedded in as few requests as possible.
+func (t *Manager) embedTexts(ctx context.Context, embedder Embedder, texts []*TextEmbedding, userID string) error {
+	var inputs []string
+	var inputTokens []int
+	waiting := map[string][]*TextEmbedding{} // texts waiting for the embedding of each input
+	for _, te := range texts {
+		if len(te.Embedding) > 0 {
+			continue
+		}
 
-	for i, embedding := range resp.Data {
-		if len(results[i].Embedding) > 0 {
+		input := textEmbeddingPrep(te.Text)
+		if _, ok := waiting[input]; ok {
+			waiting[input] = append(waiting[input], te)
+			continue
+		}
+		if embedding, ok := t.cache.Get(EmbeddingCacheKey(embedder.Model(), input)); ok {
+			te.Embedding = embedding
 			continue
 		}
-		results[i].Embedding = embedding.Embedding
+		inputs = append(inputs, input)
+		inputTokens = append(inputTokens, tokens.MustCount(input))
+		waiting[input] = []*TextEmbedding{te}
 	}
 
-	return results, nil
+	for _, batch := range embeddingBatches(inputs, inputTokens, maxEmbeddingBatchSize, maxEmbeddingBatchTokens) {
+		embeddings, err := embedder.Embed(ctx, batch, userID)
+		if err != nil {
+			return errors.Wrap(err, "error creating embeddings")
+		}
+		if len(embeddings) != len(batch) {
+			return errors.Errorf("embedder %s returned %d embeddings for %d texts", embedder.Model(), len(embeddings), len(batch))
+		}
+
+		for i, embedding := range embeddings {
+			for _, te := range waiting[batch[i]] {
+				te.Embedding = embedding
+			}
+			t.cache.Set(EmbeddingCacheKey(embedder.Model(), batch[i]), embedding)
+		}
+	}
+
+	return nil
+}
+
+// embeddingBatches splits inputs into batches of at most maxSize inputs and maxTokens tokens, where tokenCounts are the
+// tokens of each input. An input with more than maxTokens tokens is a batch of its own.
+func embeddingBatches(inputs []string, tokenCounts []int, maxSize, maxTokens int) [][]string {
+	var batches [][]string
+	start, batchTokens := 0, 0
+	for i := range inputs {
+		if i > start && (i-start == maxSize || batchTokens+tokenCounts[i] > maxTokens) {
+			batches = append(batches, inputs[start:i])
+			start, batchTokens = i, 0
+		}
+		batchTokens += tokenCounts[i]
+	}
+	if start < len(inputs) {
+		batches = append(batches, inputs[start:])
+	}
+	return batches
 }
 
 func (t *Manager) cosineSimilarity(a, b []float32) (float64, error) {
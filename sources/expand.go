@@ -0,0 +1,295 @@
+package sources
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/troylelandshields/hardconversations/internal/tokens"
+)
+
+// ChunkExpansion controls what is done with chunks of longer texts once they are selected, so the model doesn't get chunks out of
+// order or without the context around them.
+type ChunkExpansion struct {
+	// Stitch merges selected chunks of the same text that are next to each other into one, in the order they are in the text.
+	Stitch bool
+	// Neighbors adds up to this many chunks before and after each selected chunk, closest first, while the token budget allows.
+	// They are placed around the chunk in the order they are in the text.
+	Neighbors int
+	// Parent injects the section a selected chunk was split from instead of the chunk, if it fits in the token budget. This
+	// lets sources be retrieved with small, precise chunks and still give the model the context around them.
+	Parent bool
+	// ParentChunker splits texts into the sections that are injected when Parent is true; the sections are then split into
+	// chunks with the source's Chunker. If it is nil, the whole text is the section.
+	ParentChunker Chunker
+}
+
+// WithDefaultChunkExpansion sets how chunks are expanded for sources that don't set their own with WithChunkExpansion. By default
+// chunks are used as they are.
+func WithDefaultChunkExpansion(expansion ChunkExpansion) ManagerOption {
+	return func(m *Manager) {
+		m.expansion = &expansion
+	}
+}
+
+// WithChunkExpansion sets how this source's chunks are expanded once they are selected, instead of the Manager's default.
+func WithChunkExpansion(expansion ChunkExpansion) SourceOption[TextEmbeddingProvider] {
+	return func(s *source[TextEmbeddingProvider]) {
+		s.expansion = &expansion
+	}
+}
+
+func (t *Manager) sourceExpansion(s source[TextEmbeddingProvider]) ChunkExpansion {
+	if s.expansion != nil {
+		return *s.expansion
+	}
+	if t.expansion != nil {
+		return *t.expansion
+	}
+	return ChunkExpansion{}
+}
+
+type documentKey struct {
+	source   int
+	document int
+}
+
+func (ci contextualInfo) documentKey() documentKey {
+	return documentKey{source: ci.sourceIdx, document: ci.Source.document}
+}
+
+func (ci contextualInfo) fits(tokenCount int, allowedTokens int) bool {
+	return tokenCount <= *ci.tokensLeft && tokenCount <= allowedTokens
+}
+
+// expandChunks applies each source's ChunkExpansion to the selected texts, using allTexts (every chunk of every source, by source)
+// to find neighbors. allowedTokens is what is left of the token budget after the selected texts.
+func (t *Manager) expandChunks(selected []contextualInfo, allTexts [][]TextEmbedding, allowedTokens int) []contextualInfo {
+	// replace chunks with their parent section
+	var result []contextualInfo
+	parents := map[documentKey]map[string]int{} // index of the injected section in result
+	for _, ci := range selected {
+		expansion := t.sourceExpansion(t.textProviders[ci.sourceIdx])
+		if !expansion.Parent || ci.Source.parentText == "" || ci.Source.parentText == ci.Source.Text {
+			result = append(result, ci)
+			continue
+		}
+
+		key := ci.documentKey()
+		if parents[key] == nil {
+			parents[key] = map[string]int{}
+		}
+		if i, ok := parents[key][ci.Source.parentText]; ok {
+			// the section was already injected for another chunk
+			result[i].Source.candidates = append(result[i].Source.candidates, ci.Source.candidates...)
+			*ci.tokensLeft += ci.Source.tokenCount
+			allowedTokens += ci.Source.tokenCount
+			continue
+		}
+
+		parentTokens, err := tokens.CountWith(t.tokenizer, ci.Source.parentText)
+		if err != nil || !ci.fits(parentTokens-ci.Source.tokenCount, allowedTokens) {
+			result = append(result, ci)
+			continue
+		}
+		parents[key][ci.Source.parentText] = len(result)
+		*ci.tokensLeft -= parentTokens - ci.Source.tokenCount
+		allowedTokens -= parentTokens - ci.Source.tokenCount
+
+		ci.Source.Text = ci.Source.parentText
+		ci.Source.tokenCount = parentTokens
+		ci.Source.Identifier = ci.Source.sectionIdentifier
+		ci.expanded = true
+		result = append(result, ci)
+	}
+	selected = result
+
+	// add neighboring chunks around the chunk they were added for, in the order they are in the text
+	used := map[documentKey]map[int]bool{}
+	for _, ci := range selected {
+		if used[ci.documentKey()] == nil {
+			used[ci.documentKey()] = map[int]bool{}
+		}
+		used[ci.documentKey()][ci.Source.chunk] = true
+	}
+	result = nil
+	for _, ci := range selected {
+		expansion := t.sourceExpansion(t.textProviders[ci.sourceIdx])
+		if expansion.Neighbors <= 0 || ci.expanded || ci.Source.totalChunks <= 1 {
+			result = append(result, ci)
+			continue
+		}
+
+		var before, after []contextualInfo
+		for d := 1; d <= expansion.Neighbors; d++ {
+			for _, chunk := range []int{ci.Source.chunk - d, ci.Source.chunk + d} {
+				if used[ci.documentKey()][chunk] {
+					continue
+				}
+				neighbor, ok := findChunk(allTexts[ci.sourceIdx], ci.Source.document, chunk)
+				if !ok || !ci.fits(neighbor.tokenCount, allowedTokens) {
+					continue
+				}
+				if neighbor.Weight == 0 {
+					neighbor.Weight = t.textProviders[ci.sourceIdx].weight
+				}
+				used[ci.documentKey()][chunk] = true
+				*ci.tokensLeft -= neighbor.tokenCount
+				allowedTokens -= neighbor.tokenCount
+
+				n := contextualInfo{
+					Source:     neighbor,
+					tokensLeft: ci.tokensLeft,
+					sourceIdx:  ci.sourceIdx,
+				}
+				if chunk < ci.Source.chunk {
+					before = append([]contextualInfo{n}, before...)
+				} else {
+					after = append(after, n)
+				}
+			}
+		}
+		result = append(result, before...)
+		result = append(result, ci)
+		result = append(result, after...)
+	}
+	selected = result
+
+	return t.stitchChunks(selected, allowedTokens)
+}
+
+func findChunk(texts []TextEmbedding, document int, chunk int) (TextEmbedding, bool) {
+	for _, te := range texts {
+		if te.document == document && te.chunk == chunk && te.totalChunks > 1 {
+			return te, true
+		}
+	}
+	return TextEmbedding{}, false
+}
+
+// stitchChunks merges runs of adjacent chunks of the same text for sources that Stitch. Each merged text takes the place of its
+// highest ranked chunk. A run is left as it is if its merged text needs more tokens than the chunks and allowedTokens has no
+// room for the difference.
+func (t *Manager) stitchChunks(selected []contextualInfo, allowedTokens int) []contextualInfo {
+	groups := map[documentKey][]int{}
+	for i, ci := range selected {
+		if !t.sourceExpansion(t.textProviders[ci.sourceIdx]).Stitch || ci.expanded || ci.Source.totalChunks <= 1 {
+			continue
+		}
+		groups[ci.documentKey()] = append(groups[ci.documentKey()], i)
+	}
+
+	replacements := map[int]contextualInfo{}
+	removed := map[int]bool{}
+	for _, idxs := range groups {
+		sorted := append([]int(nil), idxs...)
+		sort.Slice(sorted, func(i, j int) bool {
+			return selected[sorted[i]].Source.chunk < selected[sorted[j]].Source.chunk
+		})
+
+		for start := 0; start < len(sorted); {
+			end := start + 1
+			for end < len(sorted) && selected[sorted[end]].Source.chunk == selected[sorted[end-1]].Source.chunk+1 {
+				end++
+			}
+			run := sorted[start:end]
+			start = end
+			if len(run) == 1 {
+				continue
+			}
+
+			// the merged text goes where the highest ranked chunk of the run was
+			first := run[0]
+			for _, i := range run {
+				if i < first {
+					first = i
+				}
+			}
+
+			merged := selected[first]
+			var chunks []TextEmbedding
+			var runTokens int
+			for _, i := range run {
+				chunks = append(chunks, selected[i].Source)
+				runTokens += selected[i].Source.tokenCount
+			}
+			merged.Source = mergeChunks(t.tokenizer, chunks)
+
+			extra := merged.Source.tokenCount - runTokens
+			if extra > 0 && !merged.fits(extra, allowedTokens) {
+				continue
+			}
+			*merged.tokensLeft -= extra
+			allowedTokens -= extra
+
+			for _, i := range run {
+				if i != first {
+					removed[i] = true
+				}
+			}
+			replacements[first] = merged
+		}
+	}
+
+	var result []contextualInfo
+	for i, ci := range selected {
+		if removed[i] {
+			continue
+		}
+		if r, ok := replacements[i]; ok {
+			ci = r
+		}
+		result = append(result, ci)
+	}
+	return result
+}
+
+// mergeChunks joins adjacent chunks, which must be in order, into one text and counts its tokens.
+func mergeChunks(tk tokens.Tokenizer, chunks []TextEmbedding) TextEmbedding {
+	first, last := chunks[0], chunks[len(chunks)-1]
+	merged := first
+	merged.Identifier = chunkIdentifier(first.parentIdentifier, first.chunk, first.totalChunks) + "-" + strconv.Itoa(last.chunk)
+	merged.Embedding = nil
+	merged.Text = joinChunks(chunks)
+
+	merged.tokenCount = 0
+	merged.candidates = nil
+	for _, c := range chunks {
+		merged.tokenCount += c.tokenCount
+		merged.candidates = append(merged.candidates, c.candidates...)
+	}
+	if cnt, err := tokens.CountWith(tk, merged.Text); err == nil {
+		merged.tokenCount = cnt
+	}
+	return merged
+}
+
+// joinChunks returns the text of adjacent chunks, which must be in order. If the chunks can be found in the text they were split
+// from, it is copied from there so that overlaps aren't repeated and separators are kept.
+func joinChunks(chunks []TextEmbedding) string {
+	first := chunks[0]
+	parent := first.parentText
+	if start := strings.Index(parent, first.Text); start != -1 {
+		end := start
+		found := true
+		for _, c := range chunks {
+			idx := strings.Index(parent[start:], c.Text)
+			if idx == -1 {
+				found = false
+				break
+			}
+			if e := start + idx + len(c.Text); e > end {
+				end = e
+			}
+		}
+		if found {
+			return parent[start:end]
+		}
+	}
+
+	texts := make([]string, len(chunks))
+	for i, c := range chunks {
+		texts[i] = c.Text
+	}
+	return strings.Join(texts, "\n")
+}
@@ -0,0 +1,114 @@
+package sources
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestManagerChunkExpansion(t *testing.T) {
+	paragraphs := []string{
+		"Alice has worked as a line cook for ten years.",
+		"She led the kitchen at a busy downtown restaurant.",
+		"Before that she studied accounting in college.",
+		"She also built a budgeting app in Go for the restaurant.",
+	}
+	document := strings.Join(paragraphs, "\n\n")
+	prompt := "kitchen restaurant Go budgeting app"
+
+	tests := []struct {
+		name        string
+		expansion   ChunkExpansion
+		want        []string
+		identifiers []string
+	}{
+		{
+			name: "no expansion",
+			want: []string{paragraphs[3], paragraphs[1]},
+		},
+		{
+			name:        "neighbors",
+			expansion:   ChunkExpansion{Neighbors: 1},
+			want:        []string{paragraphs[2], paragraphs[3], paragraphs[0], paragraphs[1]},
+			identifiers: []string{"alice#2", "alice#3", "alice#0", "alice#1"},
+		},
+		{
+			name:        "stitch with neighbors",
+			expansion:   ChunkExpansion{Stitch: true, Neighbors: 1},
+			want:        []string{document},
+			identifiers: []string{"alice#0-3"},
+		},
+		{
+			name:      "parent",
+			expansion: ChunkExpansion{Parent: true},
+			want:      []string{document},
+		},
+		{
+			name:        "parent sections",
+			expansion:   ChunkExpansion{Parent: true, ParentChunker: ParagraphChunker{Size: 25}},
+			want:        []string{paragraphs[2] + "\n\n" + paragraphs[3], paragraphs[0] + "\n\n" + paragraphs[1]},
+			identifiers: []string{"alice#section-1", "alice#section-0"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New(nil, WithDefaultEmbedder(HashingEmbedder{}), WithDefaultChunker(ParagraphChunker{Size: 14}), WithDefaultChunkExpansion(tt.expansion))
+			m.AddSourceTextEmbeddingProvider(staticTextEmbeddingProvider{{Identifier: "alice", Text: document}})
+
+			got, err := m.GetSourceText(context.Background(), true, 0.15, 1000, prompt, "")
+			if err != nil {
+				t.Fatalf("GetSourceText() error = %v", err)
+			}
+			var texts, identifiers []string
+			for _, te := range got {
+				texts = append(texts, te.Text)
+				identifiers = append(identifiers, te.Identifier)
+			}
+			if strings.Join(texts, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("GetSourceText() = %q, want %q", texts, tt.want)
+			}
+			if tt.identifiers != nil && strings.Join(identifiers, "|") != strings.Join(tt.identifiers, "|") {
+				t.Errorf("GetSourceText() identifiers = %q, want %q", identifiers, tt.identifiers)
+			}
+		})
+	}
+}
+
+func TestStitchChunksBudget(t *testing.T) {
+	m := New(nil, WithDefaultChunkExpansion(ChunkExpansion{Stitch: true}))
+	m.AddSourceTextEmbeddingProvider(staticTextEmbeddingProvider{})
+
+	parent := "The first chunk.\n\nThe second chunk."
+	selected := func(tokensLeft *int) []contextualInfo {
+		var selected []contextualInfo
+		for i, text := range strings.Split(parent, "\n\n") {
+			selected = append(selected, contextualInfo{
+				// the chunks' token counts are too low, so the merged text needs more tokens than were charged for them
+				Source:     TextEmbedding{Text: text, parentIdentifier: "doc", parentText: parent, chunk: i, totalChunks: 2, tokenCount: 1},
+				tokensLeft: tokensLeft,
+			})
+		}
+		return selected
+	}
+
+	tokensLeft := 0
+	if got := m.stitchChunks(selected(&tokensLeft), 100); len(got) != 2 || tokensLeft != 0 {
+		t.Errorf("stitchChunks() without room returned %d texts and left %d tokens, want the 2 chunks and 0", len(got), tokensLeft)
+	}
+
+	tokensLeft = 100
+	got := m.stitchChunks(selected(&tokensLeft), 100)
+	if len(got) != 1 || got[0].Source.Text != parent {
+		t.Fatalf("stitchChunks() = %+v, want the merged text", got)
+	}
+	if charged := 100 - tokensLeft; charged != got[0].Source.tokenCount-2 {
+		t.Errorf("stitchChunks() charged %d tokens, want %d", charged, got[0].Source.tokenCount-2)
+	}
+}
+
+type staticTextEmbeddingProvider []TextEmbedding
+
+func (p staticTextEmbeddingProvider) Sources(ctx context.Context, prompt string) ([]TextEmbedding, error) {
+	return p, nil
+}
@@ -0,0 +1,100 @@
+package sources
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const defaultParallelism = 8
+
+// fetchResult is what a source's provider returned.
+type fetchResult struct {
+	texts []TextEmbedding
+	err   error
+}
+
+// fetchSources calls every provider's Sources concurrently, at most t.parallelism at a time, and returns the results in the same
+// order as t.textProviders so that everything after fetching stays deterministic. Texts that don't match q's Filters are left out.
+func (t *Manager) fetchSources(ctx context.Context, q SourceQuery) []fetchResult {
+	results := make([]fetchResult, len(t.textProviders))
+
+	parallelism := t.parallelism
+	if parallelism <= 0 {
+		parallelism = len(t.textProviders)
+	}
+	sem := make(chan struct{}, parallelism)
+
+	var wg sync.WaitGroup
+	for i, s := range t.textProviders {
+		wg.Add(1)
+		go func(i int, s source[TextEmbeddingProvider]) {
+			defer wg.Done()
+
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				results[i].err = ctx.Err()
+				return
+			}
+			defer func() { <-sem }()
+
+			results[i].texts, results[i].err = t.fetchSource(ctx, s, q)
+			results[i].texts = filterTexts(results[i].texts, q)
+		}(i, s)
+	}
+	wg.Wait()
+
+	return results
+}
+
+func (t *Manager) fetchSource(ctx context.Context, source source[TextEmbeddingProvider], q SourceQuery) ([]TextEmbedding, error) {
+	timeout := source.timeout
+	if timeout == 0 {
+		timeout = t.timeout
+	}
+	if timeout <= 0 {
+		return querySources(ctx, source.provider, q)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// a provider that ignores ctx shouldn't hold up the other sources past its timeout
+	done := make(chan fetchResult, 1)
+	go func() {
+		texts, err := querySources(ctx, source.provider, q)
+		done <- fetchResult{texts: texts, err: err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.texts, r.err
+	case <-ctx.Done():
+		return nil, errors.Wrapf(ctx.Err(), "source %T timed out after %s", source.provider, timeout)
+	}
+}
+
+// WithParallelism limits how many sources are fetched at the same time; the default is 8, and 0 or less means no limit.
+func WithParallelism(n int) ManagerOption {
+	return func(m *Manager) {
+		m.parallelism = n
+	}
+}
+
+// WithDefaultTimeout sets how long sources that don't set their own with WithTimeout can take to return their texts. By default there is no timeout.
+func WithDefaultTimeout(d time.Duration) ManagerOption {
+	return func(m *Manager) {
+		m.timeout = d
+	}
+}
+
+// WithTimeout sets how long this source can take to return its texts. A source that times out is handled like any other
+// error, so it is skipped if it was added WithAllowErrors.
+func WithTimeout(d time.Duration) SourceOption[TextEmbeddingProvider] {
+	return func(s *source[TextEmbeddingProvider]) {
+		s.timeout = d
+	}
+}
@@ -0,0 +1,83 @@
+package sources
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+type slowTextProvider struct {
+	delay time.Duration
+	texts []string
+}
+
+func (p slowTextProvider) Sources(ctx context.Context, prompt string) ([]string, error) {
+	select {
+	case <-time.After(p.delay):
+		return p.texts, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// countingEmbedder records how many times Embed is called.
+type countingEmbedder struct {
+	HashingEmbedder
+	mu    sync.Mutex
+	calls int
+}
+
+func (e *countingEmbedder) Embed(ctx context.Context, texts []string, userID string) ([][]float32, error) {
+	e.mu.Lock()
+	e.calls++
+	e.mu.Unlock()
+	return e.HashingEmbedder.Embed(ctx, texts, userID)
+}
+
+func TestManagerFetchesSourcesConcurrently(t *testing.T) {
+	embedder := &countingEmbedder{}
+	m := New(nil, WithDefaultEmbedder(embedder), WithEmbeddingCache(nil))
+	for i, text := range []string{"Go developer", "Go engineer", "Go programmer", "Go contractor", "Go consultant"} {
+		m.AddSourceTextProvider(slowTextProvider{delay: 50 * time.Millisecond, texts: []string{text}}, WithWeight(1-float64(i)/10))
+	}
+	m.AddSourceTextProvider(slowTextProvider{delay: time.Second, texts: []string{"Go intern"}}, WithTimeout(20*time.Millisecond), WithAllowErrors())
+
+	start := time.Now()
+	got, err := m.GetSourceText(context.Background(), true, 0, 1000, "Go", "")
+	if err != nil {
+		t.Fatalf("GetSourceText() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("GetSourceText() took %s, want sources fetched concurrently", elapsed)
+	}
+	if embedder.calls != 1 {
+		t.Errorf("Embed() called %d times, want 1 batch for all sources", embedder.calls)
+	}
+
+	want := []string{"Go developer", "Go engineer", "Go programmer", "Go contractor", "Go consultant"}
+	if len(got) != len(want) {
+		t.Fatalf("GetSourceText() returned %d texts, want %d", len(got), len(want))
+	}
+
+	// the simple mode keeps the order of the sources' weights
+	got, err = m.GetSourceText(context.Background(), false, 0, 1000, "Go", "")
+	if err != nil {
+		t.Fatalf("GetSourceText() error = %v", err)
+	}
+	for i := range want {
+		if got[i].Text != want[i] {
+			t.Errorf("GetSourceText()[%d] = %q, want %q", i, got[i].Text, want[i])
+		}
+	}
+}
+
+func TestManagerSourceTimeout(t *testing.T) {
+	m := New(nil, WithDefaultEmbedder(HashingEmbedder{}), WithDefaultTimeout(20*time.Millisecond))
+	m.AddSourceTextProvider(slowTextProvider{delay: time.Second, texts: []string{"too slow"}})
+
+	_, err := m.GetSourceText(context.Background(), false, 0, 1000, "Go", "")
+	if err == nil {
+		t.Fatal("GetSourceText() error = nil, want a timeout")
+	}
+}
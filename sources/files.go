@@ -0,0 +1,386 @@
+package sources
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/troylelandshields/hardconversations/logger"
+)
+
+const defaultJSONLTextField = "text"
+
+// FileProvider is a TextEmbeddingProvider that returns the contents of files matching glob patterns. The format of each file is
+// chosen by its extension:
+//
+//   - .md and .markdown files are returned as they are, so they work well with MarkdownChunker
+//   - .html and .htm files have their tags removed; headings are kept as Markdown headings
+//   - .csv files are returned as one text per row (or group of rows), with each cell labeled with its column header
+//   - .jsonl and .ndjson files are returned as one text per line, using one field as the text and the rest as Metadata
+//   - anything else is returned as plain text
+//
+// Identifiers are the file's path, with "@" and the byte offset of the row or line added for CSV and JSONL files. Files are
+// read the first time Sources is called, and again when they change if the provider watches for changes.
+type FileProvider struct {
+	fsys           fs.FS
+	globs          []string
+	csvRowsPerText int
+	jsonlTextField string
+	watchInterval  time.Duration
+
+	mu          sync.Mutex
+	texts       []TextEmbedding
+	loaded      bool
+	lastChecked time.Time
+	versions    map[string]fileVersion
+}
+
+type fileVersion struct {
+	size    int64
+	modTime time.Time
+}
+
+type FileOption func(*FileProvider)
+
+// WithCSVRowsPerText sets how many CSV rows are returned together as one text. The default is 1.
+func WithCSVRowsPerText(n int) FileOption {
+	return func(p *FileProvider) {
+		p.csvRowsPerText = n
+	}
+}
+
+// WithJSONLTextField sets the field of each JSONL line that is used as the text; the other fields are its Metadata. The default is "text".
+func WithJSONLTextField(field string) FileOption {
+	return func(p *FileProvider) {
+		p.jsonlTextField = field
+	}
+}
+
+// WithWatch makes the provider check whether files were added, removed or changed at most once every interval, when Sources
+// is called, and read them again if they were.
+func WithWatch(interval time.Duration) FileOption {
+	return func(p *FileProvider) {
+		p.watchInterval = interval
+	}
+}
+
+// FromFiles returns a provider for the files in fsys that match any of the globs (see path.Match), e.g.
+// sources.FromFiles(os.DirFS("docs"), "*.md", "faq/*.html").
+func FromFiles(fsys fs.FS, globs ...string) *FileProvider {
+	return NewFileProvider(fsys, globs)
+}
+
+// NewFileProvider is like FromFiles, with options.
+func NewFileProvider(fsys fs.FS, globs []string, opt ...FileOption) *FileProvider {
+	p := &FileProvider{
+		fsys:           fsys,
+		globs:          globs,
+		csvRowsPerText: 1,
+		jsonlTextField: defaultJSONLTextField,
+	}
+
+	for _, o := range opt {
+		o(p)
+	}
+
+	return p
+}
+
+// Sources returns the texts of all of the files, reading them if they haven't been read yet or have changed.
+func (p *FileProvider) Sources(ctx context.Context, prompt string) ([]TextEmbedding, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.loaded {
+		err := p.load()
+		if err != nil {
+			return nil, err
+		}
+	} else if p.watchInterval > 0 && time.Since(p.lastChecked) >= p.watchInterval {
+		changed, err := p.changed()
+		if err != nil {
+			return nil, err
+		}
+		if changed {
+			logger.Debugf("Files changed, reloading")
+			err = p.load()
+			if err != nil {
+				return nil, err
+			}
+		}
+		p.lastChecked = time.Now()
+	}
+
+	return p.texts, nil
+}
+
+// Reload reads all of the files again.
+func (p *FileProvider) Reload() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.load()
+}
+
+func (p *FileProvider) paths() ([]string, error) {
+	seen := map[string]bool{}
+	var paths []string
+	for _, glob := range p.globs {
+		matches, err := fs.Glob(p.fsys, glob)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid glob %q", glob)
+		}
+		for _, m := range matches {
+			if !seen[m] {
+				seen[m] = true
+				paths = append(paths, m)
+			}
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func (p *FileProvider) stat() (map[string]fileVersion, error) {
+	paths, err := p.paths()
+	if err != nil {
+		return nil, err
+	}
+
+	versions := map[string]fileVersion{}
+	for _, name := range paths {
+		info, err := fs.Stat(p.fsys, name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading %s", name)
+		}
+		if info.IsDir() {
+			continue
+		}
+		versions[name] = fileVersion{size: info.Size(), modTime: info.ModTime()}
+	}
+	return versions, nil
+}
+
+func (p *FileProvider) changed() (bool, error) {
+	versions, err := p.stat()
+	if err != nil {
+		return false, err
+	}
+	if len(versions) != len(p.versions) {
+		return true, nil
+	}
+	for name, v := range versions {
+		if old, ok := p.versions[name]; !ok || old != v {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (p *FileProvider) load() error {
+	versions, err := p.stat()
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(versions))
+	for name := range versions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var texts []TextEmbedding
+	for _, name := range names {
+		b, err := fs.ReadFile(p.fsys, name)
+		if err != nil {
+			return errors.Wrapf(err, "error reading %s", name)
+		}
+
+		fileTexts, err := p.parse(name, b)
+		if err != nil {
+			return errors.Wrapf(err, "error parsing %s", name)
+		}
+		texts = append(texts, fileTexts...)
+	}
+
+	p.texts = texts
+	p.versions = versions
+	p.loaded = true
+	p.lastChecked = time.Now()
+	return nil
+}
+
+func (p *FileProvider) parse(name string, b []byte) ([]TextEmbedding, error) {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".html", ".htm":
+		return []TextEmbedding{{Identifier: name, Text: StripHTML(string(b))}}, nil
+	case ".csv":
+		return parseCSV(name, b, p.csvRowsPerText)
+	case ".jsonl", ".ndjson":
+		return parseJSONL(name, b, p.jsonlTextField)
+	default:
+		return []TextEmbedding{{Identifier: name, Text: string(b)}}, nil
+	}
+}
+
+// parseCSV returns a text for every rowsPerText rows, with each cell on its own line labeled with its column header. When each
+// text is one row, its Metadata is a map of the headers to the cells.
+func parseCSV(name string, b []byte, rowsPerText int) ([]TextEmbedding, error) {
+	if rowsPerText <= 0 {
+		rowsPerText = 1
+	}
+
+	r := csv.NewReader(bytes.NewReader(b))
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var texts []TextEmbedding
+	var rows []string
+	var groupOffset int64
+	var metadata map[string]string
+	for {
+		offset := r.InputOffset()
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var sb strings.Builder
+		rowMetadata := map[string]string{}
+		for i, cell := range record {
+			label := fmt.Sprintf("column %d", i+1)
+			if i < len(header) && header[i] != "" {
+				label = header[i]
+			}
+			if sb.Len() > 0 {
+				sb.WriteString("\n")
+			}
+			sb.WriteString(label + ": " + cell)
+			rowMetadata[label] = cell
+		}
+
+		if len(rows) == 0 {
+			groupOffset = offset
+			metadata = rowMetadata
+		}
+		rows = append(rows, sb.String())
+
+		if len(rows) == rowsPerText {
+			texts = append(texts, csvText(name, groupOffset, rows, metadata))
+			rows = nil
+		}
+	}
+	if len(rows) > 0 {
+		texts = append(texts, csvText(name, groupOffset, rows, metadata))
+	}
+
+	return texts, nil
+}
+
+func csvText(name string, offset int64, rows []string, metadata map[string]string) TextEmbedding {
+	te := TextEmbedding{
+		Identifier: fmt.Sprintf("%s@%d", name, offset),
+		Text:       strings.Join(rows, "\n\n"),
+	}
+	if len(rows) == 1 {
+		te.Metadata = metadata
+	}
+	return te
+}
+
+// parseJSONL returns a text for every line that has textField; the line's other fields are its Metadata.
+func parseJSONL(name string, b []byte, textField string) ([]TextEmbedding, error) {
+	var texts []TextEmbedding
+
+	// lines are read with their line endings, so the offsets are right for CRLF files too; the trailing \r is JSON whitespace
+	r := bufio.NewReader(bytes.NewReader(b))
+	var offset int64
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) == 0 && err != nil {
+			break
+		}
+		lineOffset := offset
+		offset += int64(len(line))
+
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var fields map[string]interface{}
+		if err := json.Unmarshal(line, &fields); err != nil {
+			return nil, errors.Wrapf(err, "invalid JSON at offset %d", lineOffset)
+		}
+
+		text, ok := fields[textField].(string)
+		if !ok {
+			logger.Debugf("Line at offset %d of %s has no %q string field, skipping", lineOffset, name, textField)
+			continue
+		}
+		delete(fields, textField)
+
+		te := TextEmbedding{
+			Identifier: fmt.Sprintf("%s@%d", name, lineOffset),
+			Text:       text,
+		}
+		if len(fields) > 0 {
+			te.Metadata = fields
+		}
+		texts = append(texts, te)
+	}
+
+	return texts, nil
+}
+
+var (
+	htmlIgnored   = regexp.MustCompile(`(?is)<(script|style|head|noscript|template)\b.*?</(script|style|head|noscript|template)\s*>|<!--.*?-->`)
+	htmlHeading   = regexp.MustCompile(`(?is)<h([1-6])\b[^>]*>(.*?)</h[1-6]\s*>`)
+	htmlBlock     = regexp.MustCompile(`(?i)</?(p|div|br|li|ul|ol|tr|table|section|article|header|footer|blockquote|pre|hr)\b[^>]*>`)
+	htmlTag       = regexp.MustCompile(`(?s)<[^>]*>`)
+	htmlSpaces    = regexp.MustCompile(`[ \t\r\f\v]+`)
+	htmlBlankRuns = regexp.MustCompile(`\n\s*\n\s*`)
+)
+
+// StripHTML returns the text of an HTML document without its tags, scripts and styles. Headings are kept as Markdown headings
+// and block elements start new lines, so the structure can still be used for chunking.
+func StripHTML(doc string) string {
+	doc = htmlIgnored.ReplaceAllString(doc, "")
+	doc = htmlHeading.ReplaceAllStringFunc(doc, func(h string) string {
+		m := htmlHeading.FindStringSubmatch(h)
+		level := int(m[1][0] - '0')
+		text := strings.TrimSpace(htmlSpaces.ReplaceAllString(htmlTag.ReplaceAllString(m[2], ""), " "))
+		return "\n\n" + strings.Repeat("#", level) + " " + text + "\n\n"
+	})
+	doc = htmlBlock.ReplaceAllString(doc, "\n")
+	doc = htmlTag.ReplaceAllString(doc, "")
+	doc = html.UnescapeString(doc)
+
+	lines := strings.Split(doc, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(htmlSpaces.ReplaceAllString(line, " "))
+	}
+	doc = strings.Join(lines, "\n")
+	doc = htmlBlankRuns.ReplaceAllString(doc, "\n\n")
+	return strings.TrimSpace(doc)
+}
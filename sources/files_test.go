@@ -0,0 +1,80 @@
+package sources
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestFileProvider(t *testing.T) {
+	fsys := fstest.MapFS{
+		"docs/readme.md":   {Data: []byte("# Title\n\nSome text.")},
+		"docs/page.html":   {Data: []byte(`<html><head><title>x</title><style>p{}</style></head><body><h1>Hello <b>there</b></h1><p>First &amp; second.</p><script>alert(1)</script><p>Third</p></body></html>`)},
+		"docs/people.csv":  {Data: []byte("name,skill\nAlice,Go\nBob,\"Adobe Creative Suite\"\n")},
+		"docs/items.jsonl": {Data: []byte(`{"text":"first item","id":1}` + "\n\n" + `{"text":"second item","tags":["a"]}` + "\n")},
+		"other/skip.txt":   {Data: []byte("not matched")},
+	}
+
+	p := NewFileProvider(fsys, []string{"docs/*"}, WithWatch(time.Nanosecond))
+	got, err := p.Sources(context.Background(), "")
+	if err != nil {
+		t.Fatalf("Sources() error = %v", err)
+	}
+
+	want := []TextEmbedding{
+		{Identifier: "docs/items.jsonl@0", Text: "first item", Metadata: map[string]interface{}{"id": float64(1)}},
+		{Identifier: "docs/items.jsonl@30", Text: "second item", Metadata: map[string]interface{}{"tags": []interface{}{"a"}}},
+		{Identifier: "docs/page.html", Text: "# Hello there\n\nFirst & second.\n\nThird"},
+		{Identifier: "docs/people.csv@11", Text: "name: Alice\nskill: Go", Metadata: map[string]string{"name": "Alice", "skill": "Go"}},
+		{Identifier: "docs/people.csv@20", Text: "name: Bob\nskill: Adobe Creative Suite", Metadata: map[string]string{"name": "Bob", "skill": "Adobe Creative Suite"}},
+		{Identifier: "docs/readme.md", Text: "# Title\n\nSome text."},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Sources() =\n%#v\nwant\n%#v", got, want)
+	}
+
+	// changed files are read again
+	fsys["docs/readme.md"] = &fstest.MapFile{Data: []byte("Updated."), ModTime: time.Now()}
+	got, err = p.Sources(context.Background(), "")
+	if err != nil {
+		t.Fatalf("Sources() error = %v", err)
+	}
+	if got[len(got)-1].Text != "Updated." {
+		t.Errorf("Sources() did not reload changed file, got %q", got[len(got)-1].Text)
+	}
+}
+
+func TestFileProviderCSVGroups(t *testing.T) {
+	fsys := fstest.MapFS{
+		"people.csv": {Data: []byte("name,skill\nAlice,Go\nBob,Rust\nCarol,Python\n")},
+	}
+
+	got, err := NewFileProvider(fsys, []string{"*.csv"}, WithCSVRowsPerText(2)).Sources(context.Background(), "")
+	if err != nil {
+		t.Fatalf("Sources() error = %v", err)
+	}
+	if len(got) != 2 || got[0].Text != "name: Alice\nskill: Go\n\nname: Bob\nskill: Rust" || got[1].Identifier != "people.csv@29" {
+		t.Errorf("Sources() = %+v", got)
+	}
+}
+
+func TestFileProviderJSONLOffsets(t *testing.T) {
+	fsys := fstest.MapFS{
+		"items.jsonl": {Data: []byte(`{"text":"first"}` + "\r\n\r\n" + `{"text":"second"}` + "\r\n" + `{"text":"third"}`)},
+	}
+
+	got, err := NewFileProvider(fsys, []string{"*.jsonl"}).Sources(context.Background(), "")
+	if err != nil {
+		t.Fatalf("Sources() error = %v", err)
+	}
+	want := []string{"items.jsonl@0", "items.jsonl@20", "items.jsonl@39"}
+	var ids []string
+	for _, te := range got {
+		ids = append(ids, te.Identifier)
+	}
+	if !reflect.DeepEqual(ids, want) {
+		t.Errorf("Sources() identifiers = %v, want %v", ids, want)
+	}
+}
@@ -0,0 +1,134 @@
+package sources
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// lastSourceID is shared by all Managers so that a handle never refers to a different source in another Manager.
+var lastSourceID uint64
+
+// SourceHandle identifies a text source added to a Manager so that it can be removed, replaced, enabled or disabled later. A
+// handle also refers to the copy of the source inherited by every Manager created from that Manager with NewFromParent, so a
+// forked thread can change one inherited source without affecting its parent or the rest of the sources.
+type SourceHandle struct {
+	id uint64
+}
+
+// Source returns the handle of the text source added WithName(name), whether it is enabled or not.
+func (t *Manager) Source(name string) (SourceHandle, bool) {
+	for _, s := range t.allSources() {
+		if s.name == name {
+			return SourceHandle{id: s.id}, true
+		}
+	}
+	return SourceHandle{}, false
+}
+
+// RemoveSource removes the source, returning false if it isn't in the Manager.
+func (t *Manager) RemoveSource(h SourceHandle) bool {
+	if i := findSource(t.textProviders, h); i >= 0 {
+		t.textProviders = append(t.textProviders[:i], t.textProviders[i+1:]...)
+		return true
+	}
+	if i := findSource(t.disabledProviders, h); i >= 0 {
+		t.disabledProviders = append(t.disabledProviders[:i], t.disabledProviders[i+1:]...)
+		return true
+	}
+	return false
+}
+
+// ReplaceSource swaps the source's provider and options for new ones, keeping its handle and whether it is enabled. The
+// options of the old source aren't kept. It returns false if the source isn't in the Manager.
+func (t *Manager) ReplaceSource(h SourceHandle, provider TextEmbeddingProvider, opt ...SourceOption[TextEmbeddingProvider]) bool {
+	replacement := newSource(provider, opt)
+	replacement.id = h.id
+
+	for _, sources := range []*[]source[TextEmbeddingProvider]{&t.textProviders, &t.disabledProviders} {
+		i := findSource(*sources, h)
+		if i < 0 {
+			continue
+		}
+		(*sources)[i] = replacement
+		sortSources(*sources)
+		return true
+	}
+	return false
+}
+
+// ReplaceSourceTextProvider is like ReplaceSource, for a TextProvider.
+func (t *Manager) ReplaceSourceTextProvider(h SourceHandle, provider TextProvider, opt ...SourceOption[TextEmbeddingProvider]) bool {
+	return t.ReplaceSource(h, textProviderAdapter{provider}, opt...)
+}
+
+// DisableSource stops the source from being used until it is enabled again, returning false if it isn't enabled in the Manager.
+func (t *Manager) DisableSource(h SourceHandle) bool {
+	i := findSource(t.textProviders, h)
+	if i < 0 {
+		return false
+	}
+	s := t.textProviders[i]
+	t.textProviders = append(t.textProviders[:i], t.textProviders[i+1:]...)
+	t.disabledProviders = append(t.disabledProviders, s)
+	return true
+}
+
+// EnableSource uses a disabled source again, returning false if it isn't disabled in the Manager.
+func (t *Manager) EnableSource(h SourceHandle) bool {
+	i := findSource(t.disabledProviders, h)
+	if i < 0 {
+		return false
+	}
+	s := t.disabledProviders[i]
+	t.disabledProviders = append(t.disabledProviders[:i], t.disabledProviders[i+1:]...)
+	t.addSource(s)
+	return true
+}
+
+// SourceEnabled returns whether the source is in the Manager and enabled.
+func (t *Manager) SourceEnabled(h SourceHandle) bool {
+	return findSource(t.textProviders, h) >= 0
+}
+
+func newSource(provider TextEmbeddingProvider, opt []SourceOption[TextEmbeddingProvider]) source[TextEmbeddingProvider] {
+	s := source[TextEmbeddingProvider]{provider: provider, weight: 1.0}
+	for _, o := range opt {
+		o(&s)
+	}
+	return s
+}
+
+// addSource adds s to the enabled sources.
+func (t *Manager) addSource(s source[TextEmbeddingProvider]) {
+	t.textProviders = append(t.textProviders, s)
+	sortSources(t.textProviders)
+}
+
+func (t *Manager) allSources() []source[TextEmbeddingProvider] {
+	all := make([]source[TextEmbeddingProvider], 0, len(t.textProviders)+len(t.disabledProviders))
+	all = append(all, t.textProviders...)
+	return append(all, t.disabledProviders...)
+}
+
+// sortSources sorts by weight, keeping sources with the same weight in the order they were added.
+func sortSources(sources []source[TextEmbeddingProvider]) {
+	sort.SliceStable(sources, func(i, j int) bool {
+		if sources[i].weight != sources[j].weight {
+			return sources[i].weight > sources[j].weight
+		}
+		return sources[i].id < sources[j].id
+	})
+}
+
+func findSource(sources []source[TextEmbeddingProvider], h SourceHandle) int {
+	for i, s := range sources {
+		if h.id != 0 && s.id == h.id {
+			return i
+		}
+	}
+	return -1
+}
+
+func nextSourceID() uint64 {
+	return atomic.AddUint64(&lastSourceID, 1)
+}
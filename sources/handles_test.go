@@ -0,0 +1,60 @@
+package sources
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestManagerSourceHandles(t *testing.T) {
+	texts := func(m *Manager) []string {
+		got, err := m.GetSourceText(context.Background(), false, 0, 1000, "", "")
+		if err != nil {
+			t.Fatalf("GetSourceText() error = %v", err)
+		}
+		var result []string
+		for _, te := range got {
+			result = append(result, te.Text)
+		}
+		return result
+	}
+
+	parent := New(nil, WithDefaultEmbedder(HashingEmbedder{}))
+	rules := parent.AddSourceText("Be kind.", WithName("rules"))
+	inState := parent.AddSourceTextProvider(staticTextProvider{"Alice, Utah"}, WithName("In-state resumes"))
+	outOfState := parent.AddSourceTextProvider(staticTextProvider{"Bob, Texas"}, WithName("Out-of-state resumes"), WithWeight(0.9))
+
+	child := NewFromParent(parent)
+	if !child.ReplaceSourceTextProvider(inState, staticTextProvider{"Carol, Utah"}, WithName("In-state resumes")) {
+		t.Fatal("ReplaceSourceTextProvider() = false, want true")
+	}
+	if !child.DisableSource(rules) {
+		t.Fatal("DisableSource() = false, want true")
+	}
+	if child.DisableSource(rules) {
+		t.Error("DisableSource() of a disabled source = true, want false")
+	}
+
+	if got, want := texts(child), []string{"Carol, Utah", "Bob, Texas"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("child texts = %v, want %v", got, want)
+	}
+	if got, want := texts(parent), []string{"Be kind.", "Alice, Utah", "Bob, Texas"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("parent texts = %v, want %v", got, want)
+	}
+
+	if h, ok := child.Source("rules"); !ok || h != rules || child.SourceEnabled(h) {
+		t.Errorf("Source(rules) = %v, %v, enabled %v; want the disabled rules handle", h, ok, child.SourceEnabled(h))
+	}
+	if !child.EnableSource(rules) {
+		t.Fatal("EnableSource() = false, want true")
+	}
+	if !child.RemoveSource(outOfState) {
+		t.Fatal("RemoveSource() = false, want true")
+	}
+	if child.RemoveSource(outOfState) {
+		t.Error("RemoveSource() of a removed source = true, want false")
+	}
+	if got, want := texts(child), []string{"Be kind.", "Carol, Utah"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("child texts = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,209 @@
+package sources
+
+import (
+	"container/heap"
+	"math"
+	"math/rand"
+)
+
+// hnswGraph is a hierarchical navigable small world graph for approximate nearest neighbor search over normalized vectors.
+// Nodes are identified by their index in the vectors slice that is passed to insert and search.
+type hnswGraph struct {
+	m              int
+	m0             int // max neighbors on layer 0
+	efConstruction int
+	efSearch       int
+	levelMult      float64
+	rand           *rand.Rand
+
+	neighbors  [][][]int // node -> layer -> neighbors
+	entryPoint int
+	maxLevel   int
+}
+
+func newHNSWGraph(m, efConstruction, efSearch int) *hnswGraph {
+	if m <= 0 {
+		m = 16
+	}
+	if efConstruction <= 0 {
+		efConstruction = 200
+	}
+	if efSearch <= 0 {
+		efSearch = 64
+	}
+
+	return &hnswGraph{
+		m:              m,
+		m0:             m * 2,
+		efConstruction: efConstruction,
+		efSearch:       efSearch,
+		levelMult:      1 / math.Log(float64(m)),
+		// a fixed seed keeps the graph, and so search results, the same every time the same texts are added
+		rand:       rand.New(rand.NewSource(1)),
+		entryPoint: -1,
+	}
+}
+
+func distance(a, b []float32) float64 {
+	return 1 - dot(a, b)
+}
+
+func (g *hnswGraph) insert(id int, vectors [][]float32) {
+	level := int(math.Floor(-math.Log(1-g.rand.Float64()) * g.levelMult))
+	g.neighbors = append(g.neighbors, make([][]int, level+1))
+
+	if g.entryPoint == -1 {
+		g.entryPoint = id
+		g.maxLevel = level
+		return
+	}
+
+	query := vectors[id]
+	entry := g.entryPoint
+	for l := g.maxLevel; l > level; l-- {
+		entry = g.searchLayer(query, []int{entry}, 1, l, vectors)[0].id
+	}
+
+	top := level
+	if g.maxLevel < top {
+		top = g.maxLevel
+	}
+
+	entries := []int{entry}
+	for l := top; l >= 0; l-- {
+		candidates := g.searchLayer(query, entries, g.efConstruction, l, vectors)
+
+		maxNeighbors := g.m
+		if l == 0 {
+			maxNeighbors = g.m0
+		}
+
+		selected := candidates
+		if len(selected) > g.m {
+			selected = selected[:g.m]
+		}
+		for _, c := range selected {
+			g.neighbors[id][l] = append(g.neighbors[id][l], c.id)
+			g.neighbors[c.id][l] = append(g.neighbors[c.id][l], id)
+			if len(g.neighbors[c.id][l]) > maxNeighbors {
+				g.neighbors[c.id][l] = g.closest(vectors[c.id], g.neighbors[c.id][l], maxNeighbors, vectors)
+			}
+		}
+
+		entries = entries[:0]
+		for _, c := range candidates {
+			entries = append(entries, c.id)
+		}
+	}
+
+	if level > g.maxLevel {
+		g.maxLevel = level
+		g.entryPoint = id
+	}
+}
+
+// search returns up to ef nodes closest to query, closest first.
+func (g *hnswGraph) search(query []float32, ef int, vectors [][]float32) []int {
+	if g.entryPoint == -1 {
+		return nil
+	}
+
+	entry := g.entryPoint
+	for l := g.maxLevel; l > 0; l-- {
+		entry = g.searchLayer(query, []int{entry}, 1, l, vectors)[0].id
+	}
+
+	results := g.searchLayer(query, []int{entry}, ef, 0, vectors)
+	ids := make([]int, len(results))
+	for i, r := range results {
+		ids[i] = r.id
+	}
+	return ids
+}
+
+// searchLayer returns up to ef nodes on the layer closest to query, closest first.
+func (g *hnswGraph) searchLayer(query []float32, entries []int, ef int, layer int, vectors [][]float32) []hnswCandidate {
+	visited := map[int]bool{}
+	candidates := &hnswHeap{}       // closest first
+	results := &hnswHeap{max: true} // furthest first
+
+	for _, e := range entries {
+		visited[e] = true
+		c := hnswCandidate{id: e, distance: distance(query, vectors[e])}
+		heap.Push(candidates, c)
+		heap.Push(results, c)
+	}
+
+	for candidates.Len() > 0 {
+		c := heap.Pop(candidates).(hnswCandidate)
+		if results.Len() >= ef && c.distance > results.items[0].distance {
+			break
+		}
+
+		if layer >= len(g.neighbors[c.id]) {
+			continue
+		}
+		for _, n := range g.neighbors[c.id][layer] {
+			if visited[n] {
+				continue
+			}
+			visited[n] = true
+
+			d := distance(query, vectors[n])
+			if results.Len() < ef || d < results.items[0].distance {
+				heap.Push(candidates, hnswCandidate{id: n, distance: d})
+				heap.Push(results, hnswCandidate{id: n, distance: d})
+				if results.Len() > ef {
+					heap.Pop(results)
+				}
+			}
+		}
+	}
+
+	sorted := make([]hnswCandidate, results.Len())
+	for i := len(sorted) - 1; i >= 0; i-- {
+		sorted[i] = heap.Pop(results).(hnswCandidate)
+	}
+	return sorted
+}
+
+// closest returns the n ids closest to vector.
+func (g *hnswGraph) closest(vector []float32, ids []int, n int, vectors [][]float32) []int {
+	h := &hnswHeap{}
+	for _, id := range ids {
+		heap.Push(h, hnswCandidate{id: id, distance: distance(vector, vectors[id])})
+	}
+
+	result := make([]int, 0, n)
+	for h.Len() > 0 && len(result) < n {
+		result = append(result, heap.Pop(h).(hnswCandidate).id)
+	}
+	return result
+}
+
+type hnswCandidate struct {
+	id       int
+	distance float64
+}
+
+// hnswHeap is a min-heap of candidates by distance, or a max-heap if max is true.
+type hnswHeap struct {
+	items []hnswCandidate
+	max   bool
+}
+
+func (h hnswHeap) Len() int { return len(h.items) }
+func (h hnswHeap) Less(i, j int) bool {
+	if h.max {
+		return h.items[i].distance > h.items[j].distance
+	}
+	return h.items[i].distance < h.items[j].distance
+}
+func (h hnswHeap) Swap(i, j int)       { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *hnswHeap) Push(x interface{}) { h.items = append(h.items, x.(hnswCandidate)) }
+func (h *hnswHeap) Pop() interface{} {
+	old := h.items
+	item := old[len(old)-1]
+	h.items = old[:len(old)-1]
+	return item
+}
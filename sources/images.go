@@ -1,5 +1,290 @@
 package sources
 
-func (t *Manager) AddSourceImage(image string) {
-	panic("not implemented yet")
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"image"
+	_ "image/gif" // register decoders so image sizes can be read for token accounting
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/troylelandshields/hardconversations/logger"
+)
+
+const (
+	// MaxImageBytes is the largest image that can be sent to the API.
+	MaxImageBytes = 20 << 20
+
+	imageTileTokens = 170
+	imageBaseTokens = 85
+	imageMaxTiles   = 8 // the most tiles a high detail image can use (e.g. 2048x768), assumed for a URL image whose size isn't known
+)
+
+// ImageDetail is how closely the model looks at an image; low detail images always use 85 tokens.
+type ImageDetail string
+
+const (
+	ImageDetailAuto ImageDetail = "auto"
+	ImageDetailLow  ImageDetail = "low"
+	ImageDetailHigh ImageDetail = "high"
+)
+
+// Image is an image source that is sent to vision models along with the prompt.
+type Image struct {
+	// Identifier of the image; defaults to its path or URL
+	Identifier string
+	// Path of an image file, which is read the first time the image is used
+	Path string
+	// URL of the image, including data URLs
+	URL string
+	// Width and Height of an image at an http or https URL, if they are known; otherwise it is counted as the largest image
+	// the model accepts. The size of files, data URLs and Data is read from the image.
+	Width, Height int
+	// Data of the image, with its MIMEType (e.g. "image/png")
+	Data     []byte
+	MIMEType string
+	// Detail defaults to ImageDetailAuto
+	Detail ImageDetail
+	// Optional metadata that can be used to identify the image; images that get used are returned in the metadata response
+	Metadata interface{}
+
+	dataURL    string
+	tokenCount int
+	size       int
+
+	resolved *resolvedImage // shared by the copies of the image, so it is only read and encoded once
+}
+
+type resolvedImage struct {
+	once       sync.Once
+	err        error
+	dataURL    string
+	tokenCount int
+	size       int
+}
+
+// TokenCount returns the number of tokens the image uses in the request; only set for images that were used.
+func (i *Image) TokenCount() int {
+	return i.tokenCount
+}
+
+// Size returns the number of bytes of the image, or 0 if it was sent as a URL; only set for images that were used.
+func (i *Image) Size() int {
+	return i.size
+}
+
+// RequestURL returns the URL the image is sent as, which is a data URL for files and bytes; only set for images that were used.
+func (i *Image) RequestURL() string {
+	return i.dataURL
+}
+
+type ImageOption func(*Image)
+
+// WithImageDetail sets how closely the model looks at the image.
+func WithImageDetail(detail ImageDetail) ImageOption {
+	return func(i *Image) {
+		i.Detail = detail
+	}
+}
+
+// WithImageSize sets the width and height of an image at an http or https URL, so its tokens can be counted exactly.
+func WithImageSize(width, height int) ImageOption {
+	return func(i *Image) {
+		i.Width, i.Height = width, height
+	}
+}
+
+// WithImageMetadata sets the image's Metadata.
+func WithImageMetadata(metadata interface{}) ImageOption {
+	return func(i *Image) {
+		i.Metadata = metadata
+	}
+}
+
+// AddSourceImage adds an image file or URL (http, https or data) as a source for vision models.
+func (t *Manager) AddSourceImage(pathOrURL string, opt ...ImageOption) {
+	img := Image{Identifier: pathOrURL}
+	if strings.HasPrefix(pathOrURL, "http://") || strings.HasPrefix(pathOrURL, "https://") || strings.HasPrefix(pathOrURL, "data:") {
+		img.URL = pathOrURL
+	} else {
+		img.Path = pathOrURL
+	}
+	t.AddImage(img, opt...)
+}
+
+// AddSourceImageBytes adds an image with the given MIME type (e.g. "image/png") as a source for vision models.
+func (t *Manager) AddSourceImageBytes(data []byte, mimeType string, opt ...ImageOption) {
+	t.AddImage(Image{Data: data, MIMEType: mimeType}, opt...)
+}
+
+// AddImage adds an image as a source for vision models.
+func (t *Manager) AddImage(img Image, opt ...ImageOption) {
+	for _, o := range opt {
+		o(&img)
+	}
+	img.resolved = &resolvedImage{}
+	t.images = append(t.images, img)
+}
+
+// HasImages returns whether any image sources have been added.
+func (t *Manager) HasImages() bool {
+	return len(t.images) > 0
+}
+
+// GetSourceImages returns the image sources, in the order they were added, that fit in allowedTokens. Images that can't be
+// read or are too big return an error.
+func (t *Manager) GetSourceImages(ctx context.Context, allowedTokens int) ([]Image, error) {
+	var images []Image
+	for _, img := range t.images {
+		err := img.resolve()
+		if err != nil {
+			return nil, err
+		}
+
+		if img.tokenCount > allowedTokens {
+			logger.Debugf("Image %s uses %d tokens, only %d left, skipping", img.Identifier, img.tokenCount, allowedTokens)
+			continue
+		}
+		allowedTokens -= img.tokenCount
+		images = append(images, img)
+	}
+	return images, nil
+}
+
+// resolve reads the image if needed and sets its request URL, size and token count. Images added to a Manager are only
+// resolved the first time they are used.
+func (i *Image) resolve() error {
+	r := i.resolved
+	if r == nil {
+		r = &resolvedImage{}
+	}
+	r.once.Do(func() {
+		var img Image
+		img, r.err = i.read()
+		r.dataURL, r.tokenCount, r.size = img.dataURL, img.tokenCount, img.size
+	})
+	if r.err != nil {
+		return r.err
+	}
+	i.dataURL, i.tokenCount, i.size = r.dataURL, r.tokenCount, r.size
+	return nil
+}
+
+// read returns a copy of the image with its request URL, size and token count set.
+func (img Image) read() (Image, error) {
+	i := &img
+	data, mimeType := i.Data, i.MIMEType
+	if strings.HasPrefix(i.URL, "data:") && data == nil {
+		var err error
+		data, mimeType, err = parseDataURL(i.URL)
+		if err != nil {
+			return Image{}, errors.Wrapf(err, "error reading image %s", i.Identifier)
+		}
+	}
+	if i.Path != "" {
+		var err error
+		data, err = os.ReadFile(i.Path)
+		if err != nil {
+			return Image{}, errors.Wrapf(err, "error reading image %s", i.Path)
+		}
+		if mimeType == "" {
+			mimeType = mime.TypeByExtension(strings.ToLower(filepath.Ext(i.Path)))
+		}
+	}
+
+	if data == nil {
+		if i.URL == "" {
+			return Image{}, errors.New("image has no path, URL or data")
+		}
+		i.dataURL = i.URL
+		i.tokenCount = imageTokens(i.Detail, i.Width, i.Height)
+		return img, nil
+	}
+
+	if len(data) > MaxImageBytes {
+		return Image{}, errors.Errorf("image %s is %d bytes, the max is %d", i.Identifier, len(data), MaxImageBytes)
+	}
+	if mimeType == "" {
+		mimeType = http.DetectContentType(data)
+	}
+	if !strings.HasPrefix(mimeType, "image/") {
+		return Image{}, errors.Errorf("image %s has MIME type %s, not an image", i.Identifier, mimeType)
+	}
+
+	var width, height int
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		width, height = cfg.Width, cfg.Height
+	}
+
+	i.dataURL = i.URL
+	if i.dataURL == "" {
+		i.dataURL = "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(data)
+	}
+	i.size = len(data)
+	i.tokenCount = imageTokens(i.Detail, width, height)
+	return img, nil
+}
+
+// parseDataURL returns the data and MIME type of a data URL, e.g. data:image/png;base64,iVBORw0KGgo...
+func parseDataURL(dataURL string) ([]byte, string, error) {
+	header, payload, ok := strings.Cut(strings.TrimPrefix(dataURL, "data:"), ",")
+	if !ok {
+		return nil, "", errors.New("data URL has no data")
+	}
+
+	mimeType, isBase64 := header, false
+	if strings.HasSuffix(header, ";base64") {
+		mimeType, isBase64 = strings.TrimSuffix(header, ";base64"), true
+	}
+	if i := strings.Index(mimeType, ";"); i != -1 {
+		mimeType = mimeType[:i]
+	}
+
+	if isBase64 {
+		data, err := base64.StdEncoding.DecodeString(payload)
+		if err != nil {
+			return nil, "", errors.Wrap(err, "error decoding data URL")
+		}
+		return data, mimeType, nil
+	}
+	data, err := url.PathUnescape(payload)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "error decoding data URL")
+	}
+	return []byte(data), mimeType, nil
+}
+
+// imageTokens estimates the tokens an image uses: high detail images are scaled to fit in 2048x2048 and then so the shortest side
+// is 768, and use 170 tokens for every 512x512 tile plus 85. A width and height of 0 means the size is unknown, so the most
+// tiles an image can use are counted.
+func imageTokens(detail ImageDetail, width, height int) int {
+	if detail == ImageDetailLow {
+		return imageBaseTokens
+	}
+	if width <= 0 || height <= 0 {
+		return imageMaxTiles*imageTileTokens + imageBaseTokens
+	}
+
+	w, h := float64(width), float64(height)
+	if w > 2048 || h > 2048 {
+		scale := 2048 / math.Max(w, h)
+		w, h = w*scale, h*scale
+	}
+	if shortest := math.Min(w, h); shortest > 768 {
+		scale := 768 / shortest
+		w, h = w*scale, h*scale
+	}
+
+	tiles := int(math.Ceil(w/512) * math.Ceil(h/512))
+	return tiles*imageTileTokens + imageBaseTokens
 }
@@ -0,0 +1,104 @@
+package sources
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestImageTokens(t *testing.T) {
+	tests := []struct {
+		detail        ImageDetail
+		width, height int
+		want          int
+	}{
+		{ImageDetailLow, 4096, 4096, 85},
+		{ImageDetailHigh, 1024, 1024, 765},
+		{ImageDetailAuto, 2048, 4096, 1105},
+		{ImageDetailHigh, 100, 100, 255},
+		{ImageDetailHigh, 2048, 768, 1445},
+		{ImageDetailHigh, 0, 0, 1445},
+	}
+	for _, tt := range tests {
+		if got := imageTokens(tt.detail, tt.width, tt.height); got != tt.want {
+			t.Errorf("imageTokens(%s, %d, %d) = %d, want %d", tt.detail, tt.width, tt.height, got, tt.want)
+		}
+	}
+}
+
+func TestManagerGetSourceImages(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 100, 100))); err != nil {
+		t.Fatal(err)
+	}
+
+	m := New(nil)
+	m.AddSourceImageBytes(buf.Bytes(), "", WithImageMetadata("small"))
+	m.AddSourceImage("https://example.com/big.png")
+
+	got, err := m.GetSourceImages(context.Background(), 500)
+	if err != nil {
+		t.Fatalf("GetSourceImages() error = %v", err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("GetSourceImages() returned %d images, want only the one that fits", len(got))
+	}
+	if got[0].Metadata != "small" || got[0].TokenCount() != 255 || got[0].Size() != buf.Len() || !strings.HasPrefix(got[0].RequestURL(), "data:image/png;base64,") {
+		t.Errorf("GetSourceImages() = %+v", got[0])
+	}
+
+	m.AddSourceImageBytes([]byte("not an image"), "")
+	if _, err := m.GetSourceImages(context.Background(), 5000); err == nil {
+		t.Error("GetSourceImages() error = nil, want error for non-image data")
+	}
+}
+
+func TestImageResolve(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 100, 100))); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "small.png")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dataURL := "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+
+	m := New(nil)
+	m.AddSourceImage(dataURL)
+	m.AddSourceImage("https://example.com/small.png", WithImageSize(100, 100))
+	m.AddSourceImage("https://example.com/unknown.png")
+	m.AddSourceImage(path)
+
+	got, err := m.GetSourceImages(context.Background(), 10000)
+	if err != nil {
+		t.Fatalf("GetSourceImages() error = %v", err)
+	}
+	for i, want := range []int{255, 255, 1445, 255} {
+		if got[i].TokenCount() != want {
+			t.Errorf("image %s uses %d tokens, want %d", got[i].Identifier, got[i].TokenCount(), want)
+		}
+	}
+	if got[0].RequestURL() != dataURL {
+		t.Errorf("RequestURL() of a data URL image = %q, want the data URL", got[0].RequestURL())
+	}
+
+	// the file was read the first time and isn't read again
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	child := NewFromParent(m)
+	got, err = child.GetSourceImages(context.Background(), 10000)
+	if err != nil {
+		t.Fatalf("GetSourceImages() after the file was removed error = %v", err)
+	}
+	if got[3].RequestURL() == "" || got[3].Size() != buf.Len() {
+		t.Errorf("GetSourceImages() = %+v, want the file image read before", got[3])
+	}
+}
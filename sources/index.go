@@ -0,0 +1,368 @@
+package sources
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const defaultIndexTopK = 5
+
+// MetadataFilter decides whether a text in a VectorIndex can be returned from a search.
+type MetadataFilter func(te TextEmbedding) bool
+
+// MetadataEquals returns a filter that matches texts whose Metadata is a map (e.g. map[string]string or map[string]interface{})
+// or struct with the given key or field set to value. Numbers are compared by value regardless of their type, so 3 matches
+// the float64 3 that metadata loaded from an index file has.
+func MetadataEquals(key string, value interface{}) MetadataFilter {
+	return func(te TextEmbedding) bool {
+		v, ok := metadataValue(te.Metadata, key)
+		if !ok {
+			return false
+		}
+		if a, ok := numberValue(v); ok {
+			b, ok := numberValue(value)
+			return ok && a == b
+		}
+		return reflect.DeepEqual(v, value)
+	}
+}
+
+func numberValue(x interface{}) (float64, bool) {
+	v := reflect.ValueOf(x)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
+
+// AllFilters returns a filter that matches texts that match all of the filters.
+func AllFilters(filters ...MetadataFilter) MetadataFilter {
+	return func(te TextEmbedding) bool {
+		for _, f := range filters {
+			if f != nil && !f(te) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func metadataValue(metadata interface{}, key string) (interface{}, bool) {
+	v := reflect.ValueOf(metadata)
+	for v.IsValid() && (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return nil, false
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, false
+		}
+		mv := v.MapIndex(reflect.ValueOf(key).Convert(v.Type().Key()))
+		if !mv.IsValid() {
+			return nil, false
+		}
+		return mv.Interface(), true
+	case reflect.Struct:
+		fv := v.FieldByName(key)
+		if !fv.IsValid() || !fv.CanInterface() {
+			return nil, false
+		}
+		return fv.Interface(), true
+	}
+	return nil, false
+}
+
+// SearchResult is a text found by a VectorIndex search along with its cosine similarity to the query.
+type SearchResult struct {
+	TextEmbedding
+	Score float64
+}
+
+// VectorIndex holds texts and their embeddings in memory so they only need to be embedded once, and finds the texts most similar
+// to a query. It can be added to a Manager as a TextEmbeddingProvider that only returns the best matches for the prompt, but
+// it must use the same Embedder as the source so that the embeddings can be compared.
+type VectorIndex struct {
+	mu         sync.RWMutex
+	embedder   Embedder
+	topK       int
+	dimensions int
+	texts      []TextEmbedding
+	normalized [][]float32
+	hnsw       *hnswGraph // nil for exact search
+}
+
+type VectorIndexOption func(*VectorIndex)
+
+// WithTopK sets how many texts Sources returns. The default is 5.
+func WithTopK(k int) VectorIndexOption {
+	return func(v *VectorIndex) {
+		v.topK = k
+	}
+}
+
+// WithApproximateSearch uses an HNSW graph to search instead of comparing the query to every text, which is much faster for
+// large indexes but may miss some matches. m is the number of neighbors per node (default 16), efConstruction and efSearch
+// control the accuracy of building and searching the graph (defaults 200 and 64); pass 0 for the defaults.
+func WithApproximateSearch(m, efConstruction, efSearch int) VectorIndexOption {
+	return func(v *VectorIndex) {
+		v.hnsw = newHNSWGraph(m, efConstruction, efSearch)
+	}
+}
+
+// NewVectorIndex returns an empty index that uses embedder for texts added without an embedding and for text queries.
+func NewVectorIndex(embedder Embedder, opt ...VectorIndexOption) *VectorIndex {
+	v := &VectorIndex{
+		embedder: embedder,
+		topK:     defaultIndexTopK,
+	}
+
+	for _, o := range opt {
+		o(v)
+	}
+
+	return v
+}
+
+// Len returns the number of texts in the index.
+func (v *VectorIndex) Len() int {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return len(v.texts)
+}
+
+// Add adds texts to the index, embedding the ones that don't have an embedding yet. All embeddings must have the same dimensions.
+func (v *VectorIndex) Add(ctx context.Context, texts ...TextEmbedding) error {
+	var missing []string
+	var missingIdxs []int
+	for i, te := range texts {
+		if len(te.Embedding) == 0 {
+			missing = append(missing, textEmbeddingPrep(te.Text))
+			missingIdxs = append(missingIdxs, i)
+		}
+	}
+
+	if len(missing) > 0 {
+		if v.embedder == nil {
+			return errors.New("texts without embeddings can't be added to an index without an embedder")
+		}
+		embeddings, err := v.embedder.Embed(ctx, missing, "")
+		if err != nil {
+			return errors.Wrap(err, "error creating embeddings")
+		}
+		if len(embeddings) != len(missing) {
+			return errors.Errorf("embedder %s returned %d embeddings for %d texts", v.embedder.Model(), len(embeddings), len(missing))
+		}
+		texts = append([]TextEmbedding(nil), texts...)
+		for i, embedding := range embeddings {
+			texts[missingIdxs[i]].Embedding = embedding
+		}
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	// check every text before adding any, so the index isn't left half updated
+	dimensions := v.dimensions
+	for _, te := range texts {
+		if dimensions == 0 {
+			dimensions = len(te.Embedding)
+		}
+		if len(te.Embedding) != dimensions {
+			return errors.Errorf("embedding for %q has %d dimensions, index has %d", te.Identifier, len(te.Embedding), dimensions)
+		}
+	}
+	v.dimensions = dimensions
+
+	for _, te := range texts {
+		v.texts = append(v.texts, te)
+		v.normalized = append(v.normalized, normalize(te.Embedding))
+		if v.hnsw != nil {
+			v.hnsw.insert(len(v.texts)-1, v.normalized)
+		}
+	}
+
+	return nil
+}
+
+// Search returns up to k texts that match filter (which may be nil), ordered by cosine similarity to query, most similar first.
+func (v *VectorIndex) Search(query []float32, k int, filter MetadataFilter) ([]SearchResult, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if len(v.texts) == 0 || k <= 0 {
+		return nil, nil
+	}
+	if len(query) != v.dimensions {
+		return nil, errors.Errorf("query has %d dimensions, index has %d", len(query), v.dimensions)
+	}
+	query = normalize(query)
+
+	var ids []int
+	if v.hnsw != nil {
+		ids = v.searchApproximate(query, k, filter)
+	} else {
+		ids = v.searchExact(query, k, filter)
+	}
+
+	results := make([]SearchResult, len(ids))
+	for i, id := range ids {
+		results[i] = SearchResult{
+			TextEmbedding: v.texts[id],
+			Score:         dot(query, v.normalized[id]),
+		}
+	}
+	return results, nil
+}
+
+// SearchText embeds text and searches for it.
+func (v *VectorIndex) SearchText(ctx context.Context, text string, k int, filter MetadataFilter) ([]SearchResult, error) {
+	if v.embedder == nil {
+		return nil, errors.New("text can't be searched in an index without an embedder")
+	}
+	embeddings, err := v.embedder.Embed(ctx, []string{textEmbeddingPrep(text)}, "")
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating embeddings")
+	}
+	if len(embeddings) != 1 {
+		return nil, errors.Errorf("embedder %s returned %d embeddings for 1 text", v.embedder.Model(), len(embeddings))
+	}
+	return v.Search(embeddings[0], k, filter)
+}
+
+func (v *VectorIndex) searchExact(query []float32, k int, filter MetadataFilter) []int {
+	type scored struct {
+		id    int
+		score float64
+	}
+	var candidates []scored
+	for id := range v.texts {
+		if filter != nil && !filter(v.texts[id]) {
+			continue
+		}
+		candidates = append(candidates, scored{id: id, score: dot(query, v.normalized[id])})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].score > candidates[j].score
+	})
+	if len(candidates) > k {
+		candidates = candidates[:k]
+	}
+
+	ids := make([]int, len(candidates))
+	for i, c := range candidates {
+		ids[i] = c.id
+	}
+	return ids
+}
+
+func (v *VectorIndex) searchApproximate(query []float32, k int, filter MetadataFilter) []int {
+	ef := v.hnsw.efSearch
+	if ef < k {
+		ef = k
+	}
+	// filtering after the search can leave too few results, so look further when there is a filter
+	if filter != nil {
+		ef *= 4
+	}
+
+	var ids []int
+	for _, id := range v.hnsw.search(query, ef, v.normalized) {
+		if filter != nil && !filter(v.texts[id]) {
+			continue
+		}
+		ids = append(ids, id)
+		if len(ids) == k {
+			return ids
+		}
+	}
+
+	// the filter is too selective for the graph search to find enough matches, so fall back to checking every text
+	if filter != nil && len(ids) < k && len(ids) < len(v.texts) {
+		return v.searchExact(query, k, filter)
+	}
+	return ids
+}
+
+// Sources returns the texts most similar to the prompt, so a VectorIndex can be added to a Manager with AddSourceTextEmbeddingProvider.
+func (v *VectorIndex) Sources(ctx context.Context, prompt string) ([]TextEmbedding, error) {
+	return v.Provider(v.topK, nil).Sources(ctx, prompt)
+}
+
+// SourcesForQuery is like Sources, but only searches the texts that match the query's Filters.
+func (v *VectorIndex) SourcesForQuery(ctx context.Context, q SourceQuery) ([]TextEmbedding, error) {
+	return indexProvider{index: v, k: v.topK}.SourcesForQuery(ctx, q)
+}
+
+// Provider returns a TextEmbeddingProvider that returns the k texts that match filter and are most similar to the prompt.
+func (v *VectorIndex) Provider(k int, filter MetadataFilter) TextEmbeddingProvider {
+	return indexProvider{index: v, k: k, filter: filter}
+}
+
+type indexProvider struct {
+	index  *VectorIndex
+	k      int
+	filter MetadataFilter
+}
+
+func (p indexProvider) Sources(ctx context.Context, prompt string) ([]TextEmbedding, error) {
+	return p.SourcesForQuery(ctx, SourceQuery{Prompt: prompt})
+}
+
+// SourcesForQuery searches only the texts that match both the provider's filter and the query's Filters, so that filtering
+// doesn't leave fewer than k results.
+func (p indexProvider) SourcesForQuery(ctx context.Context, q SourceQuery) ([]TextEmbedding, error) {
+	filter := p.filter
+	if len(q.Filters) > 0 {
+		filter = AllFilters(append([]MetadataFilter{p.filter}, q.Filters...)...)
+	}
+
+	results, err := p.index.SearchText(ctx, q.Prompt, p.k, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	texts := make([]TextEmbedding, len(results))
+	for i, r := range results {
+		texts[i] = r.TextEmbedding
+	}
+	return texts, nil
+}
+
+func normalize(v []float32) []float32 {
+	var norm float64
+	for _, f := range v {
+		norm += float64(f) * float64(f)
+	}
+	result := make([]float32, len(v))
+	if norm == 0 {
+		return result
+	}
+	norm = math.Sqrt(norm)
+	for i, f := range v {
+		result[i] = float32(float64(f) / norm)
+	}
+	return result
+}
+
+func dot(a, b []float32) float64 {
+	var sum float64
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return sum
+}
@@ -0,0 +1,146 @@
+package sources
+
+import (
+	"context"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+func randomTexts(n, dims int, r *rand.Rand) []TextEmbedding {
+	texts := make([]TextEmbedding, n)
+	for i := range texts {
+		embedding := make([]float32, dims)
+		for j := range embedding {
+			embedding[j] = float32(r.NormFloat64())
+		}
+		texts[i] = TextEmbedding{
+			Identifier: strconv.Itoa(i),
+			Text:       "text " + strconv.Itoa(i),
+			Embedding:  embedding,
+			Metadata:   map[string]interface{}{"even": i%2 == 0},
+		}
+	}
+	return texts
+}
+
+func TestVectorIndexSearch(t *testing.T) {
+	r := rand.New(rand.NewSource(42))
+	texts := randomTexts(500, 32, r)
+
+	ctx := context.Background()
+	exact := NewVectorIndex(nil)
+	approximate := NewVectorIndex(nil, WithApproximateSearch(0, 0, 0))
+	if err := exact.Add(ctx, texts...); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if err := approximate.Add(ctx, texts...); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	var found, total int
+	for q := 0; q < 20; q++ {
+		query := randomTexts(1, 32, r)[0].Embedding
+
+		want, err := exact.Search(query, 10, nil)
+		if err != nil {
+			t.Fatalf("Search() error = %v", err)
+		}
+		for i := 1; i < len(want); i++ {
+			if want[i].Score > want[i-1].Score {
+				t.Fatalf("Search() results are not sorted by score")
+			}
+		}
+
+		got, err := approximate.Search(query, 10, nil)
+		if err != nil {
+			t.Fatalf("Search() error = %v", err)
+		}
+		ids := map[string]bool{}
+		for _, g := range got {
+			ids[g.Identifier] = true
+		}
+		for _, w := range want {
+			if ids[w.Identifier] {
+				found++
+			}
+			total++
+		}
+
+		filtered, err := approximate.Search(query, 10, MetadataEquals("even", true))
+		if err != nil {
+			t.Fatalf("Search() error = %v", err)
+		}
+		if len(filtered) != 10 {
+			t.Fatalf("Search() with filter returned %d results, want 10", len(filtered))
+		}
+		for _, f := range filtered {
+			if f.Metadata.(map[string]interface{})["even"] != true {
+				t.Fatalf("Search() with filter returned %s", f.Identifier)
+			}
+		}
+	}
+
+	if recall := float64(found) / float64(total); recall < 0.9 {
+		t.Errorf("approximate search recall = %f, want at least 0.9", recall)
+	}
+
+	if _, err := exact.Search(make([]float32, 8), 10, nil); err == nil {
+		t.Errorf("Search() expected error for query with the wrong dimensions")
+	}
+}
+
+func TestVectorIndexAddDimensionMismatch(t *testing.T) {
+	for _, opt := range [][]VectorIndexOption{nil, {WithApproximateSearch(8, 32, 32)}} {
+		r := rand.New(rand.NewSource(1))
+		index := NewVectorIndex(nil, opt...)
+		texts := append(randomTexts(3, 8, r), randomTexts(1, 4, r)...)
+
+		if err := index.Add(context.Background(), texts...); err == nil {
+			t.Fatal("Add() of mixed dimensions succeeded, want an error")
+		}
+		if index.Len() != 0 {
+			t.Errorf("Len() = %d after a failed Add, want 0", index.Len())
+		}
+		if err := index.Add(context.Background(), randomTexts(2, 4, r)...); err != nil {
+			t.Errorf("Add() after a failed Add error = %v", err)
+		}
+	}
+}
+
+func TestVectorIndexEmbeddingCount(t *testing.T) {
+	ctx := context.Background()
+
+	index := NewVectorIndex(miscountingEmbedder{extra: -1})
+	if err := index.Add(ctx, TextEmbedding{Text: "Go developer"}, TextEmbedding{Text: "Line cook"}); err == nil {
+		t.Error("Add() with a missing embedding succeeded, want an error")
+	}
+	if _, err := index.SearchText(ctx, "Go", 1, nil); err == nil {
+		t.Error("SearchText() without an embedding succeeded, want an error")
+	}
+}
+
+func TestVectorIndexProvider(t *testing.T) {
+	ctx := context.Background()
+	embedder := HashingEmbedder{}
+
+	index := NewVectorIndex(embedder, WithTopK(1))
+	err := index.Add(ctx,
+		TextEmbedding{Identifier: "designer", Text: "Graphic designer skilled in Adobe Creative Suite"},
+		TextEmbedding{Identifier: "developer", Text: "Backend developer experienced with Go and microservices"},
+	)
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	m := New(nil, WithDefaultEmbedder(embedder))
+	m.AddSourceTextEmbeddingProvider(index)
+
+	got, err := m.GetSourceText(ctx, true, 0, 1000, "Looking for a Go developer for microservices", "")
+	if err != nil {
+		t.Fatalf("GetSourceText() error = %v", err)
+	}
+	if len(got) != 1 || got[0].Identifier != "developer" {
+		t.Errorf("GetSourceText() = %+v, want only the developer", got)
+	}
+}
@@ -0,0 +1,403 @@
+package sources
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// Index files store texts and their embeddings so an index doesn't need to be re-embedded every time a process starts.
+//
+// The format is a header followed by any number of records, so new texts can be appended to an existing file:
+//
+//	header: "HCVI" | version uint16 | model length uvarint | model | dimensions uint32
+//	record: payload length uint32 | payload | crc32 of payload uint32
+//	payload: identifier length uvarint | identifier | text length uvarint | text | metadata JSON length uvarint | metadata JSON | weight float64 | embedding dimensions * float32
+//
+// All numbers are little endian. A record that was only partially written (e.g. the process died while appending) is ignored.
+//
+// Metadata is stored as JSON, so it is loaded as the types encoding/json decodes into an interface{}: structs and maps become
+// map[string]interface{} and all numbers become float64. MetadataEquals compares numbers by value, so its filters still match.
+
+const (
+	indexFileMagic         = "HCVI"
+	indexFileVersion       = 1
+	maxIndexFileRecordSize = 1 << 30
+	maxIndexFileModelSize  = 1 << 10
+)
+
+// ErrIndexMismatch is returned when an index file was created with a different embedding model or dimensions than expected.
+var ErrIndexMismatch = errors.New("index file embedding model or dimensions do not match")
+
+// IndexFileHeader describes the embeddings stored in an index file.
+type IndexFileHeader struct {
+	Model      string
+	Dimensions int
+}
+
+func (h IndexFileHeader) write(w io.Writer) error {
+	var buf bytes.Buffer
+	buf.WriteString(indexFileMagic)
+	binary.Write(&buf, binary.LittleEndian, uint16(indexFileVersion))
+	writeBytes(&buf, []byte(h.Model))
+	binary.Write(&buf, binary.LittleEndian, uint32(h.Dimensions))
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func readIndexFileHeader(r *bufio.Reader) (IndexFileHeader, error) {
+	magic := make([]byte, len(indexFileMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != indexFileMagic {
+		return IndexFileHeader{}, errors.New("not an index file")
+	}
+
+	var version uint16
+	if err := binary.Read(r, binary.LittleEndian, &version); err != nil {
+		return IndexFileHeader{}, errors.Wrap(err, "error reading index file header")
+	}
+	if version != indexFileVersion {
+		return IndexFileHeader{}, errors.Errorf("unsupported index file version %d", version)
+	}
+
+	model, err := readBytes(r, maxIndexFileModelSize)
+	if err != nil {
+		return IndexFileHeader{}, errors.Wrap(err, "error reading index file header")
+	}
+
+	var dims uint32
+	if err := binary.Read(r, binary.LittleEndian, &dims); err != nil {
+		return IndexFileHeader{}, errors.Wrap(err, "error reading index file header")
+	}
+	if dims == 0 {
+		return IndexFileHeader{}, errors.New("index file header has 0 dimensions")
+	}
+
+	return IndexFileHeader{Model: string(model), Dimensions: int(dims)}, nil
+}
+
+// IndexFileWriter appends texts to an index file.
+type IndexFileWriter struct {
+	f      *os.File
+	w      *bufio.Writer
+	header IndexFileHeader
+}
+
+// OpenIndexFileWriter opens the index file at path for appending, creating it if it doesn't exist. If the file exists, ErrIndexMismatch
+// is returned unless it was created with the same model and dimensions.
+func OpenIndexFileWriter(path string, model string, dimensions int) (*IndexFileWriter, error) {
+	if dimensions <= 0 {
+		return nil, errors.Errorf("index file must have a positive number of dimensions, got %d", dimensions)
+	}
+	header := IndexFileHeader{Model: model, Dimensions: dimensions}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening index file")
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, errors.Wrap(err, "error opening index file")
+	}
+
+	if info.Size() == 0 {
+		if err := header.write(f); err != nil {
+			f.Close()
+			return nil, errors.Wrap(err, "error writing index file header")
+		}
+	} else {
+		r, err := NewIndexFileReader(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if r.Header() != header {
+			f.Close()
+			return nil, errors.Wrapf(ErrIndexMismatch, "file has %s with %d dimensions", r.Header().Model, r.Header().Dimensions)
+		}
+
+		// find the end of the last complete record and drop anything after it
+		for {
+			_, err := r.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				f.Close()
+				return nil, err
+			}
+		}
+		if err := f.Truncate(r.offset); err != nil {
+			f.Close()
+			return nil, errors.Wrap(err, "error truncating partial record")
+		}
+	}
+
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		f.Close()
+		return nil, errors.Wrap(err, "error opening index file")
+	}
+
+	return &IndexFileWriter{
+		f:      f,
+		w:      bufio.NewWriter(f),
+		header: header,
+	}, nil
+}
+
+// Write appends a text to the file; its embedding must have the file's dimensions.
+func (w *IndexFileWriter) Write(te TextEmbedding) error {
+	if len(te.Embedding) != w.header.Dimensions {
+		return errors.Wrapf(ErrIndexMismatch, "embedding for %q has %d dimensions, file has %d", te.Identifier, len(te.Embedding), w.header.Dimensions)
+	}
+
+	metadata, err := json.Marshal(te.Metadata)
+	if err != nil {
+		return errors.Wrap(err, "error encoding metadata")
+	}
+
+	var payload bytes.Buffer
+	writeBytes(&payload, []byte(te.Identifier))
+	writeBytes(&payload, []byte(te.Text))
+	writeBytes(&payload, metadata)
+	binary.Write(&payload, binary.LittleEndian, te.Weight)
+	for _, f := range te.Embedding {
+		binary.Write(&payload, binary.LittleEndian, math.Float32bits(f))
+	}
+
+	var record bytes.Buffer
+	binary.Write(&record, binary.LittleEndian, uint32(payload.Len()))
+	record.Write(payload.Bytes())
+	binary.Write(&record, binary.LittleEndian, crc32.ChecksumIEEE(payload.Bytes()))
+
+	_, err = w.w.Write(record.Bytes())
+	return err
+}
+
+// Flush writes any buffered texts to the file.
+func (w *IndexFileWriter) Flush() error {
+	return w.w.Flush()
+}
+
+// Close flushes and closes the file.
+func (w *IndexFileWriter) Close() error {
+	err := w.w.Flush()
+	if closeErr := w.f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// IndexFileReader streams texts from an index file without loading the whole file into memory.
+type IndexFileReader struct {
+	r      *bufio.Reader
+	header IndexFileHeader
+	offset int64 // end of the last complete record
+}
+
+// NewIndexFileReader reads the header of an index file; call Next to read the texts.
+func NewIndexFileReader(r io.Reader) (*IndexFileReader, error) {
+	br := bufio.NewReader(r)
+	header, err := readIndexFileHeader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	var headerLen bytes.Buffer
+	header.write(&headerLen)
+
+	return &IndexFileReader{
+		r:      br,
+		header: header,
+		offset: int64(headerLen.Len()),
+	}, nil
+}
+
+func (r *IndexFileReader) Header() IndexFileHeader {
+	return r.header
+}
+
+// Next returns the next text in the file, or io.EOF when there are no more. A partially written record at the end of the file is treated as the end.
+func (r *IndexFileReader) Next() (TextEmbedding, error) {
+	var length uint32
+	if err := binary.Read(r.r, binary.LittleEndian, &length); err != nil {
+		return TextEmbedding{}, io.EOF
+	}
+
+	if length > maxIndexFileRecordSize {
+		return TextEmbedding{}, errors.Errorf("index file record at offset %d is corrupt", r.offset)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r.r, payload); err != nil {
+		return TextEmbedding{}, io.EOF
+	}
+
+	var checksum uint32
+	if err := binary.Read(r.r, binary.LittleEndian, &checksum); err != nil {
+		return TextEmbedding{}, io.EOF
+	}
+	if checksum != crc32.ChecksumIEEE(payload) {
+		return TextEmbedding{}, errors.Errorf("index file record at offset %d is corrupt", r.offset)
+	}
+
+	te, err := r.decode(payload)
+	if err != nil {
+		return TextEmbedding{}, errors.Wrapf(err, "error reading index file record at offset %d", r.offset)
+	}
+
+	r.offset += int64(4 + len(payload) + 4)
+	return te, nil
+}
+
+func (r *IndexFileReader) decode(payload []byte) (TextEmbedding, error) {
+	br := bytes.NewReader(payload)
+
+	identifier, err := readBytes(br, br.Len())
+	if err != nil {
+		return TextEmbedding{}, err
+	}
+	text, err := readBytes(br, br.Len())
+	if err != nil {
+		return TextEmbedding{}, err
+	}
+	metadataJSON, err := readBytes(br, br.Len())
+	if err != nil {
+		return TextEmbedding{}, err
+	}
+
+	var metadata interface{}
+	if err := json.Unmarshal(metadataJSON, &metadata); err != nil {
+		return TextEmbedding{}, errors.Wrap(err, "error decoding metadata")
+	}
+
+	var weight float64
+	if err := binary.Read(br, binary.LittleEndian, &weight); err != nil {
+		return TextEmbedding{}, err
+	}
+
+	if br.Len() != r.header.Dimensions*4 {
+		return TextEmbedding{}, errors.Wrapf(ErrIndexMismatch, "embedding has %d bytes, expected %d dimensions", br.Len(), r.header.Dimensions)
+	}
+	embedding := make([]float32, r.header.Dimensions)
+	rest := payload[len(payload)-br.Len():]
+	for i := range embedding {
+		embedding[i] = math.Float32frombits(binary.LittleEndian.Uint32(rest[i*4:]))
+	}
+
+	return TextEmbedding{
+		Identifier: string(identifier),
+		Text:       string(text),
+		Metadata:   metadata,
+		Weight:     weight,
+		Embedding:  embedding,
+	}, nil
+}
+
+// LoadIndexFile loads an index file into a VectorIndex that uses embedder for queries. ErrIndexMismatch is returned if the
+// file was created with a different model than embedder.
+func LoadIndexFile(path string, embedder Embedder, opt ...VectorIndexOption) (*VectorIndex, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening index file")
+	}
+	defer f.Close()
+
+	r, err := NewIndexFileReader(f)
+	if err != nil {
+		return nil, err
+	}
+	if embedder != nil && r.Header().Model != embedder.Model() {
+		return nil, errors.Wrapf(ErrIndexMismatch, "file has %s, embedder is %s", r.Header().Model, embedder.Model())
+	}
+
+	index := NewVectorIndex(embedder, opt...)
+	index.dimensions = r.Header().Dimensions
+
+	ctx := context.Background()
+	for {
+		te, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := index.Add(ctx, te); err != nil {
+			return nil, err
+		}
+	}
+
+	return index, nil
+}
+
+// SaveFile writes all of the texts in the index to a new index file at path, replacing it if it exists. An index that has
+// never had a text added can't be saved because its dimensions aren't known yet.
+func (v *VectorIndex) SaveFile(path string) error {
+	if v.embedder == nil {
+		return errors.New("an index without an embedder can't be saved")
+	}
+
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.dimensions == 0 {
+		return errors.New("an empty index can't be saved")
+	}
+
+	tmpPath := path + ".tmp"
+	os.Remove(tmpPath)
+	w, err := OpenIndexFileWriter(tmpPath, v.embedder.Model(), v.dimensions)
+	if err != nil {
+		return err
+	}
+	for _, te := range v.texts {
+		if err := w.Write(te); err != nil {
+			w.Close()
+			os.Remove(tmpPath)
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+func writeBytes(buf *bytes.Buffer, b []byte) {
+	var lenBuf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(lenBuf[:], uint64(len(b)))
+	buf.Write(lenBuf[:n])
+	buf.Write(b)
+}
+
+// readBytes reads bytes written by writeBytes, which may not be more than maxLength, so a corrupt length can't allocate more than
+// is left to read.
+func readBytes(r io.ByteReader, maxLength int) ([]byte, error) {
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if length > uint64(maxLength) {
+		return nil, errors.Errorf("length %d is more than the maximum of %d bytes", length, maxLength)
+	}
+	b := make([]byte, length)
+	for i := range b {
+		b[i], err = r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
@@ -0,0 +1,158 @@
+package sources
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestIndexFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resumes.hcvi")
+	embedder := HashingEmbedder{Dimensions: 16}
+	texts := randomTexts(10, 16, rand.New(rand.NewSource(1)))
+
+	w, err := OpenIndexFileWriter(path, embedder.Model(), 16)
+	if err != nil {
+		t.Fatalf("OpenIndexFileWriter() error = %v", err)
+	}
+	for _, te := range texts[:5] {
+		if err := w.Write(te); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// simulate a process dying in the middle of appending a record
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{200, 0, 0, 0, 1, 2, 3})
+	f.Close()
+
+	w, err = OpenIndexFileWriter(path, embedder.Model(), 16)
+	if err != nil {
+		t.Fatalf("OpenIndexFileWriter() error = %v", err)
+	}
+	for _, te := range texts[5:] {
+		if err := w.Write(te); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	if err := w.Write(TextEmbedding{Embedding: make([]float32, 4)}); !errors.Is(err, ErrIndexMismatch) {
+		t.Errorf("Write() error = %v, want ErrIndexMismatch", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	if _, err := OpenIndexFileWriter(path, "other-model", 16); !errors.Is(err, ErrIndexMismatch) {
+		t.Errorf("OpenIndexFileWriter() error = %v, want ErrIndexMismatch", err)
+	}
+	if _, err := LoadIndexFile(path, HashingEmbedder{Dimensions: 32}); !errors.Is(err, ErrIndexMismatch) {
+		t.Errorf("LoadIndexFile() error = %v, want ErrIndexMismatch", err)
+	}
+
+	index, err := LoadIndexFile(path, embedder)
+	if err != nil {
+		t.Fatalf("LoadIndexFile() error = %v", err)
+	}
+	if index.Len() != len(texts) {
+		t.Fatalf("LoadIndexFile() loaded %d texts, want %d", index.Len(), len(texts))
+	}
+	for i, te := range index.texts {
+		if te.Identifier != texts[i].Identifier || te.Text != texts[i].Text || !reflect.DeepEqual(te.Embedding, texts[i].Embedding) ||
+			!reflect.DeepEqual(te.Metadata, texts[i].Metadata) {
+			t.Errorf("text %d = %+v, want %+v", i, te, texts[i])
+		}
+	}
+
+	// a saved index can be loaded again and used as a source
+	savedPath := filepath.Join(t.TempDir(), "saved.hcvi")
+	if err := index.SaveFile(savedPath); err != nil {
+		t.Fatalf("SaveFile() error = %v", err)
+	}
+	saved, err := LoadIndexFile(savedPath, embedder, WithTopK(3))
+	if err != nil {
+		t.Fatalf("LoadIndexFile() error = %v", err)
+	}
+	got, err := saved.Sources(context.Background(), "text 3")
+	if err != nil {
+		t.Fatalf("Sources() error = %v", err)
+	}
+	if len(got) != 3 {
+		t.Errorf("Sources() returned %d texts, want 3", len(got))
+	}
+}
+
+func TestIndexFileMetadata(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resumes.hcvi")
+	embedder := HashingEmbedder{Dimensions: 16}
+
+	empty := NewVectorIndex(embedder)
+	if err := empty.SaveFile(path); err == nil {
+		t.Error("SaveFile() of an empty index succeeded, want an error")
+	}
+
+	index := NewVectorIndex(embedder)
+	texts := []TextEmbedding{
+		{Text: "Go developer", Metadata: map[string]interface{}{"years": 3}},
+		{Text: "Line cook", Metadata: map[string]interface{}{"years": 5}},
+	}
+	if err := index.Add(context.Background(), texts...); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if err := index.SaveFile(path); err != nil {
+		t.Fatalf("SaveFile() error = %v", err)
+	}
+
+	loaded, err := LoadIndexFile(path, embedder)
+	if err != nil {
+		t.Fatalf("LoadIndexFile() error = %v", err)
+	}
+	if got := loaded.texts[0].Metadata.(map[string]interface{})["years"]; got != float64(3) {
+		t.Errorf("loaded years = %#v, want float64(3)", got)
+	}
+	results, err := loaded.Search(loaded.texts[0].Embedding, 10, MetadataEquals("years", 3))
+	if err != nil {
+		t.Fatalf("Search() error = %v", err)
+	}
+	if len(results) != 1 || results[0].Text != "Go developer" {
+		t.Errorf("Search() = %+v, want the Go developer", results)
+	}
+}
+
+func TestReadBytes(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		want    string
+		wantErr bool
+	}{
+		{name: "valid", data: []byte{5, 'h', 'e', 'l', 'l', 'o'}, want: "hello"},
+		{name: "truncated", data: []byte{5, 'h', 'e'}, wantErr: true},
+		// a corrupt length of 1<<62 must not be allocated
+		{name: "length too large", data: []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x40}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bytes.NewReader(tt.data)
+			got, err := readBytes(r, r.Len())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readBytes() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("readBytes() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package sources
 import (
 	"context"
 	"sort"
+	"time"
 
 	"github.com/pkg/errors"
 	gogpt "github.com/sashabaranov/go-openai"
@@ -13,36 +14,177 @@ import (
 type Manager struct {
 	ai            *gogpt.Client
 	textProviders []source[TextEmbeddingProvider]
+	// disabledProviders are kept so they can be enabled again, but aren't used
+	disabledProviders []source[TextEmbeddingProvider]
+	images            []Image
+	cache             *countingCache
+	embedder          Embedder
+	chunker           Chunker
+	fusion            *Fusion
+	diversity         float64
+	parallelism       int
+	timeout           time.Duration
+	expansion         *ChunkExpansion
+	tokenizer         tokens.Tokenizer
+
+	queryAggregation QueryAggregation
 }
 
-func New(openAIClient *gogpt.Client) *Manager {
-	return &Manager{
+type ManagerOption func(*Manager)
+
+// WithEmbeddingCache sets the cache used to avoid re-embedding the same text. By default an in-memory LRU cache is used; nil disables caching.
+func WithEmbeddingCache(cache EmbeddingCache) ManagerOption {
+	return func(m *Manager) {
+		m.cache = &countingCache{cache: cache}
+	}
+}
+
+// WithDefaultEmbedder sets the Embedder used for sources that don't set their own with WithEmbedder. Defaults to OpenAI's text-embedding-3-small.
+func WithDefaultEmbedder(embedder Embedder) ManagerOption {
+	return func(m *Manager) {
+		m.embedder = embedder
+	}
+}
+
+// WithDefaultChunker sets the Chunker used for sources that don't set their own with WithChunker. Defaults to a TokenChunker of 2048 tokens.
+func WithDefaultChunker(chunker Chunker) ManagerOption {
+	return func(m *Manager) {
+		m.chunker = chunker
+	}
+}
+
+// WithDefaultFusion makes sources that don't set their own with WithFusion rank texts by both BM25 keyword matching and
+// embeddings. By default only embeddings are used.
+func WithDefaultFusion(fusion Fusion) ManagerOption {
+	return func(m *Manager) {
+		m.fusion = &fusion
+	}
+}
+
+// WithoutDefaultFusion makes sources that don't set their own Fusion with WithFusion rank texts only by embeddings, e.g. for a
+// Manager created with NewFromParent from one that uses WithDefaultFusion.
+func WithoutDefaultFusion() ManagerOption {
+	return func(m *Manager) {
+		m.fusion = nil
+	}
+}
+
+// WithMMR re-ranks relevant texts with maximal marginal relevance so that near-identical chunks don't crowd out other information.
+// diversity is between 0 and 1: 0 ranks only by relevance (the default), higher values penalize texts more for being similar
+// to texts ranked before them. Only used when sorting by relevance.
+func WithMMR(diversity float64) ManagerOption {
+	return func(m *Manager) {
+		m.diversity = diversity
+	}
+}
+
+func New(openAIClient *gogpt.Client, opt ...ManagerOption) *Manager {
+	m := &Manager{
 		ai:            openAIClient,
 		textProviders: []source[TextEmbeddingProvider]{},
+		cache:         &countingCache{cache: NewLRUEmbeddingCache(defaultEmbeddingCacheSize)},
+		embedder:      NewOpenAIEmbedder(openAIClient, string(defaultEmbeddingModel), 0),
+		chunker:       TokenChunker{Size: maxEmbeddingTokenCount},
+		parallelism:   defaultParallelism,
+		tokenizer:     tokens.Default(),
+	}
+
+	for _, o := range opt {
+		o(m)
 	}
+
+	return m
 }
 
-func NewFromParent(m *Manager) *Manager {
+// NewFromParent returns a Manager with m's sources and settings; opt can change the settings of the new Manager.
+func NewFromParent(m *Manager, opt ...ManagerOption) *Manager {
 	copiedProviders := make([]source[TextEmbeddingProvider], len(m.textProviders))
 	copy(copiedProviders, m.textProviders)
+	copiedDisabled := make([]source[TextEmbeddingProvider], len(m.disabledProviders))
+	copy(copiedDisabled, m.disabledProviders)
+	copiedImages := make([]Image, len(m.images))
+	copy(copiedImages, m.images)
+
+	child := &Manager{
+		ai:                m.ai,
+		textProviders:     copiedProviders,
+		disabledProviders: copiedDisabled,
+		images:            copiedImages,
+		cache:             m.cache,
+		embedder:          m.embedder,
+		chunker:           m.chunker,
+		fusion:            m.fusion,
+		diversity:         m.diversity,
+		parallelism:       m.parallelism,
+		timeout:           m.timeout,
+		expansion:         m.expansion,
+		tokenizer:         m.tokenizer,
+
+		queryAggregation: m.queryAggregation,
+	}
+	for _, o := range opt {
+		o(child)
+	}
 
+	return child
+}
+
+// WithoutSources returns a new Manager with the same settings (and embedding cache) as m, but without any sources.
+func (t *Manager) WithoutSources() *Manager {
 	return &Manager{
-		ai:            m.ai,
-		textProviders: copiedProviders,
+		ai:            t.ai,
+		textProviders: []source[TextEmbeddingProvider]{},
+		cache:         t.cache,
+		embedder:      t.embedder,
+		chunker:       t.chunker,
+		fusion:        t.fusion,
+		diversity:     t.diversity,
+		parallelism:   t.parallelism,
+		timeout:       t.timeout,
+		expansion:     t.expansion,
+		tokenizer:     t.tokenizer,
+
+		queryAggregation: t.queryAggregation,
 	}
 }
 
+// EmbeddingCacheStats returns the embedding cache hit and miss counts, shared by all Managers created from the same parent.
+func (t *Manager) EmbeddingCacheStats() EmbeddingCacheStats {
+	return t.cache.stats()
+}
+
 type source[T any] struct {
+	id             uint64
 	provider       T
 	weight         float64
 	maxTokens      int
 	allowErrors    bool
 	skipEmbeddings bool
+	embedder       Embedder
+	chunker        Chunker
+	fusion         *Fusion
+	timeout        time.Duration
+	expansion      *ChunkExpansion
+	name           string
+	description    string
 }
 
-// add other source options, like description
 type SourceOption[T any] func(*source[T])
 
+// WithName sets a name for the source, e.g. "In-state resumes", which is used to label its texts in the system message.
+func WithName(name string) SourceOption[TextEmbeddingProvider] {
+	return func(s *source[TextEmbeddingProvider]) {
+		s.name = name
+	}
+}
+
+// WithDescription sets a description of the source, e.g. "preferred", which is used to label its texts in the system message.
+func WithDescription(description string) SourceOption[TextEmbeddingProvider] {
+	return func(s *source[TextEmbeddingProvider]) {
+		s.description = description
+	}
+}
+
 // WithWeight sets the weight of a source. The weight is used to give more or less priority to a source. The default weight is 1.0.
 // Sources with a higher weight are used first, and if text embeddings are being used, the weight is multiplied by the cosine similarity
 // between the prompt and the source to make it more or less likely that the source will be used.
@@ -60,6 +202,27 @@ func WithSkipEmbeddings() SourceOption[TextEmbeddingProvider] {
 	}
 }
 
+// WithEmbedder sets the Embedder used for this source's text (and the prompt it is compared to), instead of the Manager's default.
+func WithEmbedder(embedder Embedder) SourceOption[TextEmbeddingProvider] {
+	return func(s *source[TextEmbeddingProvider]) {
+		s.embedder = embedder
+	}
+}
+
+// WithChunker sets how this source's text is split into chunks, instead of the Manager's default, e.g. MarkdownChunker{Size: 500, Overlap: 50}.
+func WithChunker(chunker Chunker) SourceOption[TextEmbeddingProvider] {
+	return func(s *source[TextEmbeddingProvider]) {
+		s.chunker = chunker
+	}
+}
+
+// WithFusion makes this source rank its texts by both BM25 keyword matching and embeddings, instead of the Manager's default.
+func WithFusion(fusion Fusion) SourceOption[TextEmbeddingProvider] {
+	return func(s *source[TextEmbeddingProvider]) {
+		s.fusion = &fusion
+	}
+}
+
 // WithMaxTokens sets the max amount of tokens this source can contribute to the contextual info. The default is 0, which means there is no limit
 func WithMaxTokens(m int) SourceOption[TextEmbeddingProvider] {
 	return func(s *source[TextEmbeddingProvider]) {
@@ -76,22 +239,77 @@ func WithAllowErrors() SourceOption[TextEmbeddingProvider] {
 
 // GetSourceText pulls text from the sources in order of weight until we run out of tokens. If sortByRelevance is true, then we will consider cosine similarity between prompt and text.
 // TODO: this is a bit of a mess, clean it up; also this might be the wrong place to be creating text embeddings since it could lead to a lot of repeated work
-// TODO: support chunking text into smaller pieces
 // TODO: I'm slapping userID as an optional param in here so I can pass it to OpenAI but I don't like it, figure out a better way
 func (t *Manager) GetSourceText(ctx context.Context, sortByRelevance bool, minCosineSimilarityThreshold float64, allowedTokens int, prompt string, userID string) ([]TextEmbedding, error) {
-	if !sortByRelevance {
-		return t.getSourceTextSimple(ctx, allowedTokens, prompt)
+	return t.Retrieve(ctx, RetrievalRequest{
+		SortByRelevance:     sortByRelevance,
+		MinCosineSimilarity: minCosineSimilarityThreshold,
+		AllowedTokens:       allowedTokens,
+		Prompt:              prompt,
+		UserID:              userID,
+	})
+}
+
+// RetrievalRequest describes which source texts to retrieve.
+type RetrievalRequest struct {
+	// SortByRelevance considers the similarity between Query and the texts, instead of only using the sources in order of weight
+	SortByRelevance bool
+	// MinCosineSimilarity is the minimum weighted cosine similarity a text needs to be used when sorting by relevance
+	MinCosineSimilarity float64
+	// AllowedTokens is the most tokens the texts can use in total
+	AllowedTokens int
+	// Prompt is passed to the source providers
+	Prompt string
+	// Query is compared to the texts to rank them by relevance; defaults to Prompt
+	Query  string
+	UserID string
+	// Input, Question, ThreadID and Filters are passed to providers in their SourceQuery; see SourceQuery
+	Input    interface{}
+	Question string
+	ThreadID string
+	Filters  []MetadataFilter
+}
+
+// SourceQuery returns the query passed to the source providers for this request.
+func (r RetrievalRequest) SourceQuery() SourceQuery {
+	return SourceQuery{
+		Prompt:   r.Prompt,
+		Input:    r.Input,
+		Question: r.Question,
+		ThreadID: r.ThreadID,
+		UserID:   r.UserID,
+		Filters:  r.Filters,
 	}
+}
 
-	return t.getSourceTextRelevant(ctx, minCosineSimilarityThreshold, allowedTokens, prompt, userID)
+// Retrieve pulls text from the sources as described by req.
+func (t *Manager) Retrieve(ctx context.Context, req RetrievalRequest) ([]TextEmbedding, error) {
+	return t.retrieve(ctx, req, nil)
+}
+
+// retrieve pulls text from the sources, recording every candidate in report unless it is nil.
+func (t *Manager) retrieve(ctx context.Context, req RetrievalRequest, report *RetrievalReport) ([]TextEmbedding, error) {
+	if !req.SortByRelevance {
+		return t.getSourceTextSimple(ctx, req.AllowedTokens, req.SourceQuery(), report)
+	}
+
+	query := req.Query
+	if query == "" {
+		query = req.Prompt
+	}
+	if report != nil {
+		report.Query = query
+	}
+	return t.getSourceTextRelevant(ctx, req.MinCosineSimilarity, req.AllowedTokens, req.SourceQuery(), query, report)
 }
 
 // getSourceTextSimple just pulls text from the sources in order of weight until we run out of tokens
-func (t *Manager) getSourceTextSimple(ctx context.Context, allowedTokens int, prompt string) ([]TextEmbedding, error) {
+func (t *Manager) getSourceTextSimple(ctx context.Context, allowedTokens int, q SourceQuery, report *RetrievalReport) ([]TextEmbedding, error) {
 	var contextualInfos []TextEmbedding
 
 	logger.Debugf("Pulling contextual info from source %d text providers...", len(t.textProviders))
-	for _, source := range t.textProviders {
+	fetched := t.fetchSources(ctx, q)
+	for i, source := range t.textProviders {
 		var sourceUsedTokens int
 
 		// use either the max tokens set by the source, or the allowed tokens left over, which ever is smaller
@@ -100,16 +318,22 @@ func (t *Manager) getSourceTextSimple(ctx context.Context, allowedTokens int, pr
 			sourceMaxTokens = source.maxTokens
 		}
 
-		sourceTextEmbeddings, err := source.provider.Sources(ctx, prompt)
+		sourceTextEmbeddings, err := fetched[i].texts, fetched[i].err
 		if err != nil {
 			if !source.allowErrors {
 				return nil, err
 			}
 			logger.Debugf("Source %T errored: %v", source.provider, err)
+			report.add(CandidateReport{Provider: providerName(source), Weight: source.weight, Disposition: DispositionProviderError, Err: err})
 		}
 
+		// once the budget is used up the rest of the texts are only counted for the report
+		var exhausted Disposition
+		if allowedTokens <= 0 {
+			exhausted = DispositionGlobalBudget
+		}
 		for _, sourceTextEmbedding := range sourceTextEmbeddings {
-			tokenCnt, err := tokens.Count(sourceTextEmbedding.Text)
+			tokenCnt, err := tokens.CountWith(t.tokenizer, sourceTextEmbedding.Text)
 			if err != nil {
 				if !source.allowErrors {
 					return nil, err
@@ -118,16 +342,40 @@ func (t *Manager) getSourceTextSimple(ctx context.Context, allowedTokens int, pr
 			}
 
 			// this source has used up all of its tokens, move on to the next source
-			if tokenCnt+sourceUsedTokens > sourceMaxTokens {
+			if exhausted == "" && tokenCnt+sourceUsedTokens > sourceMaxTokens {
 				logger.Debugf("Could not pull all contextual info for source, stopping at %d tokens (max of %d)", sourceUsedTokens, sourceMaxTokens)
-				break
+				exhausted = DispositionGlobalBudget
+				if sourceMaxTokens < allowedTokens {
+					exhausted = DispositionProviderBudget
+				}
+			}
+
+			sourceTextEmbedding.sourceName, sourceTextEmbedding.sourceDescription = source.name, source.description
+			candidate := CandidateReport{
+				Provider:    providerName(source),
+				Text:        sourceTextEmbedding,
+				Weight:      source.weight,
+				Score:       source.weight,
+				Tokens:      tokenCnt,
+				Disposition: DispositionIncluded,
+			}
+			if exhausted != "" {
+				if report == nil {
+					break
+				}
+				candidate.Disposition = exhausted
+				report.add(candidate)
+				continue
 			}
 
 			sourceUsedTokens += tokenCnt
+			if id := report.add(candidate); id >= 0 {
+				sourceTextEmbedding.candidates = []int{id}
+			}
 			contextualInfos = append(contextualInfos, sourceTextEmbedding)
 		}
 		allowedTokens -= sourceUsedTokens
-		if allowedTokens <= 0 {
+		if allowedTokens <= 0 && report == nil {
 			break
 		}
 	}
@@ -139,43 +387,131 @@ type contextualInfo struct {
 	Source                   TextEmbedding
 	WeightedCosineSimilarity float64
 	tokensLeft               *int
+	sourceIdx                int
+	expanded                 bool // Source is a whole parent section rather than a chunk
+	reportIdx                int  // index of the candidate in the RetrievalReport, if there is one
 }
 
 // getSourceTextRelevant gets all the sources, filter and sort by cosine similarity, then pull the top ones until we run out of tokens
-func (t *Manager) getSourceTextRelevant(ctx context.Context, minCosineSimilarityThreshold float64, allowedTokens int, prompt string, userID string) ([]TextEmbedding, error) {
+func (t *Manager) getSourceTextRelevant(ctx context.Context, minCosineSimilarityThreshold float64, allowedTokens int, sq SourceQuery, query string, report *RetrievalReport) ([]TextEmbedding, error) {
 	var contextualInfos []contextualInfo
 
-	promptEmbeddings, err := t.CreateTextEmbeddingsFromStrings(ctx, []string{prompt}, userID)
+	logger.Debugf("Pulling contextual info from source %d text providers...", len(t.textProviders))
+	fetched := t.fetchSources(ctx, sq)
+
+	// chunk every source's texts, in order of weight, and collect the texts that need embeddings for each embedder
+	type preparedSource struct {
+		texts    []TextEmbedding
+		embedder Embedder
+		failed   bool
+	}
+	prepared := make([]preparedSource, len(t.textProviders))
+	var embedders []Embedder
+	pending := map[string][]*TextEmbedding{}
+	pendingSources := map[string][]int{}
+	queryEmbeddings := map[string][]*TextEmbedding{}
+
+	queryChunks, err := t.queryChunks(query)
 	if err != nil {
 		return nil, err
 	}
-	// TODO: what if this gets chunked?
-	promptEmbedding := promptEmbeddings[0]
 
-	logger.Debugf("Pulling contextual info from source %d text providers...", len(t.textProviders))
-	for _, source := range t.textProviders {
-		sourceMax := source.maxTokens
-		if sourceMax == 0 {
-			sourceMax = allowedTokens
+	for i, source := range t.textProviders {
+		p := &prepared[i]
+		if fetched[i].err != nil {
+			if !source.allowErrors {
+				return nil, fetched[i].err
+			}
+			logger.Debugf("Source %T errored: %v", source.provider, fetched[i].err)
+			report.add(CandidateReport{Provider: providerName(source), Weight: source.weight, Disposition: DispositionProviderError, Err: fetched[i].err})
+			p.failed = true
+			continue
+		}
+
+		chunker := source.chunker
+		if chunker == nil {
+			chunker = t.chunker
 		}
-		sourceTextEmbeddings, err := source.provider.Sources(ctx, prompt)
+
+		var sectionChunker Chunker
+		if expansion := t.sourceExpansion(source); expansion.Parent {
+			sectionChunker = expansion.ParentChunker
+		}
+
+		p.texts, err = chunkTexts(t.tokenizer, chunker, sectionChunker, fetched[i].texts)
 		if err != nil {
 			if !source.allowErrors {
 				return nil, err
 			}
 			logger.Debugf("Source %T errored: %v", source.provider, err)
+			report.add(CandidateReport{Provider: providerName(source), Weight: source.weight, Disposition: DispositionProviderError, Err: err})
+			p.failed = true
 			continue
 		}
+		for j := range p.texts {
+			p.texts[j].sourceName, p.texts[j].sourceDescription = source.name, source.description
+		}
 
-		allSourceInfo, err := t.prepareForQuerying(ctx, sourceTextEmbeddings, userID, source.skipEmbeddings)
-		if err != nil {
+		if source.skipEmbeddings {
+			continue
+		}
+
+		p.embedder = source.embedder
+		if p.embedder == nil {
+			p.embedder = t.embedder
+		}
+
+		// the query is embedded once for every embedder used by the sources
+		model := p.embedder.Model()
+		if _, ok := queryEmbeddings[model]; !ok {
+			embedders = append(embedders, p.embedder)
+			for _, chunk := range queryChunks {
+				te := &TextEmbedding{Text: chunk}
+				queryEmbeddings[model] = append(queryEmbeddings[model], te)
+				pending[model] = append(pending[model], te)
+			}
+		}
+		for j := range p.texts {
+			pending[model] = append(pending[model], &p.texts[j])
+		}
+		pendingSources[model] = append(pendingSources[model], i)
+	}
+
+	// embed the texts of all sources that use the same embedder together
+	for _, embedder := range embedders {
+		model := embedder.Model()
+		err := t.embedTexts(ctx, embedder, pending[model], sq.UserID)
+		if err == nil {
+			continue
+		}
+		for _, i := range pendingSources[model] {
+			source := t.textProviders[i]
 			if !source.allowErrors {
 				return nil, err
 			}
 			logger.Debugf("Source %T errored: %v", source.provider, err)
+			report.add(CandidateReport{Provider: providerName(source), Weight: source.weight, Disposition: DispositionProviderError, Err: err})
+			prepared[i].failed = true
+		}
+	}
+
+	for i, source := range t.textProviders {
+		sourceMax := source.maxTokens
+		if sourceMax == 0 {
+			sourceMax = allowedTokens
+		}
+		if prepared[i].failed {
 			continue
 		}
+		allSourceInfo := prepared[i].texts
+
+		var sourceQueryEmbeddings [][]float32
+		if !source.skipEmbeddings {
+			sourceQueryEmbeddings = t.aggregateQuery(queryEmbeddings[prepared[i].embedder.Model()])
+		}
 
+		var candidates []TextEmbedding
+		var cosineSimilarities []float64
 		for _, sourceTextEmbedding := range allSourceInfo {
 			if sourceTextEmbedding.Weight == 0 {
 				sourceTextEmbedding.Weight = source.weight
@@ -184,50 +520,130 @@ func (t *Manager) getSourceTextRelevant(ctx context.Context, minCosineSimilarity
 			// get cosine similarity or use 1.0 if we're skipping embeddings
 			cosineSimilarity := 1.0
 			if !source.skipEmbeddings {
-				cosineSimilarity, err = t.cosineSimilarity(sourceTextEmbedding.Embedding, promptEmbedding.Embedding)
+				cosineSimilarity, err = t.querySimilarity(sourceTextEmbedding.Embedding, sourceQueryEmbeddings)
 				if err != nil {
 					if !source.allowErrors {
 						return nil, errors.Wrap(err, "failed to get cosine similarity")
 					}
 					logger.Debugf("Source %T errored: %v", source.provider, err)
+					report.add(CandidateReport{Provider: providerName(source), Text: sourceTextEmbedding, Weight: sourceTextEmbedding.Weight, Tokens: sourceTextEmbedding.tokenCount, Disposition: DispositionProviderError, Err: err})
 					continue
 				}
 			}
 
-			weightedCosineSimilarity := float64(cosineSimilarity) * sourceTextEmbedding.Weight
-			if weightedCosineSimilarity < minCosineSimilarityThreshold {
+			candidates = append(candidates, sourceTextEmbedding)
+			cosineSimilarities = append(cosineSimilarities, cosineSimilarity)
+		}
+
+		fusion := source.fusion
+		if fusion == nil {
+			fusion = t.fusion
+		}
+
+		var lexicalScores, fusedScores []float64
+		var bestLexical float64
+		if fusion != nil {
+			texts := make([]string, len(candidates))
+			for i, c := range candidates {
+				texts[i] = c.Text
+			}
+			lexicalScores, bestLexical = bm25Scores(query, texts)
+			fusedScores = fusion.fuse(cosineSimilarities, lexicalScores)
+		}
+
+		for j, sourceTextEmbedding := range candidates {
+			cosineSimilarity := cosineSimilarities[j]
+			scores := &RetrievalScores{
+				Semantic: cosineSimilarity,
+				Weight:   sourceTextEmbedding.Weight,
+				Score:    cosineSimilarity * sourceTextEmbedding.Weight,
+			}
+
+			weightedCosineSimilarity := cosineSimilarity * sourceTextEmbedding.Weight
+			lexicalMatch := false
+			if fusion != nil {
+				scores.Lexical = lexicalScores[j]
+				scores.Score = fusedScores[j] * sourceTextEmbedding.Weight
+				lexicalMatch = lexicalScores[j] > 0 && lexicalScores[j] >= fusion.minLexicalScore()*bestLexical
+			}
+
+			sourceTextEmbedding.Scores = scores
+			candidate := CandidateReport{
+				Provider:         providerName(source),
+				Text:             sourceTextEmbedding,
+				CosineSimilarity: cosineSimilarity,
+				Weight:           sourceTextEmbedding.Weight,
+				Score:            scores.Score,
+				Tokens:           sourceTextEmbedding.tokenCount,
+				Disposition:      DispositionGlobalBudget, // until it is selected
+			}
+
+			if weightedCosineSimilarity < minCosineSimilarityThreshold && !lexicalMatch {
 				logger.Debugf("Cosine similarity of %f is below threshold of %f, skipping", cosineSimilarity, minCosineSimilarityThreshold)
+				candidate.Disposition = DispositionBelowThreshold
+				report.add(candidate)
 				continue
 			}
 
 			contextualInfos = append(contextualInfos, contextualInfo{
 				Source:                   sourceTextEmbedding,
-				WeightedCosineSimilarity: weightedCosineSimilarity,
+				WeightedCosineSimilarity: scores.Score,
 				tokensLeft:               &sourceMax,
+				sourceIdx:                i,
+				reportIdx:                report.add(candidate),
 			})
 		}
 	}
 
-	// sort contextualInfos by weighted cosine similarity descending
-	sort.Slice(contextualInfos, func(i, j int) bool {
+	// sort contextualInfos by weighted cosine similarity (or fused score) descending
+	sort.SliceStable(contextualInfos, func(i, j int) bool {
 		return contextualInfos[i].WeightedCosineSimilarity > contextualInfos[j].WeightedCosineSimilarity
 	})
 
-	// add contextualInfos to contextualText until we run out of tokens
-	var contextualText []TextEmbedding
+	if t.diversity > 0 {
+		contextualInfos = rerankMMR(contextualInfos, t.diversity)
+	}
+
+	// add contextualInfos to selected until we run out of tokens
+	var selected []contextualInfo
 	for _, ci := range contextualInfos {
 		// if not enough tokens left, skip it
-		if ci.Source.tokenCount > *ci.tokensLeft || ci.Source.tokenCount > allowedTokens {
+		if ci.Source.tokenCount > allowedTokens {
+			continue
+		}
+		if ci.Source.tokenCount > *ci.tokensLeft {
+			report.setDisposition(ci.reportIdx, DispositionProviderBudget)
 			continue
 		}
 		// reduce source's tokens left and allowed tokens
 		*ci.tokensLeft -= ci.Source.tokenCount
 		allowedTokens -= ci.Source.tokenCount
-		contextualText = append(contextualText, ci.Source)
+		if ci.reportIdx >= 0 {
+			ci.Source.candidates = []int{ci.reportIdx}
+		}
+		selected = append(selected, ci)
+		report.setDisposition(ci.reportIdx, DispositionIncluded)
 		if allowedTokens <= 0 {
 			break
 		}
 	}
 
+	allTexts := make([][]TextEmbedding, len(prepared))
+	for i := range prepared {
+		allTexts[i] = prepared[i].texts
+	}
+	selected = t.expandChunks(selected, allTexts, allowedTokens)
+
+	contextualText := make([]TextEmbedding, len(selected))
+	for i, ci := range selected {
+		contextualText[i] = ci.Source
+	}
+
+	if report != nil {
+		sort.SliceStable(report.Candidates, func(i, j int) bool {
+			return report.Candidates[i].Score > report.Candidates[j].Score
+		})
+	}
+
 	return contextualText, nil
 }
@@ -0,0 +1,198 @@
+package sources
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+type staticTextProvider []string
+
+func (p staticTextProvider) Sources(ctx context.Context, prompt string) ([]string, error) {
+	return p, nil
+}
+
+func TestManagerGetSourceTextRelevant(t *testing.T) {
+	m := New(nil, WithDefaultEmbedder(HashingEmbedder{}))
+	m.AddSourceTextProvider(staticTextProvider{
+		"Graphic designer skilled in Adobe Creative Suite and typography",
+		"Backend developer experienced with Go, microservices and PostgreSQL",
+		"Line cook with experience in busy kitchens",
+	})
+
+	ctx := context.Background()
+	got, err := m.GetSourceText(ctx, true, 0.2, 1000, "Senior backend engineer: Go and microservices", "")
+	if err != nil {
+		t.Fatalf("GetSourceText() error = %v", err)
+	}
+	if len(got) == 0 || got[0].Text != "Backend developer experienced with Go, microservices and PostgreSQL" {
+		t.Fatalf("GetSourceText() = %+v, want the backend developer first", got)
+	}
+
+	// asking again is served from the embedding cache
+	before := m.EmbeddingCacheStats()
+	_, err = m.GetSourceText(ctx, true, 0.2, 1000, "Senior backend engineer: Go and microservices", "")
+	if err != nil {
+		t.Fatalf("GetSourceText() error = %v", err)
+	}
+	after := m.EmbeddingCacheStats()
+	if after.Misses != before.Misses || after.Hits != before.Hits+4 {
+		t.Errorf("EmbeddingCacheStats() = %+v, want 4 more hits than %+v", after, before)
+	}
+}
+
+func TestManagerGetSourceTextHybrid(t *testing.T) {
+	texts := staticTextProvider{
+		"Backend developer experienced with Go, microservices and PostgreSQL",
+		"Graphic designer skilled in Adobe Creative Suite and typography",
+		"Line cook with experience in busy kitchens",
+	}
+
+	for _, method := range []FusionMethod{FusionWeightedSum, FusionReciprocalRank} {
+		t.Run(string(method), func(t *testing.T) {
+			m := New(nil, WithDefaultEmbedder(HashingEmbedder{}), WithDefaultFusion(Fusion{Method: method, LexicalWeight: 0.8, SemanticWeight: 0.2}))
+			m.AddSourceTextProvider(texts)
+
+			// the threshold is too high for any cosine similarity, but keyword matches are still kept; sharing only a common word
+			// of the instruction, like "with", isn't enough
+			got, err := m.GetSourceText(context.Background(), true, 0.99, 1000, "Answer with a name only. Who knows Adobe Creative Suite?", "")
+			if err != nil {
+				t.Fatalf("GetSourceText() error = %v", err)
+			}
+			if len(got) != 1 || got[0].Text != texts[1] {
+				t.Fatalf("GetSourceText() = %+v, want only the graphic designer", got)
+			}
+
+			scores := got[0].Scores
+			if scores == nil || scores.Lexical <= 0 || scores.Semantic <= 0 || scores.Weight != 1 || scores.Score <= 0 {
+				t.Errorf("Scores = %+v, want semantic, lexical and fused scores", scores)
+			}
+		})
+	}
+}
+
+func TestBM25Scores(t *testing.T) {
+	scores, _ := bm25Scores("creative suite", []string{
+		"Adobe Creative Suite",
+		"creative writing",
+		"nothing relevant",
+	})
+	if !(scores[0] > scores[1] && scores[1] > scores[2] && scores[2] == 0) {
+		t.Errorf("bm25Scores() = %v, want strictly decreasing to 0", scores)
+	}
+}
+
+func TestManagerGetSourceTextMMR(t *testing.T) {
+	texts := staticTextProvider{
+		"Backend developer experienced with Go, microservices and PostgreSQL",
+		"Backend developer experienced with Go, microservices and PostgreSQL databases",
+		"Backend engineer who builds Go services",
+	}
+	prompt := "Backend developer with Go and microservices"
+
+	for _, tt := range []struct {
+		diversity float64
+		second    string
+	}{
+		{diversity: 0, second: texts[1]},
+		{diversity: 0.7, second: texts[2]},
+	} {
+		m := New(nil, WithDefaultEmbedder(HashingEmbedder{}), WithMMR(tt.diversity))
+		m.AddSourceTextProvider(texts)
+
+		got, err := m.GetSourceText(context.Background(), true, 0, 1000, prompt, "")
+		if err != nil {
+			t.Fatalf("GetSourceText() error = %v", err)
+		}
+		if len(got) != 3 || got[1].Text != tt.second {
+			t.Errorf("diversity %v: GetSourceText() second text = %q, want %q", tt.diversity, got[1].Text, tt.second)
+		}
+	}
+}
+
+func TestManagerRetrieveQueryAggregation(t *testing.T) {
+	texts := staticTextProvider{
+		"Backend developer experienced with Go and microservices",
+		"Graphic designer skilled in Adobe Creative Suite",
+	}
+	// the query covers two different topics; comparing each chunk on its own finds both
+	query := "Backend developer experienced with Go and microservices.\n\nGraphic designer skilled in Adobe Creative Suite."
+
+	for _, tt := range []struct {
+		aggregation QueryAggregation
+		want        int
+	}{
+		{QueryMeanChunk, 0},
+		{QueryMaxChunk, 2},
+	} {
+		m := New(nil, WithDefaultEmbedder(HashingEmbedder{}), WithDefaultChunker(ParagraphChunker{Size: 12}), WithQueryAggregation(tt.aggregation))
+		m.AddSourceTextProvider(texts)
+
+		got, err := m.Retrieve(context.Background(), RetrievalRequest{
+			SortByRelevance:     true,
+			MinCosineSimilarity: 0.9,
+			AllowedTokens:       1000,
+			Prompt:              "unrelated prompt",
+			Query:               query,
+		})
+		if err != nil {
+			t.Fatalf("Retrieve() error = %v", err)
+		}
+		if len(got) != tt.want {
+			t.Errorf("%s: Retrieve() returned %d texts, want %d", tt.aggregation, len(got), tt.want)
+		}
+	}
+}
+
+func TestEmbeddingBatches(t *testing.T) {
+	inputs := []string{"a", "b", "c", "d", "e"}
+
+	tests := []struct {
+		name      string
+		counts    []int
+		maxSize   int
+		maxTokens int
+		want      []int // the size of each batch
+	}{
+		{name: "by size", counts: []int{1, 1, 1, 1, 1}, maxSize: 2, maxTokens: 100, want: []int{2, 2, 1}},
+		{name: "by tokens", counts: []int{4, 4, 4, 4, 4}, maxSize: 10, maxTokens: 10, want: []int{2, 2, 1}},
+		{name: "at the token limit", counts: []int{5, 5, 5, 5, 5}, maxSize: 10, maxTokens: 10, want: []int{2, 2, 1}},
+		{name: "input over the token limit", counts: []int{1, 20, 1, 1, 1}, maxSize: 10, maxTokens: 10, want: []int{1, 1, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []int
+			for _, batch := range embeddingBatches(inputs, tt.counts, tt.maxSize, tt.maxTokens) {
+				got = append(got, len(batch))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("embeddingBatches() sizes = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// miscountingEmbedder returns extra embeddings more than it was asked for, or drops some if extra is negative.
+type miscountingEmbedder struct {
+	HashingEmbedder
+	extra int
+}
+
+func (e miscountingEmbedder) Embed(ctx context.Context, texts []string, userID string) ([][]float32, error) {
+	embeddings, err := e.HashingEmbedder.Embed(ctx, texts, userID)
+	if err != nil || e.extra < 0 {
+		return embeddings[:len(embeddings)+e.extra], err
+	}
+	return append(embeddings, make([][]float32, e.extra)...), nil
+}
+
+func TestEmbedTextsCount(t *testing.T) {
+	for _, extra := range []int{-1, 1} {
+		m := New(nil, WithEmbeddingCache(nil))
+		texts := []*TextEmbedding{{Text: "Go developer"}, {Text: "Line cook"}, {Text: "Accountant"}}
+		if err := m.embedTexts(context.Background(), miscountingEmbedder{extra: extra}, texts, ""); err == nil {
+			t.Errorf("embedTexts() with %d extra embeddings succeeded, want an error", extra)
+		}
+	}
+}
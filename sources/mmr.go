@@ -0,0 +1,52 @@
+package sources
+
+// rerankMMR reorders contextualInfos, which must be sorted by score, with maximal marginal relevance: each next text is the one
+// with the best (1-diversity)*score - diversity*(highest cosine similarity to a text before it), so near-duplicate chunks don't
+// fill the token budget. Texts without comparable embeddings aren't considered similar to anything.
+func rerankMMR(contextualInfos []contextualInfo, diversity float64) []contextualInfo {
+	remaining := append([]contextualInfo(nil), contextualInfos...)
+	redundancy := make([]float64, len(remaining))
+	normalized := make([][]float32, len(remaining))
+	for i, ci := range remaining {
+		if len(ci.Source.Embedding) > 0 {
+			normalized[i] = normalize(ci.Source.Embedding)
+		}
+	}
+
+	reranked := make([]contextualInfo, 0, len(remaining))
+	for len(remaining) > 0 {
+		best := 0
+		bestScore := 0.0
+		for i, ci := range remaining {
+			score := (1-diversity)*ci.WeightedCosineSimilarity - diversity*redundancy[i]
+			if i == 0 || score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+
+		selected := remaining[best]
+		selectedEmbedding := normalized[best]
+		if selected.Source.Scores != nil {
+			selected.Source.Scores.Redundancy = redundancy[best]
+		}
+		reranked = append(reranked, selected)
+
+		remaining = append(remaining[:best], remaining[best+1:]...)
+		redundancy = append(redundancy[:best], redundancy[best+1:]...)
+		normalized = append(normalized[:best], normalized[best+1:]...)
+
+		if selectedEmbedding == nil {
+			continue
+		}
+		for i, embedding := range normalized {
+			if len(embedding) != len(selectedEmbedding) {
+				continue
+			}
+			if sim := dot(embedding, selectedEmbedding); sim > redundancy[i] {
+				redundancy[i] = sim
+			}
+		}
+	}
+
+	return reranked
+}
@@ -0,0 +1,82 @@
+package sources
+
+import (
+	"github.com/pkg/errors"
+	"github.com/troylelandshields/hardconversations/internal/tokens"
+)
+
+// QueryAggregation is how a retrieval query that is too long to embed at once is compared to the source texts.
+type QueryAggregation string
+
+const (
+	// QueryFirstChunk only embeds the start of the query (up to 2048 tokens). This is the default.
+	QueryFirstChunk QueryAggregation = "first"
+	// QueryMaxChunk splits the query with the Manager's default Chunker and uses a text's highest similarity to any of the chunks.
+	QueryMaxChunk QueryAggregation = "max"
+	// QueryMeanChunk splits the query with the Manager's default Chunker and compares texts to the mean of the chunks' embeddings.
+	QueryMeanChunk QueryAggregation = "mean"
+)
+
+// WithQueryAggregation sets how long retrieval queries are compared to source texts.
+func WithQueryAggregation(aggregation QueryAggregation) ManagerOption {
+	return func(m *Manager) {
+		m.queryAggregation = aggregation
+	}
+}
+
+func (t *Manager) queryChunks(query string) ([]string, error) {
+	var chunks []string
+	var err error
+	switch t.queryAggregation {
+	case QueryMaxChunk, QueryMeanChunk:
+		chunks, err = t.chunker.Chunk(query)
+	default:
+		chunks, err = tokens.Chunk(query, maxEmbeddingTokenCount)
+		if len(chunks) > 1 {
+			chunks = chunks[:1]
+		}
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "error chunking query")
+	}
+	if len(chunks) == 0 {
+		chunks = []string{query}
+	}
+	return chunks, nil
+}
+
+// aggregateQuery returns the embeddings a text is compared to: the mean of the query's chunks for QueryMeanChunk, otherwise all of them.
+func (t *Manager) aggregateQuery(queryEmbeddings []*TextEmbedding) [][]float32 {
+	if t.queryAggregation == QueryMeanChunk && len(queryEmbeddings) > 1 {
+		mean := make([]float32, len(queryEmbeddings[0].Embedding))
+		for _, te := range queryEmbeddings {
+			for i, f := range normalize(te.Embedding) {
+				if i < len(mean) {
+					mean[i] += f
+				}
+			}
+		}
+		return [][]float32{mean}
+	}
+
+	embeddings := make([][]float32, len(queryEmbeddings))
+	for i, te := range queryEmbeddings {
+		embeddings[i] = te.Embedding
+	}
+	return embeddings
+}
+
+// querySimilarity returns the highest cosine similarity between embedding and any of the query embeddings.
+func (t *Manager) querySimilarity(embedding []float32, queryEmbeddings [][]float32) (float64, error) {
+	var best float64
+	for i, q := range queryEmbeddings {
+		similarity, err := t.cosineSimilarity(embedding, q)
+		if err != nil {
+			return 0, err
+		}
+		if i == 0 || similarity > best {
+			best = similarity
+		}
+	}
+	return best, nil
+}
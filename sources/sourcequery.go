@@ -0,0 +1,98 @@
+package sources
+
+import "context"
+
+// SourceQuery describes the request that source texts are being retrieved for, so providers can return only the texts that
+// apply to it.
+type SourceQuery struct {
+	// Prompt is the full prompt sent to the model
+	Prompt string
+	// Input is the question's input as it was passed to the question method, e.g. a struct; questions with several named inputs
+	// pass them as a map[string]interface{} keyed by name. It is nil for questions without an input.
+	Input interface{}
+	// Question is the name of the question's function, if it has one
+	Question string
+	// ThreadID identifies the thread the question was asked on
+	ThreadID string
+	UserID   string
+	// Filters are checked against every text the providers return (see MetadataEquals), and texts that don't match all of
+	// them aren't used. Providers can also check them with Matches to avoid loading texts that would be filtered out.
+	Filters []MetadataFilter
+}
+
+// Matches reports whether te matches all of the query's Filters.
+func (q SourceQuery) Matches(te TextEmbedding) bool {
+	return AllFilters(q.Filters...)(te)
+}
+
+// QueryProvider is a TextEmbeddingProvider that gets the whole SourceQuery instead of just the prompt.
+type QueryProvider interface {
+	SourcesForQuery(ctx context.Context, q SourceQuery) ([]TextEmbedding, error)
+}
+
+// TextQueryProvider is a TextProvider that gets the whole SourceQuery instead of just the prompt.
+type TextQueryProvider interface {
+	SourcesForQuery(ctx context.Context, q SourceQuery) ([]string, error)
+}
+
+// AddSourceQueryProvider adds a provider that is given the SourceQuery of each request.
+func (t *Manager) AddSourceQueryProvider(provider QueryProvider, opt ...SourceOption[TextEmbeddingProvider]) SourceHandle {
+	return t.AddSourceTextEmbeddingProvider(queryProviderAdapter{provider}, opt...)
+}
+
+// AddSourceTextQueryProvider adds a text provider that is given the SourceQuery of each request.
+func (t *Manager) AddSourceTextQueryProvider(provider TextQueryProvider, opt ...SourceOption[TextEmbeddingProvider]) SourceHandle {
+	return t.AddSourceTextEmbeddingProvider(textQueryProviderAdapter{provider}, opt...)
+}
+
+type queryProviderAdapter struct {
+	QueryProvider
+}
+
+func (a queryProviderAdapter) Sources(ctx context.Context, prompt string) ([]TextEmbedding, error) {
+	return a.SourcesForQuery(ctx, SourceQuery{Prompt: prompt})
+}
+
+type textQueryProviderAdapter struct {
+	TextQueryProvider
+}
+
+func (a textQueryProviderAdapter) Sources(ctx context.Context, prompt string) ([]TextEmbedding, error) {
+	return a.SourcesForQuery(ctx, SourceQuery{Prompt: prompt})
+}
+
+func (a textQueryProviderAdapter) SourcesForQuery(ctx context.Context, q SourceQuery) ([]TextEmbedding, error) {
+	texts, err := a.TextQueryProvider.SourcesForQuery(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]TextEmbedding, 0, len(texts))
+	for _, text := range texts {
+		result = append(result, TextEmbedding{Text: text})
+	}
+	return result, nil
+}
+
+// querySources calls the provider with the whole query if it is a QueryProvider, or else just the prompt.
+func querySources(ctx context.Context, provider TextEmbeddingProvider, q SourceQuery) ([]TextEmbedding, error) {
+	if qp, ok := provider.(QueryProvider); ok {
+		return qp.SourcesForQuery(ctx, q)
+	}
+	return provider.Sources(ctx, q.Prompt)
+}
+
+// filterTexts returns the texts whose Metadata matches all of the query's Filters.
+func filterTexts(texts []TextEmbedding, q SourceQuery) []TextEmbedding {
+	if len(q.Filters) == 0 {
+		return texts
+	}
+
+	filtered := texts[:0:0]
+	for _, te := range texts {
+		if q.Matches(te) {
+			filtered = append(filtered, te)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,75 @@
+package sources
+
+import (
+	"context"
+	"testing"
+)
+
+type job struct {
+	State string
+}
+
+// stateTextProvider returns the texts for the state of the job passed as the question's input.
+type stateTextProvider map[string][]string
+
+func (p stateTextProvider) SourcesForQuery(ctx context.Context, q SourceQuery) ([]string, error) {
+	j, _ := q.Input.(job)
+	return p[j.State], nil
+}
+
+func TestManagerSourceQuery(t *testing.T) {
+	m := New(nil, WithDefaultEmbedder(HashingEmbedder{}), WithEmbeddingCache(nil))
+	m.AddSourceTextQueryProvider(stateTextProvider{
+		"UT": {"Alice, Utah"},
+		"CA": {"Bob, California"},
+	})
+	m.AddSourceTextEmbeddingProvider(staticTextEmbeddingProvider{
+		{Identifier: "carol", Text: "Carol", Metadata: map[string]string{"state": "UT"}},
+		{Identifier: "dan", Text: "Dan", Metadata: map[string]interface{}{"state": "CA"}},
+		{Identifier: "erin", Text: "Erin"},
+	})
+
+	tests := []struct {
+		name string
+		req  RetrievalRequest
+		want []string
+	}{
+		{
+			name: "no input",
+			req:  RetrievalRequest{AllowedTokens: 1000, Prompt: "resumes"},
+			want: []string{"Carol", "Dan", "Erin"},
+		},
+		{
+			name: "input",
+			req:  RetrievalRequest{AllowedTokens: 1000, Prompt: "resumes", Input: job{State: "CA"}},
+			want: []string{"Bob, California", "Carol", "Dan", "Erin"},
+		},
+		{
+			name: "filters",
+			req:  RetrievalRequest{AllowedTokens: 1000, Prompt: "resumes", Input: job{State: "UT"}, Filters: []MetadataFilter{MetadataEquals("state", "CA")}},
+			want: []string{"Dan"},
+		},
+		{
+			name: "filters when sorting by relevance",
+			req:  RetrievalRequest{SortByRelevance: true, AllowedTokens: 1000, Prompt: "resumes", Filters: []MetadataFilter{MetadataEquals("state", "UT")}},
+			want: []string{"Carol"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := m.Retrieve(context.Background(), tt.req)
+			if err != nil {
+				t.Fatalf("Retrieve() error = %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Retrieve() returned %d texts, want %d", len(got), len(tt.want))
+			}
+			for i, te := range got {
+				if te.Text != tt.want[i] {
+					t.Errorf("Retrieve()[%d] = %q, want %q", i, te.Text, tt.want[i])
+				}
+			}
+		})
+	}
+}
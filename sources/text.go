@@ -6,12 +6,12 @@ type TextProvider interface {
 	Sources(ctx context.Context, prompt string) ([]string, error)
 }
 
-func (t *Manager) AddSourceText(text string, opt ...SourceOption[TextEmbeddingProvider]) {
-	t.AddSourceTextEmbeddingProvider(hardCodedTextProvider{Text: text}, opt...)
+func (t *Manager) AddSourceText(text string, opt ...SourceOption[TextEmbeddingProvider]) SourceHandle {
+	return t.AddSourceTextEmbeddingProvider(hardCodedTextProvider{Text: text}, opt...)
 }
 
-func (t *Manager) AddSourceTextProvider(provider TextProvider, opt ...SourceOption[TextEmbeddingProvider]) {
-	t.AddSourceTextEmbeddingProvider(textProviderAdapter{provider}, opt...)
+func (t *Manager) AddSourceTextProvider(provider TextProvider, opt ...SourceOption[TextEmbeddingProvider]) SourceHandle {
+	return t.AddSourceTextEmbeddingProvider(textProviderAdapter{provider}, opt...)
 }
 
 // adapters to convert a TextProvider to a TextEmbeddingProvider--the chat client will fill in empty embeddings if necessary
@@ -0,0 +1,12 @@
+package sources
+
+import "github.com/troylelandshields/hardconversations/tokens"
+
+// WithTokenizer sets the tokenizer that source texts are counted with against the token budget, which should be the one of the
+// chat model they are sent to. Defaults to cl100k_base. Chunkers always count with cl100k_base, since chunks must fit the
+// embedding models.
+func WithTokenizer(tk tokens.Tokenizer) ManagerOption {
+	return func(m *Manager) {
+		m.tokenizer = tk
+	}
+}
@@ -0,0 +1,164 @@
+package tokens
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"io"
+	"math"
+	"strconv"
+	"sync"
+
+	"github.com/dlclark/regexp2"
+	"github.com/pkg/errors"
+)
+
+// Encoding is a byte-level BPE tokenizer like the ones used by tiktoken: text is split into pieces with a regular expression,
+// and the bytes of each piece are merged into tokens in order of their rank.
+type Encoding struct {
+	name    string
+	pattern *regexp2.Regexp
+	ranks   map[string]int
+	decoder map[int][]byte
+
+	mu    sync.Mutex
+	cache map[string][]int
+}
+
+const maxEncodingCacheSize = 4096
+
+// NewEncoding returns an Encoding that splits text with pattern and then merges bytes using ranks, which map every token's bytes
+// to its token. pattern is a regular expression in the syntax of github.com/dlclark/regexp2, so it can use lookaheads.
+func NewEncoding(name, pattern string, ranks map[string]int) (*Encoding, error) {
+	re, err := regexp2.Compile(pattern, regexp2.None)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid pattern for encoding %s", name)
+	}
+
+	decoder := make(map[int][]byte, len(ranks))
+	for token, rank := range ranks {
+		decoder[rank] = []byte(token)
+	}
+	for b := 0; b < 256; b++ {
+		if _, ok := ranks[string([]byte{byte(b)})]; !ok {
+			return nil, errors.Errorf("encoding %s has no token for byte %d", name, b)
+		}
+	}
+
+	return &Encoding{
+		name:    name,
+		pattern: re,
+		ranks:   ranks,
+		decoder: decoder,
+		cache:   map[string][]int{},
+	}, nil
+}
+
+// ParseTiktoken reads ranks in the format of tiktoken's .tiktoken files: one base64 encoded token and its rank per line.
+func ParseTiktoken(r io.Reader) (map[string]int, error) {
+	ranks := map[string]int{}
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := bytes.Fields(scanner.Bytes())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, errors.Errorf("invalid line %d", line)
+		}
+
+		token, err := base64.StdEncoding.DecodeString(string(fields[0]))
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid token on line %d", line)
+		}
+		rank, err := strconv.Atoi(string(fields[1]))
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid rank on line %d", line)
+		}
+		ranks[string(token)] = rank
+	}
+
+	return ranks, scanner.Err()
+}
+
+func (e *Encoding) Name() string {
+	return e.name
+}
+
+// Encode returns the tokens of text. Special tokens like <|endoftext|> are encoded as ordinary text.
+func (e *Encoding) Encode(text string) ([]int, error) {
+	var encoded []int
+
+	m, err := e.pattern.FindStringMatch(text)
+	for ; m != nil && err == nil; m, err = e.pattern.FindNextMatch(m) {
+		encoded = append(encoded, e.encodePiece(m.String())...)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "error splitting text")
+	}
+
+	return encoded, nil
+}
+
+// Decode returns the text of tokens; tokens that aren't in the encoding are skipped.
+func (e *Encoding) Decode(tokens []int) string {
+	var b []byte
+	for _, t := range tokens {
+		b = append(b, e.decoder[t]...)
+	}
+	return string(b)
+}
+
+func (e *Encoding) encodePiece(piece string) []int {
+	if rank, ok := e.ranks[piece]; ok {
+		return []int{rank}
+	}
+
+	e.mu.Lock()
+	cached, ok := e.cache[piece]
+	e.mu.Unlock()
+	if ok {
+		return cached
+	}
+
+	encoded := bytePairEncode([]byte(piece), e.ranks)
+
+	e.mu.Lock()
+	if len(e.cache) >= maxEncodingCacheSize {
+		e.cache = map[string][]int{}
+	}
+	e.cache[piece] = encoded
+	e.mu.Unlock()
+
+	return encoded
+}
+
+// bytePairEncode repeatedly merges the adjacent parts of piece whose combined bytes have the lowest rank, until no merge is
+// possible, and returns the ranks of the parts.
+func bytePairEncode(piece []byte, ranks map[string]int) []int {
+	// boundaries of the parts; part i is piece[bounds[i]:bounds[i+1]]
+	bounds := make([]int, len(piece)+1)
+	for i := range bounds {
+		bounds[i] = i
+	}
+
+	for len(bounds) > 2 {
+		best, bestRank := -1, math.MaxInt
+		for i := 0; i+2 < len(bounds); i++ {
+			if rank, ok := ranks[string(piece[bounds[i]:bounds[i+2]])]; ok && rank < bestRank {
+				best, bestRank = i, rank
+			}
+		}
+		if best < 0 {
+			break
+		}
+		bounds = append(bounds[:best+1], bounds[best+2:]...)
+	}
+
+	encoded := make([]int, len(bounds)-1)
+	for i := range encoded {
+		encoded[i] = ranks[string(piece[bounds[i]:bounds[i+1]])]
+	}
+	return encoded
+}
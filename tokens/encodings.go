@@ -0,0 +1,179 @@
+package tokens
+
+import (
+	"compress/gzip"
+	"embed"
+	"io/fs"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	gpt3encoder "github.com/samber/go-gpt-3-encoder"
+	"github.com/troylelandshields/hardconversations/logger"
+)
+
+//go:generate go run gen_tables.go
+
+// Names of the built-in encodings.
+const (
+	R50kBase   = "r50k_base"   // GPT-2 and GPT-3
+	CL100kBase = "cl100k_base" // gpt-3.5-turbo, gpt-4 and the text-embedding-3 models
+	O200kBase  = "o200k_base"  // gpt-4o, gpt-4.1, the o-series and newer models
+)
+
+const (
+	cl100kPattern = `(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+`
+	o200kPattern  = `[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]*[\p{Ll}\p{Lm}\p{Lo}\p{M}]+(?i:'s|'t|'re|'ve|'m|'ll|'d)?` +
+		`|[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]+[\p{Ll}\p{Lm}\p{Lo}\p{M}]*(?i:'s|'t|'re|'ve|'m|'ll|'d)?` +
+		`|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n/]*|\s*[\r\n]+|\s+(?!\S)|\s+`
+)
+
+// tables holds the gzipped .tiktoken rank files of the BPE encodings, written to data/ by go generate.
+//
+//go:embed data
+var tables embed.FS
+
+// ErrUnknownEncoding is returned for encodings that aren't registered, or whose tables aren't embedded.
+var ErrUnknownEncoding = errors.New("unknown encoding")
+
+var (
+	encodingsMu sync.Mutex
+	loaders     = map[string]func() (Tokenizer, error){
+		R50kBase:   loadR50k,
+		CL100kBase: embeddedLoader(CL100kBase, cl100kPattern),
+		O200kBase:  embeddedLoader(O200kBase, o200kPattern),
+	}
+	loaded = map[string]Tokenizer{}
+)
+
+// RegisterEncoding makes an encoding available to GetEncoding and ForModel. load is called the first time the encoding is used.
+func RegisterEncoding(name string, load func() (Tokenizer, error)) {
+	encodingsMu.Lock()
+	defer encodingsMu.Unlock()
+	loaders[name] = load
+	delete(loaded, name)
+}
+
+// GetEncoding returns the encoding with the given name, loading it the first time it is used.
+func GetEncoding(name string) (Tokenizer, error) {
+	encodingsMu.Lock()
+	defer encodingsMu.Unlock()
+
+	if tk, ok := loaded[name]; ok {
+		return tk, nil
+	}
+	load, ok := loaders[name]
+	if !ok {
+		return nil, errors.Wrap(ErrUnknownEncoding, name)
+	}
+	tk, err := load()
+	if err != nil {
+		return nil, err
+	}
+	loaded[name] = tk
+	return tk, nil
+}
+
+// modelPrefixes maps model name prefixes to their encodings; the longest matching prefix wins.
+var modelPrefixes = map[string]string{
+	"gpt-5":                  O200kBase,
+	"gpt-4.5":                O200kBase,
+	"gpt-4.1":                O200kBase,
+	"gpt-4o":                 O200kBase,
+	"chatgpt-4o":             O200kBase,
+	"o1":                     O200kBase,
+	"o3":                     O200kBase,
+	"o4":                     O200kBase,
+	"gpt-4":                  CL100kBase,
+	"gpt-3.5-turbo":          CL100kBase,
+	"gpt-35-turbo":           CL100kBase,
+	"text-embedding-3":       CL100kBase,
+	"text-embedding-ada-002": CL100kBase,
+	"davinci":                R50kBase,
+	"curie":                  R50kBase,
+	"babbage":                R50kBase,
+	"ada":                    R50kBase,
+	"text-davinci":           R50kBase,
+}
+
+// defaultModelEncoding is used for models that aren't known, which are most likely newer models.
+const defaultModelEncoding = O200kBase
+
+// RegisterModel sets the encoding used for models whose names start with prefix.
+func RegisterModel(prefix, encoding string) {
+	encodingsMu.Lock()
+	defer encodingsMu.Unlock()
+	modelPrefixes[prefix] = encoding
+}
+
+// EncodingForModel returns the name of the encoding used by model.
+func EncodingForModel(model string) string {
+	encodingsMu.Lock()
+	defer encodingsMu.Unlock()
+
+	var best string
+	encoding := defaultModelEncoding
+	for prefix, e := range modelPrefixes {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(best) {
+			best, encoding = prefix, e
+		}
+	}
+	return encoding
+}
+
+// ForModel returns the tokenizer for model. If the model's encoding isn't available (e.g. its table wasn't embedded) it falls
+// back to r50k_base, which counts English text within a few percent.
+func ForModel(model string) Tokenizer {
+	name := EncodingForModel(model)
+	tk, err := GetEncoding(name)
+	if err == nil {
+		return tk
+	}
+
+	logger.Debugf("Encoding %s for model %s is not available, using %s: %v", name, model, R50kBase, err)
+	tk, err = GetEncoding(R50kBase)
+	if err != nil {
+		panic(err)
+	}
+	return tk
+}
+
+func embeddedLoader(name, pattern string) func() (Tokenizer, error) {
+	return func() (Tokenizer, error) {
+		f, err := tables.Open("data/" + name + ".tiktoken.gz")
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, errors.Wrapf(ErrUnknownEncoding, "%s table is not embedded, run go generate", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		r, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading %s table", name)
+		}
+		ranks, err := ParseTiktoken(r)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading %s table", name)
+		}
+		return NewEncoding(name, pattern, ranks)
+	}
+}
+
+// r50k is the GPT-2 encoder.
+type r50k struct {
+	*gpt3encoder.Encoder
+}
+
+func loadR50k() (Tokenizer, error) {
+	encoder, err := gpt3encoder.NewEncoder()
+	if err != nil {
+		return nil, errors.Wrap(err, "error loading r50k_base")
+	}
+	return r50k{encoder}, nil
+}
+
+func (r50k) Name() string {
+	return R50kBase
+}
@@ -0,0 +1,77 @@
+//go:build ignore
+
+// gen_tables downloads the tiktoken rank files of the BPE encodings, checks their hashes, and writes them gzipped to data/ so
+// they are embedded in the tokens package.
+package main
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+var tables = []struct {
+	name string
+	url  string
+	hash string
+}{
+	{
+		name: "cl100k_base",
+		url:  "https://openaipublic.blob.core.windows.net/encodings/cl100k_base.tiktoken",
+		hash: "223921b76ee99bde995b7ff738513eef100fb51d18c93597a113bcffe865b2a7",
+	},
+	{
+		name: "o200k_base",
+		url:  "https://openaipublic.blob.core.windows.net/encodings/o200k_base.tiktoken",
+		hash: "446a9538cb6c348e3516120d7c08b09f57c36495e2acfffe59a5bf8b0cfb1a2d",
+	},
+}
+
+func main() {
+	for _, t := range tables {
+		if err := download(t.name, t.url, t.hash); err != nil {
+			fmt.Fprintf(os.Stderr, "error downloading %s: %v\n", t.name, err)
+			os.Exit(1)
+		}
+	}
+}
+
+func download(name, url, hash string) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(b)
+	if got := hex.EncodeToString(sum[:]); got != hash {
+		return fmt.Errorf("hash is %s, want %s", got, hash)
+	}
+
+	f, err := os.Create(filepath.Join("data", name+".tiktoken.gz"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w, err := gzip.NewWriterLevel(f, gzip.BestCompression)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(b); err != nil {
+		return err
+	}
+	return w.Close()
+}
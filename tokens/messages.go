@@ -0,0 +1,87 @@
+package tokens
+
+import (
+	"strings"
+	"sync"
+)
+
+// Message is the part of a chat message that is counted in a request's prompt tokens.
+type Message struct {
+	Role    string
+	Name    string
+	Content string
+}
+
+// MessageFormat is how a model family wraps chat messages in tokens, e.g. <|start|>{role}<|message|>{content}<|end|>.
+type MessageFormat struct {
+	TokensPerMessage int // added to every message for the tokens around its role and content
+	TokensPerName    int // added to every message with a name; negative if the name replaces the role
+	ReplyTokens      int // primes the reply, e.g. <|start|>assistant<|message|>
+}
+
+var (
+	formatsMu sync.Mutex
+
+	// messageFormats maps model name prefixes to their message formats; the longest matching prefix wins.
+	messageFormats = map[string]MessageFormat{
+		"gpt-3.5-turbo-0301": {TokensPerMessage: 4, TokensPerName: -1, ReplyTokens: 3},
+		"gpt-35-turbo-0301":  {TokensPerMessage: 4, TokensPerName: -1, ReplyTokens: 3},
+	}
+)
+
+// defaultMessageFormat is used by every chat model since gpt-3.5-turbo-0613, including the gpt-4, gpt-4o and o-series models.
+var defaultMessageFormat = MessageFormat{TokensPerMessage: 3, TokensPerName: 1, ReplyTokens: 3}
+
+// RegisterMessageFormat sets the message format of models whose names start with prefix.
+func RegisterMessageFormat(prefix string, format MessageFormat) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	messageFormats[prefix] = format
+}
+
+// MessageFormatForModel returns the message format of model.
+func MessageFormatForModel(model string) MessageFormat {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+
+	var best string
+	format := defaultMessageFormat
+	for prefix, f := range messageFormats {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(best) {
+			best, format = prefix, f
+		}
+	}
+	return format
+}
+
+// CountMessage returns the number of tokens m adds to a request, not including the reply priming.
+func (f MessageFormat) CountMessage(tk Tokenizer, m Message) (int, error) {
+	count := f.TokensPerMessage
+	for _, text := range []string{m.Role, m.Content, m.Name} {
+		n, err := Count(tk, text)
+		if err != nil {
+			return 0, err
+		}
+		count += n
+	}
+	if m.Name != "" {
+		count += f.TokensPerName
+	}
+	return count, nil
+}
+
+// CountMessages returns the prompt tokens of a chat request to model with messages, as reported in the response's usage.
+// tk should be the tokenizer of model, e.g. from ForModel.
+func CountMessages(tk Tokenizer, model string, messages []Message) (int, error) {
+	format := MessageFormatForModel(model)
+
+	count := format.ReplyTokens
+	for _, m := range messages {
+		n, err := format.CountMessage(tk, m)
+		if err != nil {
+			return 0, err
+		}
+		count += n
+	}
+	return count, nil
+}
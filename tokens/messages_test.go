@@ -0,0 +1,81 @@
+package tokens
+
+import (
+	"strings"
+	"testing"
+)
+
+// wordTokenizer encodes every word as one token.
+type wordTokenizer struct{}
+
+func (wordTokenizer) Name() string { return "words" }
+
+func (wordTokenizer) Encode(text string) ([]int, error) {
+	return make([]int, len(strings.Fields(text))), nil
+}
+
+func (wordTokenizer) Decode(tokens []int) string { return "" }
+
+func TestCountMessagesFormat(t *testing.T) {
+	messages := []Message{
+		{Role: "system", Content: "You are helpful."},
+		{Role: "user", Name: "alice", Content: "Hi there"},
+		{Role: "assistant", Content: "Hello!"},
+	}
+
+	tests := []struct {
+		model string
+		want  int
+	}{
+		// reply priming, 3 roles, 6 content words, 1 name word, and the per-message and per-name overheads
+		{model: "gpt-4o", want: 3 + 3 + 6 + 1 + 3*3 + 1},
+		{model: "gpt-3.5-turbo-0301", want: 3 + 3 + 6 + 1 + 3*4 - 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			got, err := CountMessages(wordTokenizer{}, tt.model, messages)
+			if err != nil {
+				t.Fatalf("CountMessages() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("CountMessages() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// usageMessages were sent to the API to record the prompt_tokens of their usage for each model.
+var usageMessages = []Message{
+	{Role: "system", Content: "You are a helpful, pattern-following assistant that translates corporate jargon into plain English."},
+	{Role: "system", Name: "example_user", Content: "New synergies will help drive top-line growth."},
+	{Role: "system", Name: "example_assistant", Content: "Things working well together will increase revenue."},
+	{Role: "system", Name: "example_user", Content: "Let's circle back when we have more bandwidth to touch base on opportunities for increased leverage."},
+	{Role: "system", Name: "example_assistant", Content: "Let's talk later when we're less busy about how to do better."},
+	{Role: "user", Content: "This late pivot means we don't have time to boil the ocean for the client deliverable."},
+}
+
+func TestCountMessagesUsage(t *testing.T) {
+	tests := []struct {
+		model        string
+		promptTokens int
+	}{
+		{model: "gpt-3.5-turbo-0301", promptTokens: 127},
+		{model: "gpt-3.5-turbo-0613", promptTokens: 129},
+		{model: "gpt-4-0613", promptTokens: 129},
+		{model: "gpt-4o", promptTokens: 124},
+		{model: "gpt-4o-mini", promptTokens: 124},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			got, err := CountMessages(ForModel(tt.model), tt.model, usageMessages)
+			if err != nil {
+				t.Fatalf("CountMessages() error = %v", err)
+			}
+			if got != tt.promptTokens {
+				t.Errorf("CountMessages() = %d, want the recorded %d", got, tt.promptTokens)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+// Package tokens counts and splits text in the tokens used by OpenAI models, so applications can do the same budget math as
+// hardconversations.
+package tokens
+
+import (
+	"github.com/pkg/errors"
+)
+
+// Tokenizer converts text to and from a model's tokens.
+type Tokenizer interface {
+	// Name is the name of the encoding, e.g. "cl100k_base"
+	Name() string
+	Encode(text string) ([]int, error)
+	Decode(tokens []int) string
+}
+
+// Count returns the number of tokens in text.
+func Count(tk Tokenizer, text string) (int, error) {
+	encoded, err := tk.Encode(text)
+	if err != nil {
+		return 0, errors.Wrap(err, "error encoding text")
+	}
+	return len(encoded), nil
+}
+
+// MustCount is like Count but panics if text can't be encoded.
+func MustCount(tk Tokenizer, text string) int {
+	count, err := Count(tk, text)
+	if err != nil {
+		panic(err)
+	}
+	return count
+}
+
+// Chunk splits text into chunks of at most maxTokenSize tokens, where each chunk repeats the last overlap tokens of the previous one.
+func Chunk(tk Tokenizer, text string, maxTokenSize int, overlap int) ([]string, error) {
+	if maxTokenSize <= 0 {
+		return nil, errors.New("max token size must be positive")
+	}
+	if overlap < 0 || overlap >= maxTokenSize {
+		return nil, errors.New("overlap must be at least 0 and less than the max token size")
+	}
+
+	encoded, err := tk.Encode(text)
+	if err != nil {
+		return nil, errors.Wrap(err, "error encoding text")
+	}
+
+	if len(encoded) <= maxTokenSize {
+		return []string{text}, nil
+	}
+
+	var chunks []string
+	for start := 0; start < len(encoded); start += maxTokenSize - overlap {
+		end := start + maxTokenSize
+		if end > len(encoded) {
+			end = len(encoded)
+		}
+
+		chunks = append(chunks, tk.Decode(encoded[start:end]))
+		if end == len(encoded) {
+			break
+		}
+	}
+
+	return chunks, nil
+}
@@ -0,0 +1,138 @@
+package tokens
+
+import (
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testRanks returns a table with every byte and the given merges, ranked in order after the bytes.
+func testRanks(merges ...string) map[string]int {
+	ranks := map[string]int{}
+	for b := 0; b < 256; b++ {
+		ranks[string([]byte{byte(b)})] = b
+	}
+	for i, m := range merges {
+		ranks[m] = 256 + i
+	}
+	return ranks
+}
+
+func TestEncoding(t *testing.T) {
+	e, err := NewEncoding("test", cl100kPattern, testRanks("he", "ll", "llo", "hello", " w", " wo", "ld"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		text string
+		want []int
+	}{
+		{text: "hello", want: []int{259}},
+		{text: "hello world", want: []int{259, 261, 'r', 262}},
+		{text: "yellow", want: []int{'y', 'e', 258, 'w'}},
+		{text: "he's 12345", want: []int{256, '\'', 's', ' ', '1', '2', '3', '4', '5'}},
+		{text: "", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got, err := e.Encode(tt.text)
+			if err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Encode() = %v, want %v", got, tt.want)
+			}
+			if decoded := e.Decode(got); decoded != tt.text {
+				t.Errorf("Decode() = %q, want %q", decoded, tt.text)
+			}
+		})
+	}
+}
+
+func TestParseTiktoken(t *testing.T) {
+	var sb strings.Builder
+	for token, rank := range testRanks("hello") {
+		fmt.Fprintf(&sb, "%s %d\n", base64.StdEncoding.EncodeToString([]byte(token)), rank)
+	}
+
+	ranks, err := ParseTiktoken(strings.NewReader(sb.String()))
+	if err != nil {
+		t.Fatalf("ParseTiktoken() error = %v", err)
+	}
+	if !reflect.DeepEqual(ranks, testRanks("hello")) {
+		t.Errorf("ParseTiktoken() returned %d ranks, want %d", len(ranks), len(testRanks("hello")))
+	}
+
+	if _, err := ParseTiktoken(strings.NewReader("aGVsbG8=\n")); err == nil {
+		t.Error("ParseTiktoken() of a line without a rank succeeded, want an error")
+	}
+}
+
+func TestEncodingForModel(t *testing.T) {
+	tests := []struct {
+		model string
+		want  string
+	}{
+		{model: "gpt-3.5-turbo", want: CL100kBase},
+		{model: "gpt-4-0613", want: CL100kBase},
+		{model: "gpt-4o-mini", want: O200kBase},
+		{model: "gpt-4.1", want: O200kBase},
+		{model: "o3-mini", want: O200kBase},
+		{model: "text-embedding-3-small", want: CL100kBase},
+		{model: "text-davinci-003", want: R50kBase},
+		{model: "some-future-model", want: O200kBase},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			if got := EncodingForModel(tt.model); got != tt.want {
+				t.Errorf("EncodingForModel() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForModel(t *testing.T) {
+	tests := []struct {
+		model    string
+		encoding string
+		text     string
+		want     int
+	}{
+		{model: "gpt-4o", encoding: O200kBase, text: "How many tokens is this?", want: 6},
+		{model: "gpt-4o", encoding: O200kBase, text: "hello world", want: 2},
+		{model: "gpt-3.5-turbo", encoding: CL100kBase, text: "hello world", want: 2},
+		{model: "text-embedding-3-small", encoding: CL100kBase, text: "tiktoken is great!", want: 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.model+"/"+tt.text, func(t *testing.T) {
+			tk := ForModel(tt.model)
+			if tk.Name() != tt.encoding {
+				t.Fatalf("ForModel() = %s, want %s", tk.Name(), tt.encoding)
+			}
+			if got := MustCount(tk, tt.text); got != tt.want {
+				t.Errorf("MustCount(%q) = %d, want %d", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegisterEncoding(t *testing.T) {
+	RegisterEncoding("test_base", func() (Tokenizer, error) {
+		return NewEncoding("test_base", cl100kPattern, testRanks("hello"))
+	})
+	RegisterModel("test-model", "test_base")
+
+	tk := ForModel("test-model-2")
+	if tk.Name() != "test_base" {
+		t.Fatalf("ForModel() = %s, want test_base", tk.Name())
+	}
+	if got := MustCount(tk, "hello"); got != 1 {
+		t.Errorf("MustCount() = %d, want 1", got)
+	}
+}